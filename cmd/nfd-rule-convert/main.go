@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	ruleconvert "sigs.k8s.io/node-feature-discovery/pkg/nfd-rule-convert"
+	"sigs.k8s.io/node-feature-discovery/pkg/version"
+)
+
+const (
+	// ProgramName is the canonical name of this program
+	ProgramName = "nfd-rule-convert"
+)
+
+func main() {
+	flags := flag.NewFlagSet(ProgramName, flag.ExitOnError)
+
+	printVersion := flags.Bool("version", false, "Print version and exit.")
+
+	args := parseArgs(flags, os.Args[1:]...)
+
+	if *printVersion {
+		fmt.Println(ProgramName, version.Get())
+		os.Exit(0)
+	}
+
+	if err := ruleconvert.Run(args, os.Stdout, os.Stderr); err != nil {
+		klog.Exit(err)
+	}
+}
+
+func parseArgs(flags *flag.FlagSet, osArgs ...string) *ruleconvert.Args {
+	args := initFlags(flags)
+	// Inject klog flags
+	klog.InitFlags(flags)
+
+	_ = flags.Parse(osArgs)
+	if len(flags.Args()) > 0 {
+		fmt.Fprintf(flags.Output(), "unknown command line argument: %s\n", flags.Args()[0])
+		flags.Usage()
+		os.Exit(2)
+	}
+	return args
+}
+
+func initFlags(flagset *flag.FlagSet) *ruleconvert.Args {
+	args := &ruleconvert.Args{}
+
+	flagset.Var(&args.InputFiles, "input",
+		"Comma separated list of files or directories to read custom rules from. A file may contain "+
+			"nfd-worker configuration or a list of custom rules. Directories are read like the "+
+			"custom.d directory of nfd-worker.")
+	flagset.StringVar(&args.Name, "name", "converted-rules",
+		"Name of the NodeFeatureRule object to output.")
+	flagset.StringVar(&args.Output, "output", ruleconvert.OutputRules,
+		"Output format, '"+ruleconvert.OutputRules+"' for a list of rules (the format of the custom "+
+			"source) or '"+ruleconvert.OutputNodeFeatureRule+"' for a NodeFeatureRule object.")
+	flagset.BoolVar(&args.Strict, "strict", false,
+		"Exit with an error if there are semantic differences between the legacy and the converted rules.")
+
+	return args
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	ruleeval "sigs.k8s.io/node-feature-discovery/pkg/nfd-rule-eval"
+	"sigs.k8s.io/node-feature-discovery/pkg/version"
+)
+
+const (
+	// ProgramName is the canonical name of this program
+	ProgramName = "nfd-rule-eval"
+)
+
+func main() {
+	flags := flag.NewFlagSet(ProgramName, flag.ExitOnError)
+
+	printVersion := flags.Bool("version", false, "Print version and exit.")
+
+	args := parseArgs(flags, os.Args[1:]...)
+
+	if *printVersion {
+		fmt.Println(ProgramName, version.Get())
+		os.Exit(0)
+	}
+
+	if err := ruleeval.Run(args, os.Stdout, os.Stderr); err != nil {
+		klog.Exit(err)
+	}
+}
+
+func parseArgs(flags *flag.FlagSet, osArgs ...string) *ruleeval.Args {
+	args := initFlags(flags)
+	// Inject klog flags
+	klog.InitFlags(flags)
+
+	_ = flags.Parse(osArgs)
+	if len(flags.Args()) > 0 {
+		fmt.Fprintf(flags.Output(), "unknown command line argument: %s\n", flags.Args()[0])
+		flags.Usage()
+		os.Exit(2)
+	}
+	return args
+}
+
+func initFlags(flagset *flag.FlagSet) *ruleeval.Args {
+	args := &ruleeval.Args{}
+
+	flagset.StringVar(&args.FeaturesFile, "features", "",
+		"JSON or YAML file containing the features to evaluate the rules against.")
+	flagset.StringVar(&args.Output, "output", "yaml",
+		"Output format of the resulting labels and vars, 'yaml' or 'json'.")
+	flagset.Var(&args.RuleFiles, "rules",
+		"Comma separated list of files containing the rules to evaluate. A file may contain "+
+			"NodeFeatureRule objects, nfd-worker configuration or a list of custom rules.")
+	flagset.BoolVar(&args.Trace, "trace", false,
+		"Print an evaluation trace of each rule to stderr.")
+	flagset.BoolVar(&args.FailOnError, "fail-on-error", false,
+		"Exit with an error on the first rule that fails to evaluate. By default, "+
+			"failing rules are reported and skipped, like nfd-master and nfd-worker do.")
+
+	return args
+}
@@ -108,17 +108,47 @@ func initFlags(flagset *flag.FlagSet) (*worker.Args, *worker.ConfigOverrideArgs)
 		"Certificate used for authenticating connections")
 	flagset.StringVar(&args.ConfigFile, "config", "/etc/kubernetes/node-feature-discovery/nfd-worker.conf",
 		"Config file to use.")
+	flagset.StringVar(&args.ConfigMap, "config-map", "",
+		"Read the configuration from a ConfigMap ([<namespace>/]<name>) in the Kubernetes API "+
+			"instead of the config file. The namespace defaults to the value of the POD_NAMESPACE environment variable.")
+	flagset.StringVar(&args.Export, "export", "",
+		"Export mode: discover features once and write the features and labels to the given file ('-' for stdout) "+
+			"instead of sending them to nfd-master. The exported sources are selected with -feature-sources and -label-sources.")
+	flagset.StringVar(&args.ExportFormat, "export-format", worker.ExportFormatYAML,
+		"Output format of the export mode, 'json' or 'yaml'.")
+	flagset.Var(&args.ExtraLabelNs, "extra-label-ns",
+		"Comma separated list of allowed extra label namespaces. Only takes effect in standalone mode.")
+	flagset.StringVar(&args.HealthAddress, "health-address", "",
+		"Address on which to serve the health endpoints. Defaults to the value of the POD_IP environment variable, "+
+			"or localhost if it is not set.")
+	flagset.IntVar(&args.HealthPort, "health-port", 0,
+		"Port on which to serve the health endpoints, e.g. for readiness probes. Zero (the default) disables the endpoints.")
+	flagset.StringVar(&args.Instance, "instance", "",
+		"Instance name. Used to separate annotation namespaces for multiple parallel deployments. "+
+			"Only takes effect in standalone mode.")
+	flagset.IntVar(&args.IntrospectionPort, "introspection-port", 0,
+		"Port on the loopback interface on which to serve the introspection endpoints. Zero (the default) disables the endpoints.")
 	flagset.StringVar(&args.KeyFile, "key-file", "",
 		"Private key matching -cert-file")
+	flagset.StringVar(&args.Kubeconfig, "kubeconfig", "",
+		"Kubeconfig to use for accessing the Kubernetes API in standalone mode or with -config-map")
 	flagset.BoolVar(&args.Oneshot, "oneshot", false,
 		"Do not publish feature labels")
 	flagset.StringVar(&args.Options, "options", "",
 		"Specify config options from command line. Config options are specified "+
 			"in the same format as in the config file (i.e. json or yaml). These options")
+	flagset.Var(&args.ResourceLabels, "resource-labels",
+		"Comma separated list of labels to be exposed as extended resources. Only takes effect in standalone mode.")
 	flagset.StringVar(&args.Server, "server", "localhost:8080",
 		"NFD server address to connecto to.")
 	flagset.StringVar(&args.ServerNameOverride, "server-name-override", "",
 		"Hostname expected from server certificate, useful in testing")
+	flagset.BoolVar(&args.Standalone, "standalone", false,
+		"Standalone mode: evaluate NodeFeatureRules and update the node object directly, "+
+			"without connecting to nfd-master.")
+	flagset.BoolVar(&args.ValidateConfig, "validate-config", false,
+		"Validate the configuration (config file, -options and custom rule files) and exit. "+
+			"Unknown config options are treated as errors.")
 
 	initKlogFlags(flagset, args)
 
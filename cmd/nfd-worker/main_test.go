@@ -41,6 +41,10 @@ func TestParseArgs(t *testing.T) {
 				So(args.Overrides.FeatureSources, ShouldBeNil)
 				So(args.Overrides.LabelSources, ShouldBeNil)
 			})
+			Convey("health and introspection endpoints should be disabled", func() {
+				So(args.HealthPort, ShouldEqual, 0)
+				So(args.IntrospectionPort, ShouldEqual, 0)
+			})
 		})
 
 		Convey("When all override args are specified", func() {
@@ -60,6 +64,46 @@ func TestParseArgs(t *testing.T) {
 				So(args.Overrides.LabelWhiteList.Regexp.String(), ShouldResemble, ".*rdt.*")
 			})
 		})
+
+		Convey("When standalone mode args are specified", func() {
+			args := parseArgs(flags,
+				"-standalone",
+				"-instance=foo",
+				"-extra-label-ns=vendor.io",
+				"-resource-labels=feature-1,feature-2")
+
+			Convey("args are set to appropriate values", func() {
+				So(args.Standalone, ShouldBeTrue)
+				So(args.Instance, ShouldEqual, "foo")
+				So(args.ExtraLabelNs, ShouldResemble, utils.StringSetVal{"vendor.io": {}})
+				So(args.ResourceLabels, ShouldResemble, utils.StringSetVal{"feature-1": {}, "feature-2": {}})
+			})
+		})
+
+		Convey("When -config-map is specified", func() {
+			args := parseArgs(flags, "-config-map=nfd/nfd-worker-conf")
+
+			Convey("args are set to appropriate values", func() {
+				So(args.ConfigMap, ShouldEqual, "nfd/nfd-worker-conf")
+			})
+		})
+
+		Convey("When -health-address is specified", func() {
+			args := parseArgs(flags, "-health-address=10.0.0.1", "-health-port=9000")
+
+			Convey("args are set to appropriate values", func() {
+				So(args.HealthAddress, ShouldEqual, "10.0.0.1")
+				So(args.HealthPort, ShouldEqual, 9000)
+			})
+		})
+
+		Convey("When -validate-config is specified", func() {
+			args := parseArgs(flags, "-validate-config")
+
+			Convey("args are set to appropriate values", func() {
+				So(args.ValidateConfig, ShouldBeTrue)
+			})
+		})
 	})
 }
 
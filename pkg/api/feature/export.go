@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package feature
+
+import (
+	"sort"
+	"time"
+)
+
+// ExportSchema identifies the schema of features exported by nfd-worker. The
+// export is not a Kubernetes API object and the schema is versioned
+// independently of the NFD APIs. It is only changed in backwards compatible
+// ways without changing the identifier.
+const ExportSchema = "nfd-worker-export/v1alpha1"
+
+// NodeFeatureExport contains the features and labels of a node, as exported
+// by nfd-worker.
+// +protobuf=false
+type NodeFeatureExport struct {
+	// Schema identifies the schema of the export, i.e. ExportSchema.
+	Schema string `json:"schema"`
+	// NodeName is the name of the node, if known.
+	NodeName string `json:"nodeName,omitempty"`
+	// NfdVersion is the version of nfd-worker that discovered the features.
+	NfdVersion string `json:"nfdVersion"`
+	// Timestamp is the time of the feature discovery.
+	Timestamp time.Time `json:"timestamp"`
+	// Features are the raw features, arranged by feature source.
+	Features map[string]ExportDomainFeatures `json:"features"`
+	// Labels are the feature labels created by nfd-worker.
+	Labels map[string]string `json:"labels"`
+}
+
+// ExportDomainFeatures contains the exported features of one domain.
+// +protobuf=false
+type ExportDomainFeatures struct {
+	// Keys are the flag features, i.e. a sorted list of keys per feature.
+	Keys map[string][]string `json:"keys,omitempty"`
+	// Values are the attribute features, i.e. key-value pairs per feature.
+	Values map[string]map[string]string `json:"values,omitempty"`
+	// Instances are the instance features, i.e. a list of attributes of each
+	// instance per feature.
+	Instances map[string][]map[string]string `json:"instances,omitempty"`
+}
+
+// NewNodeFeatureExport creates a new NodeFeatureExport instance.
+func NewNodeFeatureExport(nodeName, nfdVersion string, features Features, labels map[string]string) *NodeFeatureExport {
+	e := &NodeFeatureExport{
+		Schema:     ExportSchema,
+		NodeName:   nodeName,
+		NfdVersion: nfdVersion,
+		Timestamp:  time.Now().UTC(),
+		Features:   make(map[string]ExportDomainFeatures, len(features)),
+		Labels:     labels,
+	}
+
+	for domain, f := range features {
+		if f == nil {
+			continue
+		}
+		d := ExportDomainFeatures{}
+		if len(f.Keys) > 0 {
+			d.Keys = make(map[string][]string, len(f.Keys))
+			for name, s := range f.Keys {
+				keys := make([]string, 0, len(s.Elements))
+				for k := range s.Elements {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				d.Keys[name] = keys
+			}
+		}
+		if len(f.Values) > 0 {
+			d.Values = make(map[string]map[string]string, len(f.Values))
+			for name, s := range f.Values {
+				d.Values[name] = s.Elements
+			}
+		}
+		if len(f.Instances) > 0 {
+			d.Instances = make(map[string][]map[string]string, len(f.Instances))
+			for name, s := range f.Instances {
+				instances := make([]map[string]string, len(s.Elements))
+				for i, e := range s.Elements {
+					instances[i] = e.Attributes
+				}
+				d.Instances[name] = instances
+			}
+		}
+		e.Features[domain] = d
+	}
+
+	return e
+}
+
+// GetFeatures converts the exported features back to Features.
+func (e *NodeFeatureExport) GetFeatures() Features {
+	features := make(Features, len(e.Features))
+	for domain, d := range e.Features {
+		f := NewDomainFeatures()
+		for name, keys := range d.Keys {
+			f.Keys[name] = NewKeyFeatures(keys...)
+		}
+		for name, values := range d.Values {
+			f.Values[name] = NewValueFeatures(values)
+		}
+		for name, instances := range d.Instances {
+			elements := make([]InstanceFeature, len(instances))
+			for i, attrs := range instances {
+				elements[i] = *NewInstanceFeature(attrs)
+			}
+			f.Instances[name] = NewInstanceFeatures(elements)
+		}
+		features[domain] = f
+	}
+	return features
+}
@@ -69,3 +69,46 @@ func InsertFeatureValues(f Features, domain, feature string, values map[string]s
 		f[domain].Values[feature].Elements[k] = v
 	}
 }
+
+// DeepCopy creates a deep copy of the features.
+func (f *DomainFeatures) DeepCopy() *DomainFeatures {
+	if f == nil {
+		return nil
+	}
+	out := NewDomainFeatures()
+	for k, v := range f.Keys {
+		var e map[string]Nil
+		if v.Elements != nil {
+			e = make(map[string]Nil, len(v.Elements))
+			for ek := range v.Elements {
+				e[ek] = Nil{}
+			}
+		}
+		out.Keys[k] = KeyFeatureSet{Elements: e}
+	}
+	for k, v := range f.Values {
+		out.Values[k] = ValueFeatureSet{Elements: copyStringMap(v.Elements)}
+	}
+	for k, v := range f.Instances {
+		var e []InstanceFeature
+		if v.Elements != nil {
+			e = make([]InstanceFeature, len(v.Elements))
+			for i, inst := range v.Elements {
+				e[i] = InstanceFeature{Attributes: copyStringMap(inst.Attributes)}
+			}
+		}
+		out.Instances[k] = InstanceFeatureSet{Elements: e}
+	}
+	return out
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var aggregateNameRe = regexp.MustCompile(`^(count|(sum|min|max)\((.+)\))$`)
+
+// MatchedAggregates holds aggregate values calculated over the matched
+// instances of an instance feature. Sum, Min and Max are indexed by attribute
+// name and only contain attributes that have a numeric value (a floating
+// point number or a resource quantity) in all of the matched instances.
+// +k8s:deepcopy-gen=false
+type MatchedAggregates struct {
+	Count int
+	Sum   map[string]string
+	Min   map[string]string
+	Max   map[string]string
+}
+
+// aggregateInstances calculates the aggregate values over a set of matched
+// instances.
+func aggregateInstances(instances []MatchedInstance) MatchedAggregates {
+	ret := MatchedAggregates{
+		Count: len(instances),
+		Sum:   make(map[string]string),
+		Min:   make(map[string]string),
+		Max:   make(map[string]string),
+	}
+	if len(instances) == 0 {
+		return ret
+	}
+
+	for name := range instances[0] {
+		var sum, min, max resource.Quantity
+		numeric := true
+		for i, inst := range instances {
+			v, ok := inst[name]
+			if !ok {
+				numeric = false
+				break
+			}
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				numeric = false
+				break
+			}
+			sum.Add(q)
+			if i == 0 || q.Cmp(min) < 0 {
+				min = q
+			}
+			if i == 0 || q.Cmp(max) > 0 {
+				max = q
+			}
+		}
+		if numeric {
+			ret.Sum[name] = sum.String()
+			ret.Min[name] = min.String()
+			ret.Max[name] = max.String()
+		}
+	}
+	return ret
+}
+
+// values returns the aggregates as a set of key-value pairs, named the same
+// way as in the MatchAggregates field of FeatureMatcherTerm.
+func (a *MatchedAggregates) values() map[string]string {
+	ret := map[string]string{"count": strconv.Itoa(a.Count)}
+	for k, v := range a.Sum {
+		ret["sum("+k+")"] = v
+	}
+	for k, v := range a.Min {
+		ret["min("+k+")"] = v
+	}
+	for k, v := range a.Max {
+		ret["max("+k+")"] = v
+	}
+	return ret
+}
+
+// matchAggregates evaluates a set of MatchExpressions against aggregate
+// values.
+func matchAggregates(m MatchExpressionSet, aggregates MatchedAggregates) (bool, error) {
+	for n := range m {
+		if !aggregateNameRe.MatchString(n) {
+			return false, fmt.Errorf("invalid aggregate %q: must be count, sum(<attribute>), min(<attribute>) or max(<attribute>)", n)
+		}
+	}
+	return m.MatchValues(aggregates.values())
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+// ExportAllVars is the special value of NodeFeatureRuleSpec.ExportVars that
+// exports all vars.
+const ExportAllVars = "*"
+
+// BackrefScopes tracks the output of evaluated rules for backreferencing, with
+// vars scoped by the NodeFeatureRule object (scope) that created them. Labels
+// and exported vars are visible to all rules, other vars only to rules of the
+// same scope.
+// +k8s:deepcopy-gen=false
+type BackrefScopes struct {
+	// shared contains labels and exported vars
+	shared map[string]string
+	// owner is the scope that created each of the shared values
+	owner map[string]string
+	// private contains the vars of each scope
+	private map[string]map[string]string
+}
+
+// NewBackrefScopes creates a new, empty, BackrefScopes instance.
+func NewBackrefScopes() *BackrefScopes {
+	return &BackrefScopes{
+		shared:  make(map[string]string),
+		owner:   make(map[string]string),
+		private: make(map[string]map[string]string),
+	}
+}
+
+// Features returns the input features for a rule of the given scope, i.e. a
+// shallow copy of features with the backreferences visible to the scope
+// injected.
+func (s *BackrefScopes) Features(features feature.Features, scope string) feature.Features {
+	ret := make(feature.Features, len(features)+1)
+	for k, v := range features {
+		ret[k] = v
+	}
+
+	matched := make(map[string]string, len(s.shared)+len(s.private[scope]))
+	for k, v := range s.shared {
+		matched[k] = v
+	}
+	for k, v := range s.private[scope] {
+		matched[k] = v
+	}
+	ret[RuleBackrefDomain] = feature.NewDomainFeatures()
+	ret[RuleBackrefDomain].Values[RuleBackrefFeature] = feature.NewValueFeatures(matched)
+
+	return ret
+}
+
+// Add records the output of a rule of the given scope. Vars listed in
+// exportVars are made visible to other scopes. An error is returned if a
+// label or exported var overrides a different value created by another scope.
+// The new value is recorded in any case.
+func (s *BackrefScopes) Add(scope string, out RuleOutput, exportVars []string) error {
+	conflicts := []string{}
+
+	addShared := func(kind, k, v string) {
+		if o, ok := s.owner[k]; ok && o != scope {
+			if s.shared[k] == v {
+				// Same value from multiple scopes is not a conflict
+				return
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s %q (value %q) overrides value %q from %q", kind, k, v, s.shared[k], o))
+		}
+		s.shared[k] = v
+		s.owner[k] = scope
+	}
+
+	for _, k := range sortedKeys(out.Labels) {
+		addShared("label", k, out.Labels[k])
+	}
+	for _, k := range sortedKeys(out.Vars) {
+		v := out.Vars[k]
+		if isExported(k, exportVars) {
+			addShared("var", k, v)
+		} else {
+			if s.private[scope] == nil {
+				s.private[scope] = make(map[string]string)
+			}
+			s.private[scope][k] = v
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting output from %q: %s", scope, strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+func isExported(name string, exportVars []string) bool {
+	for _, e := range exportVars {
+		if e == name || e == ExportAllVars {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+func TestBackrefScopes(t *testing.T) {
+	features := feature.Features{"cpu": feature.NewDomainFeatures()}
+	matched := func(f feature.Features) map[string]string {
+		return f[RuleBackrefDomain].Values[RuleBackrefFeature].Elements
+	}
+
+	s := NewBackrefScopes()
+
+	// No output yet
+	f := s.Features(features, "a")
+	assert.Equal(t, map[string]string{}, matched(f))
+	assert.Equal(t, features["cpu"], f["cpu"])
+	_, ok := features[RuleBackrefDomain]
+	assert.False(t, ok, "input features must not be modified")
+
+	// Labels are visible to all scopes, vars only when exported
+	err := s.Add("a", RuleOutput{
+		Labels: map[string]string{"label-a": "1"},
+		Vars:   map[string]string{"var-a": "1", "exported-a": "1"},
+	}, []string{"exported-a"})
+	assert.Nil(t, err)
+	err = s.Add("b", RuleOutput{
+		Vars: map[string]string{"var-b": "1", "exported-b": "1"},
+	}, []string{ExportAllVars})
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]string{"label-a": "1", "var-a": "1", "exported-a": "1", "var-b": "1", "exported-b": "1"}, matched(s.Features(features, "a")))
+	assert.Equal(t, map[string]string{"label-a": "1", "exported-a": "1", "var-b": "1", "exported-b": "1"}, matched(s.Features(features, "b")))
+	assert.Equal(t, map[string]string{"label-a": "1", "exported-a": "1", "var-b": "1", "exported-b": "1"}, matched(s.Features(features, "c")))
+
+	// Private vars of different scopes do not conflict
+	err = s.Add("c", RuleOutput{Vars: map[string]string{"var-a": "2"}}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", matched(s.Features(features, "a"))["var-a"])
+	assert.Equal(t, "2", matched(s.Features(features, "c"))["var-a"])
+
+	// Same value from another scope is not a conflict
+	err = s.Add("c", RuleOutput{Labels: map[string]string{"label-a": "1"}}, nil)
+	assert.Nil(t, err)
+
+	// Overriding labels and exported vars of another scope is a conflict
+	err = s.Add("c", RuleOutput{Labels: map[string]string{"label-a": "2"}}, nil)
+	assert.EqualError(t, err, `conflicting output from "c": label "label-a" (value "2") overrides value "1" from "a"`)
+	err = s.Add("a", RuleOutput{Vars: map[string]string{"exported-b": "2"}}, []string{"exported-b"})
+	assert.EqualError(t, err, `conflicting output from "a": var "exported-b" (value "2") overrides value "1" from "b"`)
+	assert.Equal(t, "2", matched(s.Features(features, "b"))["exported-b"])
+
+	// Overriding own values is not a conflict
+	err = s.Add("a", RuleOutput{Vars: map[string]string{"exported-b": "3"}}, []string{"exported-b"})
+	assert.Nil(t, err)
+}
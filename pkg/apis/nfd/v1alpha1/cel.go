@@ -0,0 +1,235 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/types"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+const (
+	// celKeysVar is the CEL variable holding all flag features, i.e. a map of
+	// "<domain>.<feature>" to a list of keys.
+	celKeysVar = "keys"
+	// celValuesVar is the CEL variable holding all attribute features, i.e. a
+	// map of "<domain>.<feature>" to a map of key-value pairs.
+	celValuesVar = "values"
+	// celInstancesVar is the CEL variable holding all instance features, i.e.
+	// a map of "<domain>.<feature>" to a list of attribute maps.
+	celInstancesVar = "instances"
+
+	// celCostLimit is the maximum cost of evaluating a CEL expression, both
+	// estimated when compiling the expression and tracked at runtime.
+	celCostLimit = 10000000
+	// celEvalTimeout is the maximum time evaluating a CEL expression may take.
+	celEvalTimeout = time.Second
+	// celInterruptCheckFrequency is the number of comprehension iterations
+	// after which the evaluation checks for timeout.
+	celInterruptCheckFrequency = 100
+
+	// celMaxFeatures is the estimated maximum number of features in one
+	// input variable, used for estimating the cost of CEL expressions.
+	celMaxFeatures = 256
+	// celMaxElements is the estimated maximum number of elements (keys,
+	// attributes or instances) of one feature.
+	celMaxElements = 256
+	// celMaxStringLength is the estimated maximum length of feature names,
+	// element names and attribute values.
+	celMaxStringLength = 1024
+)
+
+// celHelper holds a compiled CEL program.
+type celHelper struct {
+	program cel.Program
+}
+
+func newCELHelper(expr string) (*celHelper, error) {
+	env, err := cel.NewEnv(
+		cel.Variable(celKeysVar, cel.MapType(cel.StringType, cel.ListType(cel.StringType))),
+		cel.Variable(celValuesVar, cel.MapType(cel.StringType, cel.MapType(cel.StringType, cel.StringType))),
+		cel.Variable(celInstancesVar, cel.MapType(cel.StringType, cel.ListType(cel.MapType(cel.StringType, cel.StringType)))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid CEL expression: %w", issues.Err())
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("invalid CEL expression: must evaluate to bool")
+	}
+
+	est, err := env.EstimateCost(ast, celCostEstimator{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL expression: %w", err)
+	}
+	if est.Max > celCostLimit {
+		return nil, fmt.Errorf("invalid CEL expression: estimated cost %d exceeds the limit of %d", est.Max, celCostLimit)
+	}
+
+	program, err := env.Program(ast,
+		cel.CostLimit(celCostLimit),
+		cel.InterruptCheckFrequency(celInterruptCheckFrequency))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL expression: %w", err)
+	}
+	return &celHelper{program: program}, nil
+}
+
+// DeepCopy is a stub to augment the auto-generated code
+func (h *celHelper) DeepCopy() *celHelper {
+	if h == nil {
+		return nil
+	}
+	out := new(celHelper)
+	h.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is a stub to augment the auto-generated code
+func (h *celHelper) DeepCopyInto(out *celHelper) {
+	// HACK: just re-use the program which is stateless
+	out.program = h.program
+}
+
+// evaluate runs the CEL program against a set of features. The input
+// variables are taken from ctx if it is not nil.
+func (h *celHelper) evaluate(features feature.Features, ctx *EvalContext) (bool, error) {
+	c, cancel := context.WithTimeout(context.Background(), celEvalTimeout)
+	defer cancel()
+
+	out, _, err := h.program.ContextEval(c, ctx.celInput(features))
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate CEL expression: %w", err)
+	}
+	b, ok := out.(types.Bool)
+	if !ok {
+		return false, fmt.Errorf("CEL expression evaluated to %s, expected bool", out.Type().TypeName())
+	}
+	return bool(b), nil
+}
+
+// celCostEstimator estimates the sizes of the input variables of CEL
+// expressions.
+type celCostEstimator struct{}
+
+// EstimateSize implements the checker.CostEstimator interface.
+func (celCostEstimator) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	switch {
+	case element.Type().GetPrimitive() == exprpb.Type_STRING:
+		return &checker.SizeEstimate{Min: 0, Max: celMaxStringLength}
+	case len(element.Path()) == 1:
+		return &checker.SizeEstimate{Min: 0, Max: celMaxFeatures}
+	case len(element.Path()) > 1:
+		return &checker.SizeEstimate{Min: 0, Max: celMaxElements}
+	}
+	return nil
+}
+
+// EstimateCallCost implements the checker.CostEstimator interface.
+func (celCostEstimator) EstimateCallCost(function, overloadID string, target *checker.AstNode, args []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// celDomainInput holds the input variables of CEL expressions converted from
+// the features of one domain.
+type celDomainInput struct {
+	keys      map[string][]string
+	values    map[string]map[string]string
+	instances map[string][]map[string]string
+}
+
+// celInput converts features into the input variables of CEL expressions.
+// Domains that have already been converted in this EvalContext are re-used.
+func (c *EvalContext) celInput(features feature.Features) map[string]interface{} {
+	keys := make(map[string][]string)
+	values := make(map[string]map[string]string)
+	instances := make(map[string][]map[string]string)
+
+	for domain, domainFeatures := range features {
+		if domainFeatures == nil {
+			continue
+		}
+		in := c.celDomainInput(domain, domainFeatures)
+		for k, v := range in.keys {
+			keys[k] = v
+		}
+		for k, v := range in.values {
+			values[k] = v
+		}
+		for k, v := range in.instances {
+			instances[k] = v
+		}
+	}
+
+	return map[string]interface{}{
+		celKeysVar:      keys,
+		celValuesVar:    values,
+		celInstancesVar: instances,
+	}
+}
+
+// celDomainInput returns the CEL input of one feature domain, converting it
+// only if it is not found in the EvalContext.
+func (c *EvalContext) celDomainInput(domain string, domainFeatures *feature.DomainFeatures) *celDomainInput {
+	if c != nil {
+		if in, ok := c.celDomains[domainFeatures]; ok {
+			return in
+		}
+	}
+
+	in := &celDomainInput{
+		keys:      make(map[string][]string, len(domainFeatures.Keys)),
+		values:    make(map[string]map[string]string, len(domainFeatures.Values)),
+		instances: make(map[string][]map[string]string, len(domainFeatures.Instances)),
+	}
+	for name, f := range domainFeatures.Keys {
+		k := make([]string, 0, len(f.Elements))
+		for e := range f.Elements {
+			k = append(k, e)
+		}
+		sort.Strings(k)
+		in.keys[domain+"."+name] = k
+	}
+	for name, f := range domainFeatures.Values {
+		in.values[domain+"."+name] = f.Elements
+	}
+	for name, f := range domainFeatures.Instances {
+		i := make([]map[string]string, len(f.Elements))
+		for n, e := range f.Elements {
+			i[n] = e.Attributes
+		}
+		in.instances[domain+"."+name] = i
+	}
+
+	// Back-references are different for every rule, don't cache them
+	if c != nil && domain != RuleBackrefDomain {
+		c.celDomains[domainFeatures] = in
+	}
+	return in
+}
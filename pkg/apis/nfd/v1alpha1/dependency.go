@@ -0,0 +1,202 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OrderRules determines the evaluation order of a list of rules, based on the
+// dependencies declared in their DependsOn and DependsOnVars fields. The
+// returned order consists of indices to the rules slice. Rules are evaluated
+// in the order they were given, except that a rule is postponed until all of
+// the rules it depends on have been evaluated.
+//
+// Rules with missing dependencies, rules that are part of a dependency cycle
+// and rules depending on any of those are left out of the returned order. In
+// this case an error describing the problems is returned, together with the
+// order of the rules that can be evaluated.
+func OrderRules(rules []*Rule) ([]int, error) {
+	n := len(rules)
+	errs := []string{}
+
+	// Index rules by name and by the vars they produce
+	byName := make(map[string][]int, n)
+	byVar := make(map[string][]int)
+	for i, r := range rules {
+		byName[r.Name] = append(byName[r.Name], i)
+		for v := range r.Vars {
+			byVar[v] = append(byVar[v], i)
+		}
+		for v := range r.VarsOnNoMatch {
+			if _, ok := r.Vars[v]; !ok {
+				byVar[v] = append(byVar[v], i)
+			}
+		}
+		if r.Score != nil && r.Score.Var != "" {
+			if _, ok := r.Vars[r.Score.Var]; !ok {
+				byVar[r.Score.Var] = append(byVar[r.Score.Var], i)
+			}
+		}
+	}
+
+	// Resolve dependencies
+	deps := make([][]int, n)
+	invalid := make([]bool, n)
+	for i, r := range rules {
+		for _, name := range r.DependsOn {
+			if d, ok := byName[name]; ok {
+				deps[i] = append(deps[i], d...)
+			} else {
+				errs = append(errs, fmt.Sprintf("rule %q depends on non-existent rule %q", r.Name, name))
+				invalid[i] = true
+			}
+		}
+		for _, name := range r.DependsOnVars {
+			if d, ok := byVar[name]; ok {
+				deps[i] = append(deps[i], d...)
+			} else {
+				errs = append(errs, fmt.Sprintf("rule %q depends on var %q that no rule specifies", r.Name, name))
+				invalid[i] = true
+			}
+		}
+	}
+
+	// Pick the first rule whose dependencies have all been evaluated until
+	// none is left
+	done := make([]bool, n)
+	order := make([]int, 0, n)
+	ready := func(i int) bool {
+		for _, d := range deps[i] {
+			if !done[d] {
+				return false
+			}
+		}
+		return true
+	}
+	for progress := true; progress; {
+		progress = false
+		for i := range rules {
+			if !done[i] && !invalid[i] && ready(i) {
+				done[i] = true
+				order = append(order, i)
+				progress = true
+				break
+			}
+		}
+	}
+
+	if len(order) == n {
+		return order, nil
+	}
+
+	// Diagnose the rules that could not be ordered
+	remaining := []int{}
+	for i := range rules {
+		if !done[i] && !invalid[i] {
+			remaining = append(remaining, i)
+		}
+	}
+	inCycle := make([]bool, n)
+	for _, c := range dependencyCycles(deps, remaining) {
+		names := make([]string, len(c))
+		for j, i := range c {
+			names[j] = fmt.Sprintf("%q", rules[i].Name)
+			inCycle[i] = true
+		}
+		errs = append(errs, "dependency cycle between rules "+strings.Join(names, ", "))
+	}
+	for _, i := range remaining {
+		if !inCycle[i] {
+			errs = append(errs, fmt.Sprintf("rule %q depends on rules that cannot be evaluated", rules[i].Name))
+		}
+	}
+
+	return order, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// dependencyCycles finds the cycles in a dependency graph restricted to the
+// given nodes, i.e. its strongly connected components consisting of more than
+// one node or of one node depending on itself. The nodes of each cycle are
+// returned in ascending order.
+func dependencyCycles(deps [][]int, nodes []int) [][]int {
+	member := make(map[int]bool, len(nodes))
+	for _, i := range nodes {
+		member[i] = true
+	}
+
+	// Tarjan's algorithm
+	index := make(map[int]int, len(nodes))
+	lowlink := make(map[int]int, len(nodes))
+	onStack := make(map[int]bool, len(nodes))
+	stack := []int{}
+	cycles := [][]int{}
+
+	var visit func(i int)
+	visit = func(i int) {
+		index[i] = len(index)
+		lowlink[i] = index[i]
+		stack = append(stack, i)
+		onStack[i] = true
+
+		selfLoop := false
+		for _, d := range deps[i] {
+			if !member[d] {
+				continue
+			}
+			if d == i {
+				selfLoop = true
+			}
+			if _, ok := index[d]; !ok {
+				visit(d)
+				if lowlink[d] < lowlink[i] {
+					lowlink[i] = lowlink[d]
+				}
+			} else if onStack[d] && index[d] < lowlink[i] {
+				lowlink[i] = index[d]
+			}
+		}
+
+		if lowlink[i] == index[i] {
+			scc := []int{}
+			for {
+				j := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[j] = false
+				scc = append(scc, j)
+				if j == i {
+					break
+				}
+			}
+			if len(scc) > 1 || selfLoop {
+				sort.Ints(scc)
+				cycles = append(cycles, scc)
+			}
+		}
+	}
+
+	for _, i := range nodes {
+		if _, ok := index[i]; !ok {
+			visit(i)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderRules(t *testing.T) {
+	type TC struct {
+		name  string
+		rules []*Rule
+		order []int
+		err   string
+	}
+
+	tcs := []TC{
+		{
+			name:  "no rules",
+			rules: []*Rule{},
+			order: []int{},
+		},
+		{
+			name:  "no dependencies",
+			rules: []*Rule{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			order: []int{0, 1, 2},
+		},
+		{
+			name: "rule dependencies",
+			rules: []*Rule{
+				{Name: "a", DependsOn: []string{"c"}},
+				{Name: "b"},
+				{Name: "c", DependsOn: []string{"d"}},
+				{Name: "d"},
+			},
+			order: []int{1, 3, 2, 0},
+		},
+		{
+			name: "var dependencies",
+			rules: []*Rule{
+				{Name: "a", DependsOnVars: []string{"x"}},
+				{Name: "b", Vars: map[string]string{"x": "1"}},
+				{Name: "c", Vars: map[string]string{"x": "2"}},
+			},
+			order: []int{1, 2, 0},
+		},
+		{
+			name: "duplicate rule names",
+			rules: []*Rule{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b"},
+				{Name: "b"},
+			},
+			order: []int{1, 2, 0},
+		},
+		{
+			name: "missing dependencies",
+			rules: []*Rule{
+				{Name: "a", DependsOn: []string{"x"}},
+				{Name: "b", DependsOnVars: []string{"y"}},
+				{Name: "c", DependsOn: []string{"a"}},
+				{Name: "d"},
+			},
+			order: []int{3},
+			err:   `rule "a" depends on non-existent rule "x"; rule "b" depends on var "y" that no rule specifies; rule "c" depends on rules that cannot be evaluated`,
+		},
+		{
+			name: "cycles",
+			rules: []*Rule{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOnVars: []string{"x"}},
+				{Name: "c", DependsOn: []string{"a"}, Vars: map[string]string{"x": "1"}},
+				{Name: "d", DependsOn: []string{"d"}},
+				{Name: "e", DependsOn: []string{"a"}},
+				{Name: "f"},
+			},
+			order: []int{5},
+			err:   `dependency cycle between rules "a", "b", "c"; dependency cycle between rules "d"; rule "e" depends on rules that cannot be evaluated`,
+		},
+	}
+
+	for _, tc := range tcs {
+		order, err := OrderRules(tc.rules)
+		assert.Equal(t, tc.order, order, "test case %q failed", tc.name)
+		if tc.err == "" {
+			assert.Nilf(t, err, "test case %q failed", tc.name)
+		} else {
+			assert.EqualErrorf(t, err, tc.err, "test case %q failed", tc.name)
+		}
+	}
+}
@@ -24,23 +24,32 @@ import (
 	"strconv"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 )
 
 var matchOps = map[MatchOp]struct{}{
-	MatchAny:          struct{}{},
-	MatchIn:           struct{}{},
-	MatchNotIn:        struct{}{},
-	MatchInRegexp:     struct{}{},
-	MatchExists:       struct{}{},
-	MatchDoesNotExist: struct{}{},
-	MatchGt:           struct{}{},
-	MatchLt:           struct{}{},
-	MatchGtLt:         struct{}{},
-	MatchIsTrue:       struct{}{},
-	MatchIsFalse:      struct{}{},
+	MatchAny:            struct{}{},
+	MatchIn:             struct{}{},
+	MatchNotIn:          struct{}{},
+	MatchInRegexp:       struct{}{},
+	MatchExists:         struct{}{},
+	MatchDoesNotExist:   struct{}{},
+	MatchGt:             struct{}{},
+	MatchLt:             struct{}{},
+	MatchGtLt:           struct{}{},
+	MatchIsTrue:         struct{}{},
+	MatchIsFalse:        struct{}{},
+	MatchVersionGt:      struct{}{},
+	MatchVersionGe:      struct{}{},
+	MatchVersionLt:      struct{}{},
+	MatchVersionLe:      struct{}{},
+	MatchVersionInRange: struct{}{},
+	MatchQuantityGt:     struct{}{},
+	MatchQuantityLt:     struct{}{},
+	MatchQuantityGtLt:   struct{}{},
 }
 
 type valueRegexpCache []*regexp.Regexp
@@ -103,6 +112,45 @@ func (m *MatchExpression) Validate() error {
 		if v[0] >= v[1] {
 			return fmt.Errorf("Value[0] must be less than Value[1] for Op %q (have %v)", m.Op, m.Value)
 		}
+	case MatchVersionGt, MatchVersionGe, MatchVersionLt, MatchVersionLe:
+		if len(m.Value) != 1 {
+			return fmt.Errorf("Value must contain exactly one element for Op %q (have %v)", m.Op, m.Value)
+		}
+		if _, err := parseVersion(m.Value[0]); err != nil {
+			return fmt.Errorf("Value must be a valid version for Op %q: %w", m.Op, err)
+		}
+	case MatchVersionInRange:
+		if len(m.Value) != 2 {
+			return fmt.Errorf("Value must contain exactly two elements for Op %q (have %v)", m.Op, m.Value)
+		}
+		c, err := compareVersions(m.Value[0], m.Value[1])
+		if err != nil {
+			return fmt.Errorf("Value must contain valid versions for Op %q: %w", m.Op, err)
+		}
+		if c >= 0 {
+			return fmt.Errorf("Value[0] must be less than Value[1] for Op %q (have %v)", m.Op, m.Value)
+		}
+	case MatchQuantityGt, MatchQuantityLt:
+		if len(m.Value) != 1 {
+			return fmt.Errorf("Value must contain exactly one element for Op %q (have %v)", m.Op, m.Value)
+		}
+		if _, err := resource.ParseQuantity(m.Value[0]); err != nil {
+			return fmt.Errorf("Value must be a number or a quantity for Op %q (have %v)", m.Op, m.Value[0])
+		}
+	case MatchQuantityGtLt:
+		if len(m.Value) != 2 {
+			return fmt.Errorf("Value must contain exactly two elements for Op %q (have %v)", m.Op, m.Value)
+		}
+		v := make([]resource.Quantity, 2)
+		for i := 0; i < 2; i++ {
+			var err error
+			if v[i], err = resource.ParseQuantity(m.Value[i]); err != nil {
+				return fmt.Errorf("Value must contain numbers or quantities for Op %q (have %v)", m.Op, m.Value)
+			}
+		}
+		if v[0].Cmp(v[1]) >= 0 {
+			return fmt.Errorf("Value[0] must be less than Value[1] for Op %q (have %v)", m.Op, m.Value)
+		}
 	case MatchInRegexp:
 		if len(m.Value) == 0 {
 			return fmt.Errorf("Value must be non-empty for Op %q", m.Op)
@@ -185,6 +233,64 @@ func (m *MatchExpression) Match(valid bool, value interface{}) (bool, error) {
 				}
 			}
 			return v > lr[0] && v < lr[1], nil
+		case MatchVersionGt, MatchVersionGe, MatchVersionLt, MatchVersionLe:
+			if len(m.Value) != 1 {
+				return false, fmt.Errorf("invalid expression, 'value' field must contain exactly one element for Op %q (have %v)", m.Op, m.Value)
+			}
+			c, err := compareVersions(value, m.Value[0])
+			if err != nil {
+				return false, err
+			}
+			switch m.Op {
+			case MatchVersionGt:
+				return c > 0, nil
+			case MatchVersionGe:
+				return c >= 0, nil
+			case MatchVersionLt:
+				return c < 0, nil
+			default:
+				return c <= 0, nil
+			}
+		case MatchVersionInRange:
+			if len(m.Value) != 2 {
+				return false, fmt.Errorf("invalid expression, 'value' field must contain exactly two elements for Op %q (have %v)", m.Op, m.Value)
+			}
+			lower, err := compareVersions(value, m.Value[0])
+			if err != nil {
+				return false, err
+			}
+			upper, err := compareVersions(value, m.Value[1])
+			if err != nil {
+				return false, err
+			}
+			return lower >= 0 && upper < 0, nil
+		case MatchQuantityGt, MatchQuantityLt:
+			l, err := resource.ParseQuantity(value)
+			if err != nil {
+				return false, fmt.Errorf("not a number or quantity %q", value)
+			}
+			r, err := resource.ParseQuantity(m.Value[0])
+			if err != nil {
+				return false, fmt.Errorf("not a number or quantity %q in %v", m.Value[0], m)
+			}
+
+			c := l.Cmp(r)
+			if (c < 0 && m.Op == MatchQuantityLt) || (c > 0 && m.Op == MatchQuantityGt) {
+				return true, nil
+			}
+		case MatchQuantityGtLt:
+			v, err := resource.ParseQuantity(value)
+			if err != nil {
+				return false, fmt.Errorf("not a number or quantity %q", value)
+			}
+			lr := make([]resource.Quantity, 2)
+			for i := 0; i < 2; i++ {
+				lr[i], err = resource.ParseQuantity(m.Value[i])
+				if err != nil {
+					return false, fmt.Errorf("not a number or quantity %q in %v", m.Value[i], m)
+				}
+			}
+			return v.Cmp(lr[0]) > 0 && v.Cmp(lr[1]) < 0, nil
 		case MatchIsTrue:
 			return value == "true", nil
 		case MatchIsFalse:
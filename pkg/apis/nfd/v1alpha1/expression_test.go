@@ -85,6 +85,47 @@ func TestCreateMatchExpression(t *testing.T) {
 
 		{op: api.MatchIsFalse, err: assert.Nilf},
 		{op: api.MatchIsFalse, values: V{"1", "2"}, err: assert.NotNilf},
+
+		{op: api.MatchVersionGt, err: assert.NotNilf},
+		{op: api.MatchVersionGt, values: V{"1.2.3"}, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"v1.24.0-rc.1"}, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"1", "2"}, err: assert.NotNilf},
+		{op: api.MatchVersionGt, values: V{"el8"}, err: assert.NotNilf},
+
+		{op: api.MatchVersionGe, values: V{"5.15"}, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{""}, err: assert.NotNilf},
+
+		{op: api.MatchVersionLt, values: V{"4.18.0-372.9.1.el8"}, err: assert.Nilf},
+		{op: api.MatchVersionLt, values: V{"a"}, err: assert.NotNilf},
+
+		{op: api.MatchVersionLe, values: V{"20.04"}, err: assert.Nilf},
+		{op: api.MatchVersionLe, err: assert.NotNilf},
+
+		{op: api.MatchVersionInRange, err: assert.NotNilf},
+		{op: api.MatchVersionInRange, values: V{"1.2"}, err: assert.NotNilf},
+		{op: api.MatchVersionInRange, values: V{"1.2", "1.10"}, err: assert.Nilf},
+		{op: api.MatchVersionInRange, values: V{"1.10", "1.2"}, err: assert.NotNilf},
+		{op: api.MatchVersionInRange, values: V{"1.2", "1.2"}, err: assert.NotNilf},
+		{op: api.MatchVersionInRange, values: V{"1.2", "x"}, err: assert.NotNilf},
+
+		{op: api.MatchQuantityGt, err: assert.NotNilf},
+		{op: api.MatchQuantityGt, values: V{"1"}, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"2.5"}, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"16Gi"}, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"-1.5e3"}, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"1", "2"}, err: assert.NotNilf},
+		{op: api.MatchQuantityGt, values: V{"2.5GHz"}, err: assert.NotNilf},
+
+		{op: api.MatchQuantityLt, err: assert.NotNilf},
+		{op: api.MatchQuantityLt, values: V{"500m"}, err: assert.Nilf},
+		{op: api.MatchQuantityLt, values: V{""}, err: assert.NotNilf},
+
+		{op: api.MatchQuantityGtLt, err: assert.NotNilf},
+		{op: api.MatchQuantityGtLt, values: V{"1.1"}, err: assert.NotNilf},
+		{op: api.MatchQuantityGtLt, values: V{"1.1", "2"}, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"1G", "1Gi"}, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"1024Mi", "1Gi"}, err: assert.NotNilf},
+		{op: api.MatchQuantityGtLt, values: V{"1", "a"}, err: assert.NotNilf},
 	}
 
 	for i, tc := range tcs {
@@ -154,6 +195,54 @@ func TestMatch(t *testing.T) {
 		{op: api.MatchIsFalse, input: "false", valid: false, result: assert.Falsef, err: assert.Nilf},
 		{op: api.MatchIsFalse, input: "false", valid: true, result: assert.Truef, err: assert.Nilf},
 		{op: api.MatchIsFalse, input: "true", valid: true, result: assert.Falsef, err: assert.Nilf},
+
+		{op: api.MatchVersionGt, values: V{"5.4"}, input: "5.15.0", valid: false, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"5.4"}, input: "5.15.0", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"5.15.0"}, input: "5.15.0-56-generic", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"5.15.0"}, input: "5.15.0", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"1.24.0"}, input: "v1.24.0-rc.1", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{"1.25.0"}, input: "1.25.0-rc.1", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{"1.25.0-rc.1"}, input: "1.25.0", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"1.24.0"}, input: "v1.24.0~rc.1", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionGt, values: V{"1.2"}, input: "not-a-version", valid: true, result: assert.Falsef, err: assert.NotNilf},
+
+		{op: api.MatchVersionGe, values: V{"4.18.0-372"}, input: "4.18.0-372.9.1.el8.x86_64", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{"4.18.0-372.9.1"}, input: "4.18.0-372.9.1.el8.x86_64", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{"20.04"}, input: "20.04", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionGe, values: V{"20.04"}, input: "18.04", valid: true, result: assert.Falsef, err: assert.Nilf},
+
+		{op: api.MatchVersionLt, values: V{"1.10"}, input: "1.9.5", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionLt, values: V{"1.0"}, input: "1.0~rc1", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionLt, values: V{"1.0"}, input: "1.0", valid: true, result: assert.Falsef, err: assert.Nilf},
+
+		{op: api.MatchVersionLe, values: V{"1.0"}, input: "1.0.0", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionLe, values: V{"1.0"}, input: "1.0.1", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionLe, values: V{"1.0.0"}, input: "v1.0.0", valid: true, result: assert.Truef, err: assert.Nilf},
+
+		{op: api.MatchVersionInRange, values: V{"5.4", "5.15"}, input: "5.4", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionInRange, values: V{"5.4", "5.15"}, input: "5.10.0-19-amd64", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchVersionInRange, values: V{"5.4", "5.15"}, input: "5.15.0-56-generic", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionInRange, values: V{"5.4", "5.15"}, input: "4.19", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchVersionInRange, values: V{"5.4", "5.15"}, input: "5.5", valid: false, result: assert.Falsef, err: assert.Nilf},
+
+		{op: api.MatchQuantityGt, values: V{"2.5"}, input: "3.1", valid: false, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"2.5"}, input: "3.1", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"2.5"}, input: 2.5, valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"1.1"}, input: "1.10", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"16Gi"}, input: "32Gi", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"16Gi"}, input: "17179869184", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"1Ti"}, input: "1100G", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityGt, values: V{"2"}, input: "2.5GHz", valid: true, result: assert.Falsef, err: assert.NotNilf},
+
+		{op: api.MatchQuantityLt, values: V{"1"}, input: "500m", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityLt, values: V{"-1"}, input: -1.5, valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityLt, values: V{"1e3"}, input: "1k", valid: true, result: assert.Falsef, err: assert.Nilf},
+
+		{op: api.MatchQuantityGtLt, values: V{"1.5", "2.5"}, input: "2", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"1.5", "2.5"}, input: "2.5", valid: true, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"8Gi", "64Gi"}, input: "16Gi", valid: true, result: assert.Truef, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"8Gi", "64Gi"}, input: "16Gi", valid: false, result: assert.Falsef, err: assert.Nilf},
+		{op: api.MatchQuantityGtLt, values: V{"8Gi", "64Gi"}, input: "lots", valid: true, result: assert.Falsef, err: assert.NotNilf},
 	}
 
 	for i, tc := range tcs {
@@ -169,6 +258,10 @@ func TestMatch(t *testing.T) {
 		{op: api.MatchGt, values: V{"3.0"}, input: 1, valid: true},
 		{op: api.MatchLt, values: V{"0x2"}, input: 1, valid: true},
 		{op: api.MatchGtLt, values: V{"1", "str"}, input: 1, valid: true},
+		{op: api.MatchVersionGt, values: V{"x"}, input: "1.0", valid: true},
+		{op: api.MatchVersionInRange, values: V{"1.0"}, input: "1.0", valid: true},
+		{op: api.MatchQuantityGt, values: V{"2.5GHz"}, input: 1, valid: true},
+		{op: api.MatchQuantityGtLt, values: V{"1", "str"}, input: 1, valid: true},
 		{op: "non-existent-op", values: V{"1"}, input: 1, valid: true},
 	}
 
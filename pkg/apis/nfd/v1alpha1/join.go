@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+// joinInstances joins a set of instances with the instances of other instance
+// features. Each instance is combined with every instance of the joined
+// feature having the same value of the join key, attributes of the joined
+// instance being prefixed with joinPrefix of the joined feature. Instances that
+// have no counterpart are dropped. Multiple joins are applied in order so
+// that a join key may be an attribute added by a preceding join.
+func joinInstances(features map[string]*feature.DomainFeatures, instances []feature.InstanceFeature, joins []FeatureJoin) ([]feature.InstanceFeature, error) {
+	for _, j := range joins {
+		split := strings.SplitN(j.Feature, ".", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid join feature %q: must be <domain>.<feature>", j.Feature)
+		}
+		domain := split[0]
+		featureName := strings.ToLower(split[1])
+
+		domainFeatures, ok := features[domain]
+		if !ok {
+			return nil, fmt.Errorf("invalid join feature %q: unknown feature source/domain %q", j.Feature, domain)
+		}
+		joined, ok := domainFeatures.Instances[featureName]
+		if !ok {
+			return nil, fmt.Errorf("invalid join feature %q: not an instance feature", j.Feature)
+		}
+		if j.Attribute == "" {
+			return nil, fmt.Errorf("invalid join with %q: attribute must be specified", j.Feature)
+		}
+
+		joinAttr := j.JoinAttribute
+		if joinAttr == "" {
+			joinAttr = j.Attribute
+		}
+		prefix := joinPrefix(domain, featureName)
+
+		// Index the joined instances by the join key
+		byKey := make(map[string][]feature.InstanceFeature)
+		for _, e := range joined.Elements {
+			if k, ok := e.Attributes[joinAttr]; ok {
+				byKey[k] = append(byKey[k], e)
+			}
+		}
+
+		ret := make([]feature.InstanceFeature, 0, len(instances))
+		for _, i := range instances {
+			k, ok := i.Attributes[j.Attribute]
+			if !ok {
+				continue
+			}
+			for _, e := range byKey[k] {
+				attrs := make(map[string]string, len(i.Attributes)+len(e.Attributes))
+				for n, v := range i.Attributes {
+					attrs[n] = v
+				}
+				for n, v := range e.Attributes {
+					attrs[prefix+n] = v
+				}
+				ret = append(ret, *feature.NewInstanceFeature(attrs))
+			}
+		}
+		instances = ret
+	}
+	return instances, nil
+}
+
+// joinPrefix returns the prefix of the attributes of instances joined from the
+// given feature, i.e. "<domain>_<feature>_". The prefix contains no dots so
+// that joined attributes can be referenced as plain fields in templates, e.g.
+// {{ .pci_device_vendor }}.
+func joinPrefix(domain, featureName string) string {
+	return strings.ReplaceAll(domain+"_"+featureName+"_", ".", "_")
+}
+
+// withInstances returns a shallow copy of domain features where the named
+// instance feature is replaced by the given instances.
+func withInstances(f *feature.DomainFeatures, featureName string, instances []feature.InstanceFeature) *feature.DomainFeatures {
+	ret := *f
+	ret.Instances = make(map[string]feature.InstanceFeatureSet, len(f.Instances))
+	for k, v := range f.Instances {
+		ret.Instances[k] = v
+	}
+	ret.Instances[featureName] = feature.NewInstanceFeatures(instances)
+	return &ret
+}
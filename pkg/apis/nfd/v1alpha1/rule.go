@@ -35,56 +35,81 @@ type RuleOutput struct {
 	Vars   map[string]string
 }
 
+// EvalContext holds data shared between the evaluations of multiple rules
+// against the same features, e.g. all rules evaluated for one node. The
+// input of CEL expressions is built only once per feature domain, which are
+// identified by their address: the features must not be modified while the
+// EvalContext is in use.
+// +k8s:deepcopy-gen=false
+type EvalContext struct {
+	celDomains map[*feature.DomainFeatures]*celDomainInput
+}
+
+// NewEvalContext creates a new, empty, EvalContext.
+func NewEvalContext() *EvalContext {
+	return &EvalContext{celDomains: make(map[*feature.DomainFeatures]*celDomainInput)}
+}
+
 // Execute the rule against a set of input features.
 func (r *Rule) Execute(features feature.Features) (RuleOutput, error) {
+	return r.execute(features, nil, nil)
+}
+
+// Execute the rule against a set of input features within the EvalContext.
+func (c *EvalContext) Execute(r *Rule, features feature.Features) (RuleOutput, error) {
+	return r.execute(features, nil, c)
+}
+
+// execute the rule, recording the evaluation in trace if it is not nil.
+func (r *Rule) execute(features feature.Features, trace *RuleTrace, ctx *EvalContext) (RuleOutput, error) {
 	labels := make(map[string]string)
 	vars := make(map[string]string)
 
-	if len(r.MatchAny) > 0 {
-		// Logical OR over the matchAny matchers
-		matched := false
-		for _, matcher := range r.MatchAny {
-			if isMatch, matches, err := matcher.match(features); err != nil {
-				return RuleOutput{}, err
-			} else if isMatch {
-				matched = true
-				utils.KlogDump(4, "matches for matchAny "+r.Name, "  ", matches)
+	// There's no need to evaluate all branches of MatchAny matchers if there
+	// are no templates to be executed on them
+	needAll := r.LabelsTemplate != "" || r.VarsTemplate != ""
 
-				if r.LabelsTemplate == "" && r.VarsTemplate == "" {
-					// there's no need to evaluate other matchers in MatchAny
-					// if there are no templates to be executed on them - so
-					// short-circuit and stop on first match here
-					break
-				}
-
-				if err := r.executeLabelsTemplate(matches, labels); err != nil {
-					return RuleOutput{}, err
-				}
-				if err := r.executeVarsTemplate(matches, vars); err != nil {
-					return RuleOutput{}, err
-				}
-			}
-		}
-		if !matched {
-			klog.V(2).Infof("rule %q did not match", r.Name)
-			return RuleOutput{}, nil
-		}
+	isMatch, matches, err := matchNested(features, r.MatchAll, r.MatchAny, r.MatchNone, needAll, trace, "")
+	if err != nil {
+		return RuleOutput{}, err
+	} else if !isMatch {
+		return r.noMatchOutput(), nil
 	}
 
 	if len(r.MatchFeatures) > 0 {
-		if isMatch, matches, err := r.MatchFeatures.match(features); err != nil {
+		if isMatch, m, err := r.MatchFeatures.match(features, trace, "matchFeatures"); err != nil {
 			return RuleOutput{}, err
 		} else if !isMatch {
-			klog.V(2).Infof("rule %q did not match", r.Name)
-			return RuleOutput{}, nil
+			return r.noMatchOutput(), nil
 		} else {
-			utils.KlogDump(4, "matches for matchFeatures "+r.Name, "  ", matches)
-			if err := r.executeLabelsTemplate(matches, labels); err != nil {
-				return RuleOutput{}, err
-			}
-			if err := r.executeVarsTemplate(matches, vars); err != nil {
-				return RuleOutput{}, err
-			}
+			matches = append(matches, m)
+		}
+	}
+
+	if r.MatchCEL != "" {
+		isMatch, err := r.evaluateCEL(features, ctx)
+		trace.setCEL(r.MatchCEL, isMatch, err)
+		if err != nil {
+			return RuleOutput{}, err
+		} else if !isMatch {
+			return r.noMatchOutput(), nil
+		}
+	}
+
+	// Templates are executed separately against each matched MatchFeatures
+	for _, m := range matches {
+		utils.KlogDump(4, "matches for rule "+r.Name, "  ", m.domains)
+		if err := r.executeLabelsTemplate(m, labels); err != nil {
+			return RuleOutput{}, err
+		}
+		if err := r.executeVarsTemplate(m, vars); err != nil {
+			return RuleOutput{}, err
+		}
+	}
+
+	if r.Score != nil {
+		if err := r.Score.execute(features, trace, labels, vars); err != nil {
+			return RuleOutput{}, err
 		}
 	}
 
@@ -96,11 +121,43 @@ func (r *Rule) Execute(features feature.Features) (RuleOutput, error) {
 	}
 
 	ret := RuleOutput{Labels: labels, Vars: vars}
+	trace.setMatched(true)
 	utils.KlogDump(2, fmt.Sprintf("rule %q matched with: ", r.Name), "  ", ret)
 
 	return ret, nil
 }
 
+// noMatchOutput returns the output of the rule when it did not match.
+func (r *Rule) noMatchOutput() RuleOutput {
+	klog.V(2).Infof("rule %q did not match", r.Name)
+
+	if len(r.LabelsOnNoMatch) == 0 && len(r.VarsOnNoMatch) == 0 {
+		return RuleOutput{}
+	}
+
+	ret := RuleOutput{Labels: make(map[string]string), Vars: make(map[string]string)}
+	for k, v := range r.LabelsOnNoMatch {
+		ret.Labels[k] = v
+	}
+	for k, v := range r.VarsOnNoMatch {
+		ret.Vars[k] = v
+	}
+	utils.KlogDump(2, fmt.Sprintf("rule %q did not match, output: ", r.Name), "  ", ret)
+
+	return ret
+}
+
+func (r *Rule) evaluateCEL(features feature.Features, ctx *EvalContext) (bool, error) {
+	if r.celProgram == nil {
+		h, err := newCELHelper(r.MatchCEL)
+		if err != nil {
+			return false, fmt.Errorf("failed to compile MatchCEL: %w", err)
+		}
+		r.celProgram = h
+	}
+	return r.celProgram.evaluate(features, ctx)
+}
+
 func (r *Rule) executeLabelsTemplate(in matchedFeatures, out map[string]string) error {
 	if r.LabelsTemplate == "" {
 		return nil
@@ -114,7 +171,7 @@ func (r *Rule) executeLabelsTemplate(in matchedFeatures, out map[string]string)
 		r.labelsTemplate = t
 	}
 
-	labels, err := r.labelsTemplate.expandMap(in)
+	labels, err := r.labelsTemplate.expandMap(in.domains, in.aggregate)
 	if err != nil {
 		return fmt.Errorf("failed to expand LabelsTemplate: %w", err)
 	}
@@ -131,14 +188,14 @@ func (r *Rule) executeVarsTemplate(in matchedFeatures, out map[string]string) er
 	if r.varsTemplate == nil {
 		t, err := newTemplateHelper(r.VarsTemplate)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to parse VarsTemplate: %w", err)
 		}
 		r.varsTemplate = t
 	}
 
-	vars, err := r.varsTemplate.expandMap(in)
+	vars, err := r.varsTemplate.expandMap(in.domains, in.aggregate)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to expand VarsTemplate: %w", err)
 	}
 	for k, v := range vars {
 		out[k] = v
@@ -146,22 +203,118 @@ func (r *Rule) executeVarsTemplate(in matchedFeatures, out map[string]string) er
 	return nil
 }
 
-type matchedFeatures map[string]domainMatchedFeatures
+// matchedFeatures holds the features matched by one MatchFeatures field.
+type matchedFeatures struct {
+	// domains is the template data, i.e. the matched features per domain
+	domains map[string]domainMatchedFeatures
+	// aggregates holds the aggregate values of the matched instance
+	// features, indexed by <domain>.<feature>. They are kept separate from
+	// the template data and made available in templates through the
+	// "aggregate" function.
+	aggregates map[string]MatchedAggregates
+}
 
 type domainMatchedFeatures map[string]interface{}
 
-func (e *MatchAnyElem) match(features map[string]*feature.DomainFeatures) (bool, matchedFeatures, error) {
-	return e.MatchFeatures.match(features)
+// aggregate returns the aggregate values of a matched instance feature.
+func (m matchedFeatures) aggregate(name string) (MatchedAggregates, error) {
+	split := strings.SplitN(name, ".", 2)
+	if len(split) != 2 {
+		return MatchedAggregates{}, fmt.Errorf("invalid feature %q: must be <domain>.<feature>", name)
+	}
+	a, ok := m.aggregates[split[0]+"."+strings.ToLower(split[1])]
+	if !ok {
+		return MatchedAggregates{}, fmt.Errorf("no aggregates for feature %q: not a matched instance feature", name)
+	}
+	return a, nil
+}
+
+// match evaluates the sub-matcher. On a match, the matched features of all
+// the MatchFeatures fields that contributed to the match are returned. If
+// needAll is false, evaluation of MatchAny is stopped on the first matching
+// element. Path is the location of the sub-matcher in the rule, used for
+// tracing.
+func (e *MatchAnyElem) match(features map[string]*feature.DomainFeatures, needAll bool, trace *RuleTrace, path string) (bool, []matchedFeatures, error) {
+	isMatch, matches, err := matchNested(features, e.MatchAll, e.MatchAny, e.MatchNone, needAll, trace, path+".")
+	if err != nil || !isMatch {
+		return false, nil, err
+	}
+
+	// An element without any nested matchers is a plain MatchFeatures, which
+	// is evaluated even if empty
+	if len(e.MatchFeatures) > 0 || (len(e.MatchAll) == 0 && len(e.MatchAny) == 0 && len(e.MatchNone) == 0) {
+		isMatch, m, err := e.MatchFeatures.match(features, trace, path+".matchFeatures")
+		if err != nil || !isMatch {
+			return false, nil, err
+		}
+		matches = append(matches, m)
+	}
+	return true, matches, nil
+}
+
+// matchNested evaluates a set of nested matchers: all elements of matchAll and
+// at least one element of matchAny (if not empty) must match, and none of the
+// elements of matchNone may match.
+func matchNested(features map[string]*feature.DomainFeatures, matchAll, matchAny, matchNone []MatchAnyElem, needAll bool, trace *RuleTrace, path string) (bool, []matchedFeatures, error) {
+	var ret []matchedFeatures
+
+	if len(matchAny) > 0 {
+		// Logical OR over the elements
+		matched := false
+		for i, e := range matchAny {
+			if isMatch, matches, err := e.match(features, needAll, trace, fmt.Sprintf("%smatchAny[%d]", path, i)); err != nil {
+				return false, nil, err
+			} else if isMatch {
+				matched = true
+				ret = append(ret, matches...)
+				if !needAll {
+					break
+				}
+			}
+		}
+		if !matched {
+			return false, nil, nil
+		}
+	}
+
+	// Logical AND over the elements
+	for i, e := range matchAll {
+		if isMatch, matches, err := e.match(features, needAll, trace, fmt.Sprintf("%smatchAll[%d]", path, i)); err != nil {
+			return false, nil, err
+		} else if !isMatch {
+			return false, nil, nil
+		} else {
+			ret = append(ret, matches...)
+		}
+	}
+
+	// Logical NOR over the elements
+	for i, e := range matchNone {
+		if isMatch, _, err := e.match(features, false, trace, fmt.Sprintf("%smatchNone[%d]", path, i)); err != nil {
+			return false, nil, err
+		} else if isMatch {
+			return false, nil, nil
+		}
+	}
+
+	return true, ret, nil
 }
 
-func (m *FeatureMatcher) match(features map[string]*feature.DomainFeatures) (bool, matchedFeatures, error) {
-	matches := make(matchedFeatures, len(*m))
+func (m *FeatureMatcher) match(features map[string]*feature.DomainFeatures, trace *RuleTrace, path string) (bool, matchedFeatures, error) {
+	matches := matchedFeatures{
+		domains:    make(map[string]domainMatchedFeatures, len(*m)),
+		aggregates: make(map[string]MatchedAggregates),
+	}
 
 	// Logical AND over the terms
-	for _, term := range *m {
+	for i, term := range *m {
+		termPath := fmt.Sprintf("%s[%d]", path, i)
+
 		split := strings.SplitN(term.Feature, ".", 2)
 		if len(split) != 2 {
-			return false, nil, fmt.Errorf("invalid feature %q: must be <domain>.<feature>", term.Feature)
+			err := fmt.Errorf("invalid feature %q: must be <domain>.<feature>", term.Feature)
+			trace.addTerm(termPath, &term, nil, "", false, err)
+			return false, matchedFeatures{}, err
 		}
 		domain := split[0]
 		// Ignore case
@@ -169,11 +322,37 @@ func (m *FeatureMatcher) match(features map[string]*feature.DomainFeatures) (boo
 
 		domainFeatures, ok := features[domain]
 		if !ok {
-			return false, nil, fmt.Errorf("unknown feature source/domain %q", domain)
+			err := fmt.Errorf("unknown feature source/domain %q", domain)
+			trace.addTerm(termPath, &term, nil, "", false, err)
+			return false, matchedFeatures{}, err
 		}
 
-		if _, ok := matches[domain]; !ok {
-			matches[domain] = make(domainMatchedFeatures)
+		if _, ok := matches.domains[domain]; !ok {
+			matches.domains[domain] = make(domainMatchedFeatures)
+		}
+
+		if len(term.MatchAggregates) > 0 {
+			if _, ok := domainFeatures.Instances[featureName]; !ok {
+				err := fmt.Errorf("invalid feature %q: matchAggregates is only supported for instance features", term.Feature)
+				trace.addTerm(termPath, &term, nil, "", false, err)
+				return false, matchedFeatures{}, err
+			}
+		}
+
+		if len(term.Joins) > 0 {
+			f, ok := domainFeatures.Instances[featureName]
+			if !ok {
+				err := fmt.Errorf("invalid feature %q: joins are only supported for instance features", term.Feature)
+				trace.addTerm(termPath, &term, nil, "", false, err)
+				return false, matchedFeatures{}, err
+			}
+			instances, err := joinInstances(features, f.Elements, term.Joins)
+			if err != nil {
+				trace.addTerm(termPath, &term, nil, "", false, err)
+				return false, matchedFeatures{}, err
+			}
+			// Match against the joined instances instead of the original ones
+			domainFeatures = withInstances(domainFeatures, featureName, instances)
 		}
 
 		var isMatch bool
@@ -182,25 +361,38 @@ func (m *FeatureMatcher) match(features map[string]*feature.DomainFeatures) (boo
 			m, v, e := term.MatchExpressions.MatchGetKeys(f.Elements)
 			isMatch = m
 			err = e
-			matches[domain][featureName] = v
+			matches.domains[domain][featureName] = v
 		} else if f, ok := domainFeatures.Values[featureName]; ok {
 			m, v, e := term.MatchExpressions.MatchGetValues(f.Elements)
 			isMatch = m
 			err = e
-			matches[domain][featureName] = v
+			matches.domains[domain][featureName] = v
 		} else if f, ok := domainFeatures.Instances[featureName]; ok {
 			v, e := term.MatchExpressions.MatchGetInstances(f.Elements)
 			isMatch = len(v) > 0
 			err = e
-			matches[domain][featureName] = v
+			matches.domains[domain][featureName] = v
+
+			if err == nil {
+				aggregates := aggregateInstances(v)
+				// Aggregates narrow down the instance match, a term never
+				// matches without matching instances
+				if isMatch && len(term.MatchAggregates) > 0 {
+					isMatch, err = matchAggregates(term.MatchAggregates, aggregates)
+				}
+				matches.aggregates[domain+"."+featureName] = aggregates
+			}
 		} else {
-			return false, nil, fmt.Errorf("%q feature of source/domain %q not available", featureName, domain)
+			err := fmt.Errorf("%q feature of source/domain %q not available", featureName, domain)
+			trace.addTerm(termPath, &term, nil, "", false, err)
+			return false, matchedFeatures{}, err
 		}
 
+		trace.addTerm(termPath, &term, domainFeatures, featureName, isMatch && err == nil, err)
 		if err != nil {
-			return false, nil, err
+			return false, matchedFeatures{}, err
 		} else if !isMatch {
-			return false, nil, nil
+			return false, matchedFeatures{}, nil
 		}
 	}
 	return true, matches, nil
@@ -211,7 +403,7 @@ type templateHelper struct {
 }
 
 func newTemplateHelper(name string) (*templateHelper, error) {
-	tmpl, err := template.New("").Option("missingkey=error").Parse(name)
+	tmpl, err := template.New("").Option("missingkey=error").Funcs(templateFuncs).Parse(name)
 	if err != nil {
 		return nil, fmt.Errorf("invalid template: %w", err)
 	}
@@ -234,9 +426,17 @@ func (h *templateHelper) DeepCopyInto(out *templateHelper) {
 	out.template = h.template
 }
 
-func (h *templateHelper) execute(data interface{}) (string, error) {
+// execute the template. The "aggregate" template function is bound to the
+// given function for this execution only.
+func (h *templateHelper) execute(data interface{}, aggregate func(string) (MatchedAggregates, error)) (string, error) {
+	tmpl, err := h.template.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{"aggregate": aggregate})
+
 	var tmp bytes.Buffer
-	if err := h.template.Execute(&tmp, data); err != nil {
+	if err := tmpl.Execute(&tmp, data); err != nil {
 		return "", err
 	}
 	return tmp.String(), nil
@@ -245,8 +445,8 @@ func (h *templateHelper) execute(data interface{}) (string, error) {
 // expandMap is a helper for expanding a template in to a map of strings. Data
 // after executing the template is expexted to be key=value pairs separated by
 // newlines.
-func (h *templateHelper) expandMap(data interface{}) (map[string]string, error) {
-	expanded, err := h.execute(data)
+func (h *templateHelper) expandMap(data interface{}, aggregate func(string) (MatchedAggregates, error)) (map[string]string, error) {
+	expanded, err := h.execute(data, aggregate)
 	if err != nil {
 		return nil, err
 	}
@@ -17,10 +17,14 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
 )
 
 func TestRule(t *testing.T) {
@@ -386,3 +390,854 @@ var-2=
 	assert.Error(t, err)
 
 }
+
+func TestAggregates(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"name": "nvme0n1", "size": "1Ti", "rotational": "0"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "nvme1n1", "size": "512Gi", "rotational": "0"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "sda", "size": "4Ti", "rotational": "1"}),
+	})
+	f.Values["kernel"] = feature.NewValueFeatures(map[string]string{"version": "5.15"})
+	features := map[string]*feature.DomainFeatures{"storage": f}
+
+	r := Rule{
+		Labels: map[string]string{"nvme": "true"},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature: "storage.device",
+				MatchExpressions: MatchExpressionSet{
+					"name": MustCreateMatchExpression(MatchInRegexp, "^nvme"),
+				},
+				MatchAggregates: MatchExpressionSet{
+					"count":     MustCreateMatchExpression(MatchGt, "1"),
+					"sum(size)": MustCreateMatchExpression(MatchQuantityGt, "1.2Ti"),
+				},
+			},
+		},
+		LabelsTemplate: `nvme-count={{ (aggregate "storage.device").Count }}
+nvme-size={{ (aggregate "storage.device").Sum.size }}
+nvme-min={{ (aggregate "storage.device").Min.size }}
+nvme-max={{ (aggregate "storage.device").Max.size }}`,
+	}
+
+	m, err := r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{
+		"nvme":       "true",
+		"nvme-count": "2",
+		"nvme-size":  "1536Gi",
+		"nvme-min":   "512Gi",
+		"nvme-max":   "1Ti",
+	}, m.Labels)
+
+	// Aggregate condition not satisfied
+	r.MatchFeatures[0].MatchAggregates["max(size)"] = MustCreateMatchExpression(MatchQuantityGt, "2Ti")
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels, "aggregates should not have matched")
+
+	// Aggregates are not part of the template data
+	r.MatchFeatures[0].MatchAggregates = nil
+	r.labelsTemplate = nil
+	r.LabelsTemplate = `{{ range $domain, $features := . }}domain-{{ $domain }}=true
+{{ end }}`
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"nvme": "true", "domain-storage": "true"}, m.Labels)
+
+	// A domain named "aggregate" does not collide with aggregates
+	r.MatchFeatures[0].Feature = "aggregate.device"
+	r.labelsTemplate = nil
+	r.LabelsTemplate = `nvme-count={{ (aggregate "aggregate.device").Count }}
+nvme-names={{ range .aggregate.device }}{{ .name }}.{{ end }}`
+	m, err = r.Execute(map[string]*feature.DomainFeatures{"aggregate": f})
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"nvme": "true", "nvme-count": "2", "nvme-names": "nvme0n1.nvme1n1."}, m.Labels)
+
+	// Aggregates of a feature that was not matched
+	r.labelsTemplate = nil
+	r.LabelsTemplate = `nvme-count={{ (aggregate "storage.device").Count }}`
+	_, err = r.Execute(map[string]*feature.DomainFeatures{"aggregate": f})
+	assert.Error(t, err, "aggregates of a non-matched feature should have failed")
+
+	// Aggregates over zero instances
+	r = Rule{
+		Labels: map[string]string{"no-rotational": "true"},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature: "storage.device",
+				MatchExpressions: MatchExpressionSet{
+					"rotational": MustCreateMatchExpression(MatchIn, "2"),
+				},
+				MatchAggregates: MatchExpressionSet{
+					"count":     MustCreateMatchExpression(MatchLt, "1"),
+					"min(size)": MustCreateMatchExpression(MatchDoesNotExist),
+				},
+			},
+		},
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels, "aggregates should not match without matching instances")
+
+	// Aggregates are combined with the instance match
+	r.MatchFeatures[0].MatchExpressions = MatchExpressionSet{
+		"name": MustCreateMatchExpression(MatchInRegexp, "^nvme"),
+	}
+	r.MatchFeatures[0].MatchAggregates = MatchExpressionSet{
+		"count": MustCreateMatchExpression(MatchLt, "3"),
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"no-rotational": "true"}, m.Labels)
+
+	// Non-numeric attributes are not aggregated
+	r.MatchFeatures[0].MatchExpressions = MatchExpressionSet{}
+	r.MatchFeatures[0].MatchAggregates = MatchExpressionSet{
+		"count":     MustCreateMatchExpression(MatchIn, "3"),
+		"sum(name)": MustCreateMatchExpression(MatchDoesNotExist),
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"no-rotational": "true"}, m.Labels)
+
+	// Error cases
+	r.MatchFeatures[0].MatchAggregates = MatchExpressionSet{"avg(size)": MustCreateMatchExpression(MatchExists)}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "invalid aggregate name should have failed")
+
+	r.MatchFeatures[0].Feature = "storage.kernel"
+	r.MatchFeatures[0].MatchAggregates = MatchExpressionSet{"count": MustCreateMatchExpression(MatchExists)}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "aggregates on a non-instance feature should have failed")
+}
+
+func TestJoins(t *testing.T) {
+	pci := feature.NewDomainFeatures()
+	pci.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"address": "0000:3b:00.0", "vendor": "15b3", "class": "0200"}),
+		*feature.NewInstanceFeature(map[string]string{"address": "0000:5e:00.0", "vendor": "8086", "class": "0200"}),
+		*feature.NewInstanceFeature(map[string]string{"address": "0000:86:00.0", "vendor": "8086", "class": "0108"}),
+	})
+	network := feature.NewDomainFeatures()
+	network.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"name": "eth0", "speed": "100000", "pci_address": "0000:3b:00.0"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "eth1", "speed": "100000", "pci_address": "0000:5e:00.0"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "eth2", "speed": "10000", "pci_address": "0000:3b:00.1"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "bond0", "speed": "200000"}),
+	})
+	network.Values["state"] = feature.NewValueFeatures(nil)
+	features := map[string]*feature.DomainFeatures{"pci": pci, "network": network}
+
+	r := Rule{
+		Labels: map[string]string{"mellanox-100g": "true"},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature: "network.device",
+				MatchExpressions: MatchExpressionSet{
+					"speed":             MustCreateMatchExpression(MatchGt, "99999"),
+					"pci_device_vendor": MustCreateMatchExpression(MatchIn, "15b3"),
+				},
+				Joins: []FeatureJoin{{Feature: "pci.device", Attribute: "pci_address", JoinAttribute: "address"}},
+			},
+		},
+		LabelsTemplate: `{{ range .network.device }}nic-{{ .name }}={{ .pci_device_class }}{{ end }}`,
+	}
+
+	m, err := r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"mellanox-100g": "true", "nic-eth0": "0200"}, m.Labels)
+
+	// Instances without a counterpart are dropped
+	r.MatchFeatures[0].MatchExpressions = MatchExpressionSet{}
+	r.MatchFeatures[0].MatchAggregates = MatchExpressionSet{"count": MustCreateMatchExpression(MatchIn, "2")}
+	r.LabelsTemplate = ""
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"mellanox-100g": "true"}, m.Labels)
+
+	// Join attribute defaults to the same attribute name
+	r.MatchFeatures[0].Joins[0].JoinAttribute = ""
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels, "join should not have matched")
+
+	// Error cases
+	r.MatchFeatures[0].Joins[0] = FeatureJoin{Feature: "pci", Attribute: "pci_address"}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "invalid join feature should have failed")
+
+	r.MatchFeatures[0].Joins[0] = FeatureJoin{Feature: "usb.device", Attribute: "pci_address"}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "join with unknown domain should have failed")
+
+	r.MatchFeatures[0].Joins[0] = FeatureJoin{Feature: "network.state", Attribute: "pci_address"}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "join with a non-instance feature should have failed")
+
+	r.MatchFeatures[0].Joins[0] = FeatureJoin{Feature: "pci.device"}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "join without attribute should have failed")
+
+	r.MatchFeatures[0] = FeatureMatcherTerm{
+		Feature:          "network.state",
+		MatchExpressions: MatchExpressionSet{},
+		Joins:            []FeatureJoin{{Feature: "pci.device", Attribute: "address"}},
+	}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "joins on a non-instance feature should have failed")
+}
+
+func TestNestedMatchers(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["cpuid"] = feature.NewKeyFeatures("AVX512F", "AVX2")
+	f.Values["model"] = feature.NewValueFeatures(map[string]string{"vendor_id": "Intel", "family": "6"})
+	f.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"vendor": "8086", "class": "0200"}),
+		*feature.NewInstanceFeature(map[string]string{"vendor": "10de", "class": "0300"}),
+	})
+	features := map[string]*feature.DomainFeatures{"cpu": f}
+
+	term := func(feature, key string, op MatchOp, values ...string) MatchAnyElem {
+		return MatchAnyElem{MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          feature,
+				MatchExpressions: MatchExpressionSet{key: MustCreateMatchExpression(op, values...)},
+			},
+		}}
+	}
+
+	// AVX512 but not a specific CPU family
+	r := Rule{
+		Labels:    map[string]string{"avx512": "true"},
+		MatchAll:  []MatchAnyElem{term("cpu.cpuid", "AVX512F", MatchExists)},
+		MatchNone: []MatchAnyElem{term("cpu.model", "family", MatchIn, "6")},
+	}
+	m, err := r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels, "matchNone should have prevented the match")
+
+	r.MatchNone = []MatchAnyElem{term("cpu.model", "family", MatchIn, "7")}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, r.Labels, m.Labels, "rule should have matched")
+
+	// (A or B) and (C or D)
+	r = Rule{
+		Labels: map[string]string{"nested": "true"},
+		MatchAll: []MatchAnyElem{
+			{MatchAny: []MatchAnyElem{
+				term("cpu.cpuid", "AMX", MatchExists),
+				term("cpu.cpuid", "AVX2", MatchExists),
+			}},
+			{MatchAny: []MatchAnyElem{
+				term("cpu.model", "vendor_id", MatchIn, "AMD"),
+				term("cpu.model", "vendor_id", MatchIn, "Foo"),
+			}},
+		},
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels, "nested matchers should not have matched")
+
+	r.MatchAll[1].MatchAny[1] = term("cpu.model", "vendor_id", MatchIn, "Intel")
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, r.Labels, m.Labels, "nested matchers should have matched")
+
+	// Templates are executed against matched branches only
+	r = Rule{
+		LabelsTemplate: `{{range .cpu.device}}dev-{{.vendor}}=true
+{{end}}`,
+		MatchAny: []MatchAnyElem{
+			{
+				MatchAll: []MatchAnyElem{term("cpu.device", "vendor", MatchIn, "8086")},
+				MatchNone: []MatchAnyElem{
+					{
+						MatchAny: []MatchAnyElem{term("cpu.cpuid", "AVX512F", MatchDoesNotExist)},
+					},
+				},
+			},
+			term("cpu.device", "vendor", MatchIn, "1234"),
+			{
+				MatchFeatures: FeatureMatcher{
+					FeatureMatcherTerm{
+						Feature:          "cpu.device",
+						MatchExpressions: MatchExpressionSet{"class": MustCreateMatchExpression(MatchIn, "0300")},
+					},
+				},
+				MatchNone: []MatchAnyElem{term("cpu.device", "vendor", MatchIn, "8086")},
+			},
+		},
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"dev-8086": "true"}, m.Labels)
+
+	r.MatchAny[2].MatchNone[0] = term("cpu.device", "vendor", MatchIn, "abcd")
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"dev-8086": "true", "dev-10de": "true"}, m.Labels)
+
+	// Errors in nested matchers are propagated
+	r.MatchAny[2].MatchNone[0] = term("cpu.foo", "vendor", MatchIn, "abcd")
+	_, err = r.Execute(features)
+	assert.Error(t, err)
+}
+
+func TestMatchCEL(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["cpuid"] = feature.NewKeyFeatures("AVX512F", "AVX2")
+	f.Values["model"] = feature.NewValueFeatures(map[string]string{"vendor_id": "Intel", "family": "6"})
+	f.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"vendor": "8086", "class": "0200"}),
+		*feature.NewInstanceFeature(map[string]string{"vendor": "10de", "class": "0300"}),
+	})
+	features := map[string]*feature.DomainFeatures{"cpu": f}
+
+	type TC struct {
+		expr   string
+		result bool
+		err    assert.ValueAssertionFunc
+	}
+	tcs := []TC{
+		{expr: `"AVX512F" in keys["cpu.cpuid"]`, result: true, err: assert.Nil},
+		{expr: `"AMX" in keys["cpu.cpuid"]`, result: false, err: assert.Nil},
+		{expr: `values["cpu.model"].vendor_id == "Intel" && int(values["cpu.model"].family) >= 6`, result: true, err: assert.Nil},
+		{expr: `instances["cpu.device"].exists(d, d.vendor == "8086" && d.class.startsWith("02"))`, result: true, err: assert.Nil},
+		{expr: `instances["cpu.device"].filter(d, d.vendor == "10de").size() > 1`, result: false, err: assert.Nil},
+		{expr: `"foo.bar" in values || "cpu.model" in values`, result: true, err: assert.Nil},
+		// Runtime errors
+		{expr: `values["foo.bar"].baz == "x"`, err: assert.NotNil},
+		// Compile errors
+		{expr: `values["cpu.model"].vendor_id`, err: assert.NotNil},
+		{expr: `foo == "bar"`, err: assert.NotNil},
+		{expr: `"AVX" in`, err: assert.NotNil},
+	}
+
+	for _, tc := range tcs {
+		r := Rule{Labels: map[string]string{"cel": "true"}, MatchCEL: tc.expr}
+		m, err := r.Execute(features)
+		tc.err(t, err, tc.expr)
+		if err == nil {
+			if tc.result {
+				assert.Equal(t, r.Labels, m.Labels, tc.expr)
+			} else {
+				assert.Nil(t, m.Labels, tc.expr)
+			}
+		}
+	}
+
+	// The expression is compiled only once
+	r := Rule{MatchCEL: `"cpu.cpuid" in keys`}
+	_, err := r.Execute(features)
+	assert.Nil(t, err)
+	p := r.celProgram
+	assert.NotNil(t, p)
+	_, err = r.Execute(features)
+	assert.Nil(t, err)
+	assert.Same(t, p, r.celProgram)
+
+	// MatchCEL is combined with other matchers
+	r = Rule{
+		Labels: map[string]string{"cel": "true"},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          "cpu.cpuid",
+				MatchExpressions: MatchExpressionSet{"AVX2": MustCreateMatchExpression(MatchExists)},
+			},
+		},
+		MatchCEL: `"AMX" in keys["cpu.cpuid"]`,
+	}
+	m, err := r.Execute(features)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Labels)
+
+	// The input of a domain is converted only once within an EvalContext,
+	// back-references are never cached
+	ctx := NewEvalContext()
+	r = Rule{Labels: map[string]string{"cel": "true"}, MatchCEL: `"AVX2" in keys["cpu.cpuid"]`}
+	m, err = ctx.Execute(&r, NewBackrefScopes().Features(features, "scope"))
+	assert.Nil(t, err)
+	assert.Equal(t, r.Labels, m.Labels)
+	assert.Len(t, ctx.celDomains, 1)
+	in := ctx.celDomains[f]
+	assert.NotNil(t, in)
+	m, _, err = ctx.ExecuteWithTrace(&r, NewBackrefScopes().Features(features, "scope"))
+	assert.Nil(t, err)
+	assert.Equal(t, r.Labels, m.Labels)
+	assert.Len(t, ctx.celDomains, 1)
+	assert.Same(t, in, ctx.celDomains[f])
+
+	// Evaluation is aborted when exceeding the runtime cost limit
+	long := strings.Repeat("a", 10000000)
+	big := feature.NewDomainFeatures()
+	big.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"name": long}),
+	})
+	r = Rule{MatchCEL: `instances["pci.device"].all(d, d.name.contains(d.name))`}
+	assert.Nil(t, r.Validate())
+	_, err = r.Execute(map[string]*feature.DomainFeatures{"pci": big})
+	assert.Contains(t, fmt.Sprint(err), "cost limit exceeded")
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["flags"] = feature.NewKeyFeatures("c", "a", "b")
+	f.Values["info"] = feature.NewValueFeatures(map[string]string{"model": "Xeon(R) Gold 6248", "cores": "20", "mhz": "x"})
+	features := map[string]*feature.DomainFeatures{"d": f}
+
+	type TC struct {
+		name     string
+		template string
+		out      map[string]string
+		err      assert.ValueAssertionFunc
+	}
+	tcs := []TC{
+		{
+			name: "string functions",
+			template: `{{range .d.info}}{{if eq .Name "model"}}lower={{.Value | lower}}
+upper={{.Value | upper}}
+replace={{.Value | replace " " "-"}}
+regex={{.Value | regexReplace "[^0-9]+" ""}}
+split={{index (split " " .Value) 1}}
+trim={{trimPrefix "Xeon" .Value | trimSuffix "48" | trim}}
+contains={{contains "Gold" .Value}}
+{{end}}{{end}}`,
+			out: map[string]string{
+				"lower":    "xeon(r) gold 6248",
+				"upper":    "XEON(R) GOLD 6248",
+				"replace":  "Xeon(R)-Gold-6248",
+				"regex":    "6248",
+				"split":    "Gold",
+				"trim":     "(R) Gold 62",
+				"contains": "true",
+			},
+			err: assert.Nil,
+		},
+		{
+			name: "list functions",
+			template: `join={{.d.flags | join ","}}
+sorted={{.d.flags | sortAlpha | join "-"}}
+len={{len .d.flags}}`,
+			out: map[string]string{"join": "a,b,c", "sorted": "a-b-c", "len": "3"},
+			err: assert.Nil,
+		},
+		{
+			name: "math functions",
+			template: `{{range .d.info}}{{if eq .Name "cores"}}add={{add .Value 4}}
+sub={{sub .Value 25}}
+mul={{mul .Value 2}}
+div={{div .Value 3}}
+mod={{mod .Value 3}}
+max={{max .Value 16}}
+min={{min .Value 16}}
+{{end}}{{end}}`,
+			out: map[string]string{"add": "24", "sub": "-5", "mul": "40", "div": "6", "mod": "2", "max": "20", "min": "16"},
+			err: assert.Nil,
+		},
+		{
+			name:     "toLabelValue",
+			template: `{{range .d.info}}{{if eq .Name "model"}}model={{toLabelValue .Value}}{{end}}{{end}}`,
+			out:      map[string]string{"model": "Xeon_R__Gold_6248"},
+			err:      assert.Nil,
+		},
+		{
+			name:     "invalid number",
+			template: `{{range .d.info}}{{if eq .Name "mhz"}}mhz={{add .Value 1}}{{end}}{{end}}`,
+			err:      assert.NotNil,
+		},
+		{
+			name:     "division by zero",
+			template: `x={{div 1 0}}`,
+			err:      assert.NotNil,
+		},
+		{
+			name:     "invalid regexp",
+			template: `x={{regexReplace "(" "" "foo"}}`,
+			err:      assert.NotNil,
+		},
+		{
+			name:     "unknown function",
+			template: `x={{foo 1}}`,
+			err:      assert.NotNil,
+		},
+	}
+
+	for _, tc := range tcs {
+		r := Rule{
+			LabelsTemplate: tc.template,
+			VarsTemplate:   tc.template,
+			MatchFeatures: FeatureMatcher{
+				FeatureMatcherTerm{
+					Feature: "d.flags",
+					MatchExpressions: MatchExpressionSet{
+						"a": MustCreateMatchExpression(MatchExists),
+						"b": MustCreateMatchExpression(MatchExists),
+						"c": MustCreateMatchExpression(MatchExists),
+					},
+				},
+				FeatureMatcherTerm{
+					Feature: "d.info",
+					MatchExpressions: MatchExpressionSet{
+						"model": MustCreateMatchExpression(MatchExists),
+						"cores": MustCreateMatchExpression(MatchExists),
+						"mhz":   MustCreateMatchExpression(MatchExists),
+					},
+				},
+			},
+		}
+		m, err := r.Execute(features)
+		tc.err(t, err, tc.name)
+		if err == nil {
+			assert.Equal(t, tc.out, m.Labels, tc.name)
+			assert.Equal(t, tc.out, m.Vars, tc.name)
+		}
+	}
+
+	// Label value sanitizing
+	assert.Equal(t, "foo-bar.baz_1", toLabelValue("foo-bar.baz_1"))
+	assert.Equal(t, "a_b", toLabelValue("__a/b--"))
+	assert.Equal(t, "", toLabelValue("///"))
+	assert.Equal(t, strings.Repeat("x", 62), toLabelValue(strings.Repeat("x", 62)+"_y"))
+}
+
+func TestExecuteWithTrace(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["cpuid"] = feature.NewKeyFeatures("AVX2")
+	f.Values["version"] = feature.NewValueFeatures(map[string]string{"major": "4"})
+	f.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"vendor": "8086"}),
+		*feature.NewInstanceFeature(map[string]string{"vendor": "10de"}),
+	})
+	features := map[string]*feature.DomainFeatures{"d": f}
+
+	r := Rule{
+		Name:   "trace-test",
+		Labels: map[string]string{"foo": "bar"},
+		MatchAny: []MatchAnyElem{
+			{
+				MatchFeatures: FeatureMatcher{
+					FeatureMatcherTerm{
+						Feature:          "d.cpuid",
+						MatchExpressions: MatchExpressionSet{"AVX512F": MustCreateMatchExpression(MatchExists)},
+					},
+				},
+			},
+		},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          "d.device",
+				MatchExpressions: MatchExpressionSet{"vendor": MustCreateMatchExpression(MatchIn, "8086")},
+			},
+			FeatureMatcherTerm{
+				Feature:          "d.version",
+				MatchExpressions: MatchExpressionSet{"major": MustCreateMatchExpression(MatchGt, "4")},
+			},
+		},
+	}
+
+	// MatchAny fails
+	out, trace, err := r.ExecuteWithTrace(features)
+	assert.Nil(t, err)
+	assert.Nil(t, out.Labels)
+	assert.Equal(t, &RuleTrace{
+		Name:    "trace-test",
+		Matched: false,
+		Terms: []TermTrace{
+			{
+				Path:    "matchAny[0].matchFeatures[0]",
+				Feature: "d.cpuid",
+				Expressions: []ExpressionTrace{
+					{Key: "AVX512F", Op: MatchExists, Input: []string{}},
+				},
+			},
+		},
+	}, trace)
+
+	// MatchFeatures fails on the second term
+	r.MatchAny[0].MatchFeatures[0].MatchExpressions = MatchExpressionSet{"AVX2": MustCreateMatchExpression(MatchExists)}
+	out, trace, err = r.ExecuteWithTrace(features)
+	assert.Nil(t, err)
+	assert.Nil(t, out.Labels)
+	assert.False(t, trace.Matched)
+	assert.Len(t, trace.Terms, 3)
+	assert.Equal(t, TermTrace{
+		Path:    "matchFeatures[0]",
+		Feature: "d.device",
+		Matched: true,
+		Expressions: []ExpressionTrace{
+			{Key: "vendor", Op: MatchIn, Value: MatchValue{"8086"}, Input: []string{"8086", "10de"}, Matched: true},
+		},
+	}, trace.Terms[1])
+	assert.Equal(t, TermTrace{
+		Path:    "matchFeatures[1]",
+		Feature: "d.version",
+		Expressions: []ExpressionTrace{
+			{Key: "major", Op: MatchGt, Value: MatchValue{"4"}, Input: []string{"4"}},
+		},
+	}, trace.Terms[2])
+	assert.Contains(t, trace.String(), `major Gt [4]: no match, input ["4"]`)
+
+	// Match
+	r.MatchFeatures[1].MatchExpressions["major"] = MustCreateMatchExpression(MatchGt, "3")
+	out, trace, err = r.ExecuteWithTrace(features)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Labels, out.Labels)
+	assert.True(t, trace.Matched)
+	assert.True(t, trace.Terms[2].Matched)
+
+	// Errors are recorded in the trace
+	r.MatchFeatures[1].Feature = "d.foo"
+	_, trace, err = r.ExecuteWithTrace(features)
+	assert.Error(t, err)
+	assert.NotEmpty(t, trace.Error)
+	assert.NotEmpty(t, trace.Terms[2].Error)
+	assert.Nil(t, trace.Terms[2].Expressions)
+}
+
+func TestOnNoMatch(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["cpuid"] = feature.NewKeyFeatures("AVX2")
+	f.Instances["device"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"vendor": "8086"}),
+	})
+	features := map[string]*feature.DomainFeatures{"cpu": f}
+
+	r := Rule{
+		Labels:          map[string]string{"tier": "premium"},
+		Vars:            map[string]string{"premium": "true"},
+		LabelsOnNoMatch: map[string]string{"tier": "standard"},
+		VarsOnNoMatch:   map[string]string{"premium": "false"},
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          "cpu.device",
+				MatchExpressions: MatchExpressionSet{"vendor": MustCreateMatchExpression(MatchIn, "10de")},
+			},
+		},
+	}
+
+	m, err := r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, r.LabelsOnNoMatch, m.Labels)
+	assert.Equal(t, r.VarsOnNoMatch, m.Vars)
+
+	r.MatchFeatures[0].MatchExpressions["vendor"] = MustCreateMatchExpression(MatchIn, "8086")
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, r.Labels, m.Labels)
+	assert.Equal(t, r.Vars, m.Vars)
+
+	// Non-matching matchAny and matchCEL
+	r = Rule{
+		LabelsOnNoMatch: map[string]string{"avx512": "false"},
+		MatchAny: []MatchAnyElem{
+			{MatchFeatures: FeatureMatcher{
+				FeatureMatcherTerm{
+					Feature:          "cpu.cpuid",
+					MatchExpressions: MatchExpressionSet{"AVX512F": MustCreateMatchExpression(MatchExists)},
+				},
+			}},
+		},
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, r.LabelsOnNoMatch, m.Labels)
+	assert.Empty(t, m.Vars)
+
+	r = Rule{
+		VarsOnNoMatch: map[string]string{"avx512": "false"},
+		MatchCEL:      `"AVX512F" in keys["cpu.cpuid"]`,
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Empty(t, m.Labels)
+	assert.Equal(t, r.VarsOnNoMatch, m.Vars)
+
+	// No output on errors
+	r.MatchFeatures = FeatureMatcher{FeatureMatcherTerm{Feature: "cpu.non-existent"}}
+	m, err = r.Execute(features)
+	assert.Error(t, err)
+	assert.Nil(t, m.Vars)
+}
+
+func TestScore(t *testing.T) {
+	f := feature.NewDomainFeatures()
+	f.Keys["cpuid"] = feature.NewKeyFeatures("AVX512F", "AVX2")
+	f.Values["memory"] = feature.NewValueFeatures(map[string]string{"speed": "4800"})
+	f.Instances["nvme"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+		*feature.NewInstanceFeature(map[string]string{"name": "nvme0"}),
+		*feature.NewInstanceFeature(map[string]string{"name": "nvme1"}),
+	})
+	features := map[string]*feature.DomainFeatures{"hw": f}
+
+	term := func(weight int64, feature, key string, op MatchOp, values ...string) ScoreTerm {
+		return ScoreTerm{Weight: weight, MatchAnyElem: MatchAnyElem{MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          feature,
+				MatchExpressions: MatchExpressionSet{key: MustCreateMatchExpression(op, values...)},
+			},
+		}}}
+	}
+
+	r := Rule{
+		Name:   "score rule",
+		Labels: map[string]string{"scored": "true"},
+		Score: &RuleScore{
+			Label: "perf-score",
+			Var:   "perf-score-var",
+			Terms: []ScoreTerm{
+				term(40, "hw.cpuid", "AVX512F", MatchExists),
+				term(20, "hw.cpuid", "AMX", MatchExists),
+				term(30, "hw.memory", "speed", MatchGt, "4000"),
+				term(-5, "hw.nvme", "name", MatchIn, "nvme1"),
+			},
+		},
+	}
+
+	m, err := r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, map[string]string{"scored": "true", "perf-score": "65"}, m.Labels)
+	assert.Equal(t, map[string]string{"perf-score-var": "65"}, m.Vars)
+
+	// Nested matchers in terms
+	r.Score.Terms = []ScoreTerm{
+		{Weight: 10, MatchAnyElem: MatchAnyElem{MatchAny: []MatchAnyElem{
+			term(0, "hw.cpuid", "AMX", MatchExists).MatchAnyElem,
+			term(0, "hw.cpuid", "AVX2", MatchExists).MatchAnyElem,
+		}}},
+	}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, "10", m.Labels["perf-score"])
+
+	// No terms
+	r.Score.Terms = nil
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Equal(t, "0", m.Labels["perf-score"])
+
+	// Score is not computed if the rule does not match
+	r.MatchFeatures = FeatureMatcher{term(0, "hw.cpuid", "AMX", MatchExists).MatchFeatures[0]}
+	m, err = r.Execute(features)
+	assert.Nilf(t, err, "unexpected error: %v", err)
+	assert.Nil(t, m.Labels)
+
+	// Invalid score
+	r = Rule{Score: &RuleScore{Terms: []ScoreTerm{term(1, "hw.cpuid", "AVX2", MatchExists)}}}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "score without label or var should have failed")
+
+	r = Rule{Score: &RuleScore{Label: "score", Terms: []ScoreTerm{term(1, "hw.non-existent", "foo", MatchExists)}}}
+	_, err = r.Execute(features)
+	assert.Error(t, err, "non-existent feature should have failed")
+}
+
+func TestValidate(t *testing.T) {
+	fieldPath := func(err error) string {
+		var fe *utils.FieldError
+		if !errors.As(err, &fe) {
+			return ""
+		}
+		return fe.Path
+	}
+
+	// Valid rule
+	r := &Rule{
+		Name:           "valid",
+		LabelsTemplate: "{{range .domain.if}}{{.name}}=true\n{{end}}",
+		MatchCEL:       `"domain.kf" in keys`,
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          "domain.if",
+				MatchExpressions: MatchExpressionSet{"name": MustCreateMatchExpression(MatchInRegexp, "^foo")},
+				MatchAggregates:  MatchExpressionSet{"count": MustCreateMatchExpression(MatchGt, "1")},
+				Joins:            []FeatureJoin{{Feature: "domain.if2", Attribute: "id"}},
+			},
+		},
+		Score: &RuleScore{Label: "score", Terms: []ScoreTerm{{Weight: 1}}},
+	}
+	assert.Nil(t, r.Validate())
+	assert.NotNil(t, r.labelsTemplate)
+	assert.NotNil(t, r.celProgram)
+
+	// Invalid feature name in a nested matcher
+	r = &Rule{
+		MatchAny: []MatchAnyElem{
+			{},
+			{MatchAll: []MatchAnyElem{{MatchFeatures: FeatureMatcher{{Feature: "domain.kf"}, {Feature: "kf"}}}}},
+		},
+	}
+	err := r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "matchAny[1].matchAll[0].matchFeatures[1]", fieldPath(err))
+
+	// Invalid regexp
+	r = &Rule{
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:          "domain.kf",
+				MatchExpressions: MatchExpressionSet{"key": &MatchExpression{Op: MatchInRegexp, Value: MatchValue{"("}}},
+			},
+		},
+	}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "matchFeatures[0]", fieldPath(err))
+
+	// Invalid aggregate
+	r = &Rule{
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{
+				Feature:         "domain.if",
+				MatchAggregates: MatchExpressionSet{"avg(attr)": MustCreateMatchExpression(MatchGt, "1")},
+			},
+		},
+	}
+	assert.Error(t, r.Validate())
+
+	// Invalid join
+	r = &Rule{
+		MatchFeatures: FeatureMatcher{
+			FeatureMatcherTerm{Feature: "domain.if", Joins: []FeatureJoin{{Feature: "domain.if2"}}},
+		},
+	}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "matchFeatures[0].joins[0]", fieldPath(err))
+
+	// Invalid score
+	r = &Rule{Score: &RuleScore{Terms: []ScoreTerm{{Weight: 1}}}}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "score", fieldPath(err))
+
+	r = &Rule{Score: &RuleScore{Var: "score", Terms: []ScoreTerm{{MatchAnyElem: MatchAnyElem{MatchFeatures: FeatureMatcher{{Feature: "kf"}}}}}}}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "score.terms[0].matchFeatures[0]", fieldPath(err))
+
+	// Invalid templates
+	r = &Rule{LabelsTemplate: "{{range .domain.if}}"}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "labelsTemplate", fieldPath(err))
+
+	r = &Rule{VarsTemplate: "{{.foo"}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "varsTemplate", fieldPath(err))
+
+	// Invalid CEL expression
+	r = &Rule{MatchCEL: "keys["}
+	err = r.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, "matchCEL", fieldPath(err))
+
+	// CEL expression exceeding the estimated cost limit
+	r = &Rule{MatchCEL: `instances.all(a, instances[a].all(b, instances.all(c, instances[c].all(d, b.x == d.x))))`}
+	err = r.Validate()
+	assert.Contains(t, fmt.Sprint(err), "estimated cost")
+	assert.Equal(t, "matchCEL", fieldPath(err))
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+// compute evaluates all terms against a set of features and returns the sum
+// of the weights of the matching terms.
+func (s *RuleScore) compute(features feature.Features, trace *RuleTrace) (int64, error) {
+	if s.Label == "" && s.Var == "" {
+		return 0, fmt.Errorf("invalid score: label or var must be specified")
+	}
+
+	var score int64
+	for i, t := range s.Terms {
+		isMatch, _, err := t.MatchAnyElem.match(features, false, trace, fmt.Sprintf("score.terms[%d]", i))
+		if err != nil {
+			return 0, fmt.Errorf("failed to evaluate score term #%d: %w", i, err)
+		} else if isMatch {
+			score += t.Weight
+		}
+	}
+	return score, nil
+}
+
+// execute computes the score and stores it in the output labels and vars.
+func (s *RuleScore) execute(features feature.Features, trace *RuleTrace, labels, vars map[string]string) error {
+	score, err := s.compute(features, trace)
+	if err != nil {
+		return err
+	}
+
+	v := strconv.FormatInt(score, 10)
+	if s.Label != "" {
+		labels[s.Label] = v
+	}
+	if s.Var != "" {
+		vars[s.Var] = v
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs is the set of functions available in rule templates, in
+// addition to the text/template built-ins (like len, index and printf). The
+// functions are deterministic and, where the input may be invalid, return an
+// error instead of panicking. In line with text/template pipelines, the
+// operand is the last argument, e.g. {{ .Value | replace "." "_" }}.
+var templateFuncs = template.FuncMap{
+	// String functions
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"regexReplace": func(re, repl, s string) (string, error) {
+		r, err := regexp.Compile(re)
+		if err != nil {
+			return "", err
+		}
+		return r.ReplaceAllString(s, repl), nil
+	},
+	"join":         templateJoin,
+	"sortAlpha":    templateSortAlpha,
+	"toLabelValue": toLabelValue,
+
+	// Aggregate values of a matched instance feature, e.g.
+	// {{ (aggregate "storage.device").Count }}. This is only a placeholder
+	// for parsing, the real implementation is bound when the template is
+	// executed.
+	"aggregate": func(string) (MatchedAggregates, error) {
+		return MatchedAggregates{}, fmt.Errorf("aggregate not available")
+	},
+
+	// Math functions, operating on integers
+	"add": func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x + y }) },
+	"sub": func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x - y }) },
+	"mul": func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x * y }) },
+	"div": func(a, b interface{}) (int64, error) {
+		if y, err := toInt64(b); err != nil {
+			return 0, err
+		} else if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return intOp(a, b, func(x, y int64) int64 { return x / y })
+	},
+	"mod": func(a, b interface{}) (int64, error) {
+		if y, err := toInt64(b); err != nil {
+			return 0, err
+		} else if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return intOp(a, b, func(x, y int64) int64 { return x % y })
+	},
+	"max": func(a, b interface{}) (int64, error) {
+		return intOp(a, b, func(x, y int64) int64 {
+			if x > y {
+				return x
+			}
+			return y
+		})
+	},
+	"min": func(a, b interface{}) (int64, error) {
+		return intOp(a, b, func(x, y int64) int64 {
+			if x < y {
+				return x
+			}
+			return y
+		})
+	},
+	"toInt": toInt64,
+}
+
+// toInt64 converts an integer or a string holding an integer to int64.
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(n), 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number %q", n)
+		}
+		return i, nil
+	case int, int8, int16, int32, int64:
+		return reflect.ValueOf(n).Int(), nil
+	case uint, uint8, uint16, uint32, uint64:
+		return int64(reflect.ValueOf(n).Uint()), nil
+	}
+	return 0, fmt.Errorf("not a number %v (%T)", v, v)
+}
+
+func intOp(a, b interface{}, op func(int64, int64) int64) (int64, error) {
+	x, err := toInt64(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+// toStringSlice converts a list of any type to a list of strings. Matched
+// keys and values are converted to their names.
+func toStringSlice(list interface{}) ([]string, error) {
+	switch l := list.(type) {
+	case []string:
+		return l, nil
+	case []MatchedKey:
+		ret := make([]string, len(l))
+		for i, k := range l {
+			ret[i] = k.Name
+		}
+		return ret, nil
+	case []MatchedValue:
+		ret := make([]string, len(l))
+		for i, v := range l {
+			ret[i] = v.Name
+		}
+		return ret, nil
+	}
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("not a list: %v (%T)", list, list)
+	}
+	ret := make([]string, v.Len())
+	for i := range ret {
+		ret[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return ret, nil
+}
+
+func templateJoin(sep string, list interface{}) (string, error) {
+	l, err := toStringSlice(list)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(l, sep), nil
+}
+
+func templateSortAlpha(list interface{}) ([]string, error) {
+	l, err := toStringSlice(list)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(l))
+	copy(ret, l)
+	sort.Strings(ret)
+	return ret, nil
+}
+
+var invalidLabelValueChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// toLabelValue converts a string into a valid label value: invalid characters
+// are replaced with underscores, leading and trailing non-alphanumeric
+// characters are removed and the result is truncated to 63 characters.
+func toLabelValue(s string) string {
+	// Only ASCII characters remain after replacing the invalid ones
+	notAlnum := func(r rune) bool { return !isDigit(byte(r)) && !isAlpha(byte(r)) }
+
+	v := invalidLabelValueChars.ReplaceAllString(s, "_")
+	v = strings.TrimFunc(v, notAlnum)
+	if len(v) > 63 {
+		v = strings.TrimRightFunc(v[:63], notAlnum)
+	}
+	return v
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+// RuleTrace is a trace of the evaluation of a rule, describing which feature
+// matcher terms and expressions matched and which did not.
+// +k8s:deepcopy-gen=false
+type RuleTrace struct {
+	// Name of the rule.
+	Name string `json:"name"`
+	// Matched tells if the rule matched.
+	Matched bool `json:"matched"`
+	// Error is the error encountered when executing the rule, if any.
+	Error string `json:"error,omitempty"`
+	// Terms contains the evaluated feature matcher terms, in the order of
+	// evaluation. Evaluation of a matcher stops on the first term that does
+	// not match.
+	Terms []TermTrace `json:"terms,omitempty"`
+	// CEL contains the evaluation of MatchCEL, if it was evaluated.
+	CEL *CELTrace `json:"cel,omitempty"`
+}
+
+// TermTrace is a trace of the evaluation of one FeatureMatcherTerm.
+// +k8s:deepcopy-gen=false
+type TermTrace struct {
+	// Path of the term in the rule, e.g. "matchAny[1].matchFeatures[0]".
+	Path string `json:"path"`
+	// Feature is the name of the feature the term matched against.
+	Feature string `json:"feature"`
+	// Matched tells if the term matched.
+	Matched bool `json:"matched"`
+	// Error is the error encountered when evaluating the term, if any.
+	Error string `json:"error,omitempty"`
+	// Expressions contains the evaluation of each MatchExpression.
+	Expressions []ExpressionTrace `json:"expressions,omitempty"`
+	// Aggregates contains the evaluation of each aggregate expression.
+	Aggregates []ExpressionTrace `json:"aggregates,omitempty"`
+}
+
+// ExpressionTrace is a trace of the evaluation of one MatchExpression.
+// +k8s:deepcopy-gen=false
+type ExpressionTrace struct {
+	// Key is the name of the element the expression was evaluated against.
+	Key string `json:"key"`
+	// Op is the operator of the expression.
+	Op MatchOp `json:"op"`
+	// Value is the value of the expression.
+	Value MatchValue `json:"value,omitempty"`
+	// Input contains the input values seen by the expression. For flag
+	// features it contains the key if it was present, for attribute features
+	// the value of the key if it was present and for instance features the
+	// values of the attribute in all instances that have it.
+	Input []string `json:"input"`
+	// Matched tells if the expression matched. For instance features this
+	// means that the expression matched at least one instance.
+	Matched bool `json:"matched"`
+	// Error is the error encountered when evaluating the expression, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// CELTrace is a trace of the evaluation of MatchCEL.
+// +k8s:deepcopy-gen=false
+type CELTrace struct {
+	Expression string `json:"expression"`
+	Matched    bool   `json:"matched"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ExecuteWithTrace executes the rule against a set of input features, like
+// Execute, and returns a trace of the evaluation in addition to the output.
+// The trace is returned also when an error occurs.
+func (r *Rule) ExecuteWithTrace(features feature.Features) (RuleOutput, *RuleTrace, error) {
+	return r.executeWithTrace(features, nil)
+}
+
+// ExecuteWithTrace executes the rule within the EvalContext, like
+// EvalContext.Execute, and returns a trace of the evaluation.
+func (c *EvalContext) ExecuteWithTrace(r *Rule, features feature.Features) (RuleOutput, *RuleTrace, error) {
+	return r.executeWithTrace(features, c)
+}
+
+func (r *Rule) executeWithTrace(features feature.Features, ctx *EvalContext) (RuleOutput, *RuleTrace, error) {
+	trace := &RuleTrace{Name: r.Name}
+	out, err := r.execute(features, trace, ctx)
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	return out, trace, err
+}
+
+// String returns a human-readable representation of the trace.
+func (t *RuleTrace) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "rule %q: %s", t.Name, matchString(t.Matched))
+	if t.Error != "" {
+		fmt.Fprintf(&b, " (error: %s)", t.Error)
+	}
+	b.WriteString("\n")
+
+	for _, term := range t.Terms {
+		fmt.Fprintf(&b, "  %s %s: %s", term.Path, term.Feature, matchString(term.Matched))
+		if term.Error != "" {
+			fmt.Fprintf(&b, " (error: %s)", term.Error)
+		}
+		b.WriteString("\n")
+		for _, e := range term.Expressions {
+			fmt.Fprintf(&b, "    %s\n", e.String())
+		}
+		for _, e := range term.Aggregates {
+			fmt.Fprintf(&b, "    aggregate %s\n", e.String())
+		}
+	}
+	if t.CEL != nil {
+		fmt.Fprintf(&b, "  matchCEL %q: %s", t.CEL.Expression, matchString(t.CEL.Matched))
+		if t.CEL.Error != "" {
+			fmt.Fprintf(&b, " (error: %s)", t.CEL.Error)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// String returns a human-readable representation of the expression trace.
+func (t *ExpressionTrace) String() string {
+	s := fmt.Sprintf("%s %s %v: %s, input %q", t.Key, t.Op, []string(t.Value), matchString(t.Matched), t.Input)
+	if t.Error != "" {
+		s += fmt.Sprintf(" (error: %s)", t.Error)
+	}
+	return s
+}
+
+func matchString(matched bool) string {
+	if matched {
+		return "matched"
+	}
+	return "no match"
+}
+
+// addTerm adds the trace of one FeatureMatcherTerm. The expressions of the
+// term are re-evaluated one-by-one in order to record their result. Features
+// are nil if the feature was not available.
+func (t *RuleTrace) addTerm(path string, term *FeatureMatcherTerm, features *feature.DomainFeatures, featureName string, matched bool, err error) {
+	if t == nil {
+		return
+	}
+
+	tt := TermTrace{Path: path, Feature: term.Feature, Matched: matched}
+	if err != nil {
+		tt.Error = err.Error()
+	}
+
+	if features != nil {
+		for _, k := range sortedExpressionKeys(term.MatchExpressions) {
+			tt.Expressions = append(tt.Expressions, traceExpression(k, term.MatchExpressions[k], features, featureName))
+		}
+
+		if f, ok := features.Instances[featureName]; ok && len(term.MatchAggregates) > 0 {
+			if v, err := term.MatchExpressions.MatchGetInstances(f.Elements); err == nil {
+				aggregates := aggregateInstances(v)
+				values := aggregates.values()
+				for _, k := range sortedExpressionKeys(term.MatchAggregates) {
+					et := ExpressionTrace{Key: k, Op: term.MatchAggregates[k].Op, Value: term.MatchAggregates[k].Value, Input: []string{}}
+					v, ok := values[k]
+					if ok {
+						et.Input = append(et.Input, v)
+					}
+					et.Matched, err = term.MatchAggregates[k].Match(ok, v)
+					if err != nil {
+						et.Error = err.Error()
+					}
+					tt.Aggregates = append(tt.Aggregates, et)
+				}
+			}
+		}
+	}
+
+	t.Terms = append(t.Terms, tt)
+}
+
+// setCEL records the evaluation of MatchCEL.
+func (t *RuleTrace) setCEL(expr string, matched bool, err error) {
+	if t == nil {
+		return
+	}
+	t.CEL = &CELTrace{Expression: expr, Matched: matched}
+	if err != nil {
+		t.CEL.Error = err.Error()
+	}
+}
+
+// setMatched records the final result of the rule evaluation.
+func (t *RuleTrace) setMatched(matched bool) {
+	if t != nil {
+		t.Matched = matched
+	}
+}
+
+func traceExpression(key string, e *MatchExpression, features *feature.DomainFeatures, featureName string) ExpressionTrace {
+	ret := ExpressionTrace{Key: key, Op: e.Op, Value: e.Value, Input: []string{}}
+
+	var err error
+	if f, ok := features.Keys[featureName]; ok {
+		if _, ok := f.Elements[key]; ok {
+			ret.Input = append(ret.Input, key)
+		}
+		ret.Matched, err = e.MatchKeys(key, f.Elements)
+	} else if f, ok := features.Values[featureName]; ok {
+		if v, ok := f.Elements[key]; ok {
+			ret.Input = append(ret.Input, v)
+		}
+		ret.Matched, err = e.MatchValues(key, f.Elements)
+	} else if f, ok := features.Instances[featureName]; ok {
+		for _, i := range f.Elements {
+			if v, ok := i.Attributes[key]; ok {
+				ret.Input = append(ret.Input, v)
+			}
+			var m bool
+			if m, err = e.MatchValues(key, i.Attributes); err != nil {
+				break
+			} else if m {
+				ret.Matched = true
+			}
+		}
+	}
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	return ret
+}
+
+func sortedExpressionKeys(m MatchExpressionSet) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -48,6 +48,13 @@ type NodeFeatureRule struct {
 type NodeFeatureRuleSpec struct {
 	// Rules is a list of node customization rules.
 	Rules []Rule `json:"rules"`
+
+	// ExportVars lists the vars created by the rules of this object that are
+	// visible to the rules of other NodeFeatureRule objects. Other vars are
+	// only visible to the rules of this object. The special value "*" exports
+	// all vars.
+	// +optional
+	ExportVars []string `json:"exportVars,omitempty"`
 }
 
 // Rule defines a rule for node customization such as labeling.
@@ -78,6 +85,14 @@ type Rule struct {
 	// +optional
 	VarsTemplate string `json:"varsTemplate"`
 
+	// LabelsOnNoMatch specifies labels to create if the rule does not match.
+	// +optional
+	LabelsOnNoMatch map[string]string `json:"labelsOnNoMatch,omitempty"`
+
+	// VarsOnNoMatch specifies vars to store if the rule does not match.
+	// +optional
+	VarsOnNoMatch map[string]string `json:"varsOnNoMatch,omitempty"`
+
 	// MatchFeatures specifies a set of matcher terms all of which must match.
 	// +optional
 	MatchFeatures FeatureMatcher `json:"matchFeatures"`
@@ -86,15 +101,102 @@ type Rule struct {
 	// +optional
 	MatchAny []MatchAnyElem `json:"matchAny"`
 
+	// MatchAll specifies a list of matchers all of which must match.
+	// +optional
+	MatchAll []MatchAnyElem `json:"matchAll,omitempty"`
+
+	// MatchNone specifies a list of matchers none of which must match.
+	// +optional
+	MatchNone []MatchAnyElem `json:"matchNone,omitempty"`
+
+	// MatchCEL specifies a Common Expression Language (CEL) expression that
+	// must evaluate to true. The expression has access to all features via
+	// the "keys", "values" and "instances" variables, each of which is a map
+	// indexed by feature name (<domain>.<feature>).
+	// +optional
+	MatchCEL string `json:"matchCEL,omitempty"`
+
+	// Score specifies a numeric score to compute from weighted matchers if
+	// the rule matches.
+	// +optional
+	Score *RuleScore `json:"score,omitempty"`
+
+	// DependsOn lists the names of rules this rule depends on, i.e. rules
+	// whose output this rule references through the rule.matched feature.
+	// The rules are evaluated before this rule, regardless of their position.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// DependsOnVars lists the names of vars this rule depends on. The rules
+	// specifying the vars in their Vars or VarsOnNoMatch field, or as the var
+	// of their Score, are evaluated before this rule.
+	// +optional
+	DependsOnVars []string `json:"dependsOnVars,omitempty"`
+
 	// private helpers/cache for handling golang templates
 	labelsTemplate *templateHelper `json:"-"`
 	varsTemplate   *templateHelper `json:"-"`
+
+	// private cache for the compiled CEL expression
+	celProgram *celHelper `json:"-"`
+}
+
+// RuleScore specifies an integer score computed by summing up the weights of
+// the matching terms. The score is stored as a label and/or a var.
+type RuleScore struct {
+	// Label is the name of the label to create with the score as its value.
+	// +optional
+	Label string `json:"label,omitempty"`
+
+	// Var is the name of the var to store the score in.
+	// +optional
+	Var string `json:"var,omitempty"`
+
+	// Terms is the list of weighted matchers the score is computed from.
+	Terms []ScoreTerm `json:"terms"`
 }
 
-// MatchAnyElem specifies one sub-matcher of MatchAny.
+// ScoreTerm is one weighted matcher of a RuleScore.
+type ScoreTerm struct {
+	// Weight to add to the score if the term matches. Negative weights are
+	// allowed.
+	Weight int64 `json:"weight"`
+
+	// MatchAnyElem specifies the matcher of the term.
+	MatchAnyElem `json:",inline"`
+}
+
+// MatchAnyElem specifies one sub-matcher of MatchAny, MatchAll or MatchNone.
+// Sub-matchers may be nested. A sub-matcher matches if all of its fields
+// match.
 type MatchAnyElem struct {
 	// MatchFeatures specifies a set of matcher terms all of which must match.
+	// +optional
 	MatchFeatures FeatureMatcher `json:"matchFeatures"`
+
+	// MatchAny specifies a list of nested matchers one of which must match.
+	// Nested matchers are not validated by the API server.
+	// +optional
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	MatchAny []MatchAnyElem `json:"matchAny,omitempty"`
+
+	// MatchAll specifies a list of nested matchers all of which must match.
+	// Nested matchers are not validated by the API server.
+	// +optional
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	MatchAll []MatchAnyElem `json:"matchAll,omitempty"`
+
+	// MatchNone specifies a list of nested matchers none of which must match.
+	// Nested matchers are not validated by the API server.
+	// +optional
+	// +kubebuilder:validation:Type=array
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	MatchNone []MatchAnyElem `json:"matchNone,omitempty"`
 }
 
 // FeatureMatcher specifies a set of feature matcher terms (i.e. per-feature
@@ -107,6 +209,40 @@ type FeatureMatcher []FeatureMatcherTerm
 type FeatureMatcherTerm struct {
 	Feature          string             `json:"feature"`
 	MatchExpressions MatchExpressionSet `json:"matchExpressions"`
+
+	// MatchAggregates specifies requirements against aggregate values
+	// calculated over the instances that matched MatchExpressions. Keys are
+	// the names of the aggregates, i.e. "count", "sum(<attribute>)",
+	// "min(<attribute>)" or "max(<attribute>)". Only applicable to instance
+	// features.
+	// +optional
+	MatchAggregates MatchExpressionSet `json:"matchAggregates,omitempty"`
+
+	// Joins specifies other instance features that the instances of this
+	// feature are joined with before evaluating MatchExpressions. Attributes
+	// of the joined instances are available to MatchExpressions and templates
+	// prefixed with the name of the joined feature, e.g. "pci_device_vendor".
+	// Instances without a counterpart in the joined feature are dropped. Only
+	// applicable to instance features.
+	// +optional
+	Joins []FeatureJoin `json:"joins,omitempty"`
+}
+
+// FeatureJoin specifies an instance feature to join with and the attributes
+// whose values must be equal in the joined instances.
+type FeatureJoin struct {
+	// Feature is the instance feature to join with, in <domain>.<feature>
+	// format.
+	Feature string `json:"feature"`
+
+	// Attribute is the attribute of the instances being matched that is used
+	// as the join key.
+	Attribute string `json:"attribute"`
+
+	// JoinAttribute is the attribute of the joined instances that must be
+	// equal to Attribute. Defaults to the same attribute name as Attribute.
+	// +optional
+	JoinAttribute string `json:"joinAttribute,omitempty"`
 }
 
 // MatchExpressionSet contains a set of MatchExpressions, each of which is
@@ -130,8 +266,10 @@ type MatchExpression struct {
 	// Value is the list of values that the operand evaluates the input
 	// against. Value should be empty if the operator is Exists, DoesNotExist,
 	// IsTrue or IsFalse. Value should contain exactly one element if the
-	// operator is Gt or Lt and exactly two elements if the operator is GtLt.
-	// In other cases Value should contain at least one element.
+	// operator is Gt, Lt, VersionGt, VersionGe, VersionLt, VersionLe,
+	// QuantityGt or QuantityLt and exactly two elements if the operator is
+	// GtLt, VersionInRange or QuantityGtLt. In other cases Value should
+	// contain at least one element.
 	// +optional
 	Value MatchValue `json:"value,omitempty"`
 
@@ -141,7 +279,7 @@ type MatchExpression struct {
 
 // MatchOp is the match operator that is applied on values when evaluating a
 // MatchExpression.
-// +kubebuilder:validation:Enum="In";"NotIn";"InRegexp";"Exists";"DoesNotExist";"Gt";"Lt";"GtLt";"IsTrue";"IsFalse"
+// +kubebuilder:validation:Enum="In";"NotIn";"InRegexp";"Exists";"DoesNotExist";"Gt";"Lt";"GtLt";"IsTrue";"IsFalse";"VersionGt";"VersionGe";"VersionLt";"VersionLe";"VersionInRange";"QuantityGt";"QuantityLt";"QuantityGtLt"
 type MatchOp string
 
 // MatchValue is the list of values associated with a MatchExpression.
@@ -186,6 +324,46 @@ const (
 	// MatchIsFalse returns true if the input holds the value "false". The
 	// expression must not have any values.
 	MatchIsFalse MatchOp = "IsFalse"
+	// MatchVersionGt returns true if the input is a version greater than the
+	// value of the expression (number of values in the expression must be
+	// exactly one). Both the input and the value must be valid version
+	// strings (e.g. "5.15.0-56-generic" or "v1.24.2"), otherwise an error is
+	// returned.
+	MatchVersionGt MatchOp = "VersionGt"
+	// MatchVersionGe returns true if the input is a version greater than or
+	// equal to the value of the expression (number of values in the
+	// expression must be exactly one).
+	MatchVersionGe MatchOp = "VersionGe"
+	// MatchVersionLt returns true if the input is a version less than the
+	// value of the expression (number of values in the expression must be
+	// exactly one).
+	MatchVersionLt MatchOp = "VersionLt"
+	// MatchVersionLe returns true if the input is a version less than or
+	// equal to the value of the expression (number of values in the
+	// expression must be exactly one).
+	MatchVersionLe MatchOp = "VersionLe"
+	// MatchVersionInRange returns true if the input is a version in the range
+	// specified by the expression, i.e. greater than or equal to the first
+	// value and less than the second value (number of values in the
+	// expression must be exactly two).
+	MatchVersionInRange MatchOp = "VersionInRange"
+	// MatchQuantityGt returns true if the input is greater than the value of
+	// the expression (number of values in the expression must be exactly
+	// one). Both the input and the value must be floating point numbers or
+	// Kubernetes resource quantities (e.g. "2.5", "1e3" or "16Gi"), otherwise
+	// an error is returned.
+	MatchQuantityGt MatchOp = "QuantityGt"
+	// MatchQuantityLt returns true if the input is less than the value of the
+	// expression (number of values in the expression must be exactly one).
+	// Both the input and the value must be floating point numbers or
+	// Kubernetes resource quantities, otherwise an error is returned.
+	MatchQuantityLt MatchOp = "QuantityLt"
+	// MatchQuantityGtLt returns true if the input is between two values, i.e.
+	// greater than the first value and less than the second value of the
+	// expression (number of values in the expression must be exactly two).
+	// Both the input and the values must be floating point numbers or
+	// Kubernetes resource quantities, otherwise an error is returned.
+	MatchQuantityGtLt MatchOp = "QuantityGtLt"
 )
 
 const (
@@ -196,3 +374,25 @@ const (
 	// output of preceding rules.
 	RuleBackrefFeature = "matched"
 )
+
+const (
+	// NodeDomain is the special feature domain for matching against the
+	// metadata and status of the Kubernetes node object. It is injected by
+	// nfd-master and not available for rules in the custom feature source.
+	NodeDomain = "node"
+	// NodeLabelFeature is the feature containing the labels of the node,
+	// excluding labels managed by NFD itself.
+	NodeLabelFeature = "label"
+	// NodeAnnotationFeature is the feature containing the annotations of the
+	// node, excluding NFD annotations.
+	NodeAnnotationFeature = "annotation"
+	// NodeInfoFeature is the feature containing the status.nodeInfo of the
+	// node.
+	NodeInfoFeature = "nodeinfo"
+	// NodeCapacityFeature is the feature containing the status.capacity of
+	// the node.
+	NodeCapacityFeature = "capacity"
+	// NodeAllocatableFeature is the feature containing the
+	// status.allocatable of the node.
+	NodeAllocatableFeature = "allocatable"
+)
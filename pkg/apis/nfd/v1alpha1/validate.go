@@ -0,0 +1,154 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+)
+
+// Validate checks the rule for errors that can be detected without evaluating
+// it against any features, i.e. malformed feature names, match expressions,
+// joins, aggregates and scores, and compiles its templates and CEL
+// expression. The compiled templates and CEL program are cached for
+// subsequent evaluations of the rule. Errors in specific fields of the rule
+// are returned as utils.FieldError.
+func (r *Rule) Validate() error {
+	if err := validateNested(r.MatchAll, r.MatchAny, r.MatchNone, ""); err != nil {
+		return err
+	}
+	if err := r.MatchFeatures.validate("matchFeatures"); err != nil {
+		return err
+	}
+
+	if r.Score != nil {
+		if r.Score.Label == "" && r.Score.Var == "" {
+			return &utils.FieldError{Path: "score", Err: fmt.Errorf("invalid score: label or var must be specified")}
+		}
+		for i, t := range r.Score.Terms {
+			if err := t.MatchAnyElem.validate(fmt.Sprintf("score.terms[%d]", i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.LabelsTemplate != "" {
+		t, err := newTemplateHelper(r.LabelsTemplate)
+		if err != nil {
+			return &utils.FieldError{Path: "labelsTemplate", Err: fmt.Errorf("failed to parse LabelsTemplate: %w", err)}
+		}
+		r.labelsTemplate = t
+	}
+	if r.VarsTemplate != "" {
+		t, err := newTemplateHelper(r.VarsTemplate)
+		if err != nil {
+			return &utils.FieldError{Path: "varsTemplate", Err: fmt.Errorf("failed to parse VarsTemplate: %w", err)}
+		}
+		r.varsTemplate = t
+	}
+	if r.MatchCEL != "" {
+		h, err := newCELHelper(r.MatchCEL)
+		if err != nil {
+			return &utils.FieldError{Path: "matchCEL", Err: fmt.Errorf("failed to compile MatchCEL: %w", err)}
+		}
+		r.celProgram = h
+	}
+
+	return nil
+}
+
+// validate checks a sub-matcher and all of its nested sub-matchers.
+func (e *MatchAnyElem) validate(path string) error {
+	if err := validateNested(e.MatchAll, e.MatchAny, e.MatchNone, path+"."); err != nil {
+		return err
+	}
+	return e.MatchFeatures.validate(path + ".matchFeatures")
+}
+
+// validateNested checks a set of nested sub-matchers.
+func validateNested(matchAll, matchAny, matchNone []MatchAnyElem, path string) error {
+	for i, e := range matchAny {
+		if err := e.validate(fmt.Sprintf("%smatchAny[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, e := range matchAll {
+		if err := e.validate(fmt.Sprintf("%smatchAll[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, e := range matchNone {
+		if err := e.validate(fmt.Sprintf("%smatchNone[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the feature names, expressions, aggregates and joins of
+// the matcher terms.
+func (m *FeatureMatcher) validate(path string) error {
+	for i, term := range *m {
+		termPath := fmt.Sprintf("%s[%d]", path, i)
+
+		if split := strings.SplitN(term.Feature, ".", 2); len(split) != 2 {
+			return &utils.FieldError{Path: termPath, Err: fmt.Errorf("invalid feature %q: must be <domain>.<feature>", term.Feature)}
+		}
+		if err := term.MatchExpressions.validate(); err != nil {
+			return &utils.FieldError{Path: termPath, Err: err}
+		}
+		for n := range term.MatchAggregates {
+			if !aggregateNameRe.MatchString(n) {
+				return &utils.FieldError{Path: termPath, Err: fmt.Errorf("invalid aggregate %q: must be count, sum(<attribute>), min(<attribute>) or max(<attribute>)", n)}
+			}
+		}
+		if err := term.MatchAggregates.validate(); err != nil {
+			return &utils.FieldError{Path: termPath, Err: err}
+		}
+		for j, join := range term.Joins {
+			joinPath := fmt.Sprintf("%s.joins[%d]", termPath, j)
+			if split := strings.SplitN(join.Feature, ".", 2); len(split) != 2 {
+				return &utils.FieldError{Path: joinPath, Err: fmt.Errorf("invalid join feature %q: must be <domain>.<feature>", join.Feature)}
+			}
+			if join.Attribute == "" {
+				return &utils.FieldError{Path: joinPath, Err: fmt.Errorf("invalid join with %q: attribute must be specified", join.Feature)}
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks all expressions of the set, in a deterministic order.
+func (m MatchExpressionSet) validate() error {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if e := m[n]; e != nil {
+			if err := e.Validate(); err != nil {
+				return fmt.Errorf("invalid expression for %q: %w", n, err)
+			}
+		}
+	}
+	return nil
+}
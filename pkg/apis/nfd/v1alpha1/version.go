@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// versionSegment is one component of a version string. Numeric segments are
+// compared as numbers and alphabetic segments lexically.
+type versionSegment struct {
+	num   *big.Int
+	alpha string
+	// pre marks the start of a pre-release, which sorts before anything else
+	pre bool
+}
+
+// parseVersion splits a version string into numeric and alphabetic segments.
+// A leading "v" (as in "v1.2.3") is dropped. A "~", or a "-" directly followed
+// by a letter (as in "1.2.3-rc.1"), marks the start of a pre-release. All
+// other non-alphanumeric characters are treated as separators. The version
+// must start with a number.
+func parseVersion(s string) ([]versionSegment, error) {
+	v := strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if v == "" || !isDigit(v[0]) {
+		return nil, fmt.Errorf("invalid version %q: must start with a number", s)
+	}
+
+	segments := []versionSegment{}
+	for i := 0; i < len(v); {
+		c := v[i]
+		switch {
+		case isDigit(c):
+			j := i
+			for j < len(v) && isDigit(v[j]) {
+				j++
+			}
+			n, _ := new(big.Int).SetString(v[i:j], 10)
+			segments = append(segments, versionSegment{num: n})
+			i = j
+		case isAlpha(c):
+			j := i
+			for j < len(v) && isAlpha(v[j]) {
+				j++
+			}
+			segments = append(segments, versionSegment{alpha: v[i:j]})
+			i = j
+		case c == '~', c == '-' && i+1 < len(v) && isAlpha(v[i+1]):
+			segments = append(segments, versionSegment{pre: true})
+			i++
+		default:
+			i++
+		}
+	}
+	return segments, nil
+}
+
+// compareVersions compares two version strings. It returns -1, 0 or 1 if a is
+// less than, equal to or greater than b, respectively. The ordering follows
+// that of rpm and dpkg: numeric segments are greater than alphabetic segments
+// and if one version is a prefix of the other, the longer one is greater
+// (e.g. a distro suffix like "4.18.0-372.9.1.el8.x86_64" > "4.18.0-372.9.1"
+// or a kernel ABI suffix like "5.15.0-91-generic" > "5.15.0"). As in semver,
+// a pre-release is less than the release (e.g. "1.2.0-rc.1" < "1.2.0" and
+// "1.2.0~rc1" < "1.2.0"). Trailing zero segments are insignificant, i.e.
+// "1.0" == "1.0.0".
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(va) && i < len(vb); i++ {
+		if c := va[i].compare(vb[i]); c != 0 {
+			return c, nil
+		}
+	}
+
+	switch {
+	case len(va) > len(vb):
+		return suffixOrder(va[len(vb):]), nil
+	case len(va) < len(vb):
+		return -suffixOrder(vb[len(va):]), nil
+	}
+	return 0, nil
+}
+
+func (s versionSegment) compare(o versionSegment) int {
+	switch {
+	case s.pre || o.pre:
+		if s.pre && o.pre {
+			return 0
+		} else if s.pre {
+			return -1
+		}
+		return 1
+	case s.num != nil && o.num != nil:
+		return s.num.Cmp(o.num)
+	case s.num != nil:
+		return 1
+	case o.num != nil:
+		return -1
+	}
+	return strings.Compare(s.alpha, o.alpha)
+}
+
+// suffixOrder returns the ordering of a version having the given extra
+// segments, relative to the version without them.
+func suffixOrder(extra []versionSegment) int {
+	for _, s := range extra {
+		switch {
+		case s.pre:
+			return -1
+		case s.num != nil && s.num.Sign() == 0:
+			// Skip trailing zeros
+			continue
+		}
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	type TC struct {
+		a, b   string
+		result int
+	}
+
+	tcs := []TC{
+		{a: "1", b: "1", result: 0},
+		{a: "1.2.3", b: "v1.2.3", result: 0},
+		{a: "1.2.3", b: "1.2-3", result: 0},
+		{a: "1.10", b: "1.9", result: 1},
+		{a: "1.01", b: "1.1", result: 0},
+		{a: "5.15.0-56-generic", b: "5.15.0", result: 1},
+		{a: "5.15.0-56-generic", b: "5.15.0-100-generic", result: -1},
+		{a: "4.18.0-372.9.1.el8.x86_64", b: "4.18.0-372.9.1", result: 1},
+		{a: "4.18.0-372.9.1.el8", b: "4.18.0-372.9.1.el9", result: -1},
+		{a: "4.18.0-372.9.1.el8_6", b: "4.18.0-372.9.1.el8", result: 1},
+		{a: "1.0", b: "1.0.0", result: 0},
+		{a: "1", b: "1.0.0.0", result: 0},
+		{a: "1.0", b: "1.0.0.1", result: -1},
+		{a: "1.0.0.el8", b: "1.0", result: 1},
+		// Kernel ABI and flavor suffixes make the version greater
+		{a: "5.15.0-91-generic", b: "5.15.0", result: 1},
+		{a: "5.15.0-91-generic", b: "5.15", result: 1},
+		{a: "5.15.0-91-generic", b: "5.15.0-100-generic", result: -1},
+		{a: "5.15.0-91-generic", b: "5.15.0-91-lowlatency", result: -1},
+		{a: "5.15.0-91-generic", b: "5.16.0-rc1", result: -1},
+		// A "-" followed by a letter is a semver pre-release, less than the
+		// release
+		{a: "1.24.0-rc.1", b: "1.24.0", result: -1},
+		{a: "v1.25.0-rc.1", b: "1.25.0", result: -1},
+		{a: "1.25.0-rc.1", b: "1.24.9", result: 1},
+		{a: "1.24.0-rc.1", b: "1.24.0-rc.2", result: -1},
+		{a: "1.24.0-alpha", b: "1.24.0-beta", result: -1},
+		{a: "1.24.0-alpha.1", b: "1.24.0-alpha", result: 1},
+		{a: "1.24.0-beta.2", b: "1.24.0-rc.1", result: -1},
+		{a: "1.24.0-rc.1", b: "1.24.1-alpha", result: -1},
+		{a: "1.24.0-rc.1", b: "1.24.0~rc.1", result: 0},
+		// A "~" always marks a pre-release
+		{a: "1.24.0~rc.1", b: "1.24.0", result: -1},
+		{a: "1.24.0.0~rc.1", b: "1.24.0", result: -1},
+		{a: "1.0~rc1", b: "1.0", result: -1},
+		{a: "1.0~rc1", b: "1.0a", result: -1},
+		{a: "1.0.1", b: "1.0.rc1", result: 1},
+		{a: "2022.10.0", b: "18446744073709551616.0", result: -1},
+	}
+
+	for i, tc := range tcs {
+		c, err := compareVersions(tc.a, tc.b)
+		assert.Nilf(t, err, "test case #%d (%v) failed", i, tc)
+		assert.Equalf(t, tc.result, c, "test case #%d (%v) failed", i, tc)
+
+		c, err = compareVersions(tc.b, tc.a)
+		assert.Nilf(t, err, "reverse test case #%d (%v) failed", i, tc)
+		assert.Equalf(t, -tc.result, c, "reverse test case #%d (%v) failed", i, tc)
+	}
+
+	for _, v := range []string{"", "v", "abc", "-1", ".1"} {
+		_, err := compareVersions(v, "1.0")
+		assert.Errorf(t, err, "invalid version %q should have failed", v)
+	}
+}
@@ -9,6 +9,21 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FeatureJoin) DeepCopyInto(out *FeatureJoin) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FeatureJoin.
+func (in *FeatureJoin) DeepCopy() *FeatureJoin {
+	if in == nil {
+		return nil
+	}
+	out := new(FeatureJoin)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in FeatureMatcher) DeepCopyInto(out *FeatureMatcher) {
 	{
@@ -48,6 +63,26 @@ func (in *FeatureMatcherTerm) DeepCopyInto(out *FeatureMatcherTerm) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.MatchAggregates != nil {
+		in, out := &in.MatchAggregates, &out.MatchAggregates
+		*out = make(MatchExpressionSet, len(*in))
+		for key, val := range *in {
+			var outVal *MatchExpression
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = new(MatchExpression)
+				(*in).DeepCopyInto(*out)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Joins != nil {
+		in, out := &in.Joins, &out.Joins
+		*out = make([]FeatureJoin, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FeatureMatcherTerm.
@@ -70,6 +105,27 @@ func (in *MatchAnyElem) DeepCopyInto(out *MatchAnyElem) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MatchAny != nil {
+		in, out := &in.MatchAny, &out.MatchAny
+		*out = make([]MatchAnyElem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MatchAll != nil {
+		in, out := &in.MatchAll, &out.MatchAll
+		*out = make([]MatchAnyElem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MatchNone != nil {
+		in, out := &in.MatchNone, &out.MatchNone
+		*out = make([]MatchAnyElem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MatchAnyElem.
@@ -270,6 +326,11 @@ func (in *NodeFeatureRuleSpec) DeepCopyInto(out *NodeFeatureRuleSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ExportVars != nil {
+		in, out := &in.ExportVars, &out.ExportVars
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeFeatureRuleSpec.
@@ -299,6 +360,20 @@ func (in *Rule) DeepCopyInto(out *Rule) {
 			(*out)[key] = val
 		}
 	}
+	if in.LabelsOnNoMatch != nil {
+		in, out := &in.LabelsOnNoMatch, &out.LabelsOnNoMatch
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.VarsOnNoMatch != nil {
+		in, out := &in.VarsOnNoMatch, &out.VarsOnNoMatch
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.MatchFeatures != nil {
 		in, out := &in.MatchFeatures, &out.MatchFeatures
 		*out = make(FeatureMatcher, len(*in))
@@ -313,6 +388,35 @@ func (in *Rule) DeepCopyInto(out *Rule) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MatchAll != nil {
+		in, out := &in.MatchAll, &out.MatchAll
+		*out = make([]MatchAnyElem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MatchNone != nil {
+		in, out := &in.MatchNone, &out.MatchNone
+		*out = make([]MatchAnyElem, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Score != nil {
+		in, out := &in.Score, &out.Score
+		*out = new(RuleScore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DependsOnVars != nil {
+		in, out := &in.DependsOnVars, &out.DependsOnVars
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.labelsTemplate != nil {
 		in, out := &in.labelsTemplate, &out.labelsTemplate
 		*out = (*in).DeepCopy()
@@ -321,6 +425,10 @@ func (in *Rule) DeepCopyInto(out *Rule) {
 		in, out := &in.varsTemplate, &out.varsTemplate
 		*out = (*in).DeepCopy()
 	}
+	if in.celProgram != nil {
+		in, out := &in.celProgram, &out.celProgram
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Rule.
@@ -332,3 +440,41 @@ func (in *Rule) DeepCopy() *Rule {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleScore) DeepCopyInto(out *RuleScore) {
+	*out = *in
+	if in.Terms != nil {
+		in, out := &in.Terms, &out.Terms
+		*out = make([]ScoreTerm, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleScore.
+func (in *RuleScore) DeepCopy() *RuleScore {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleScore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScoreTerm) DeepCopyInto(out *ScoreTerm) {
+	*out = *in
+	in.MatchAnyElem.DeepCopyInto(&out.MatchAnyElem)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScoreTerm.
+func (in *ScoreTerm) DeepCopy() *ScoreTerm {
+	if in == nil {
+		return nil
+	}
+	out := new(ScoreTerm)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -87,8 +87,20 @@ func NewNfdBaseClient(args *Args) (NfdBaseClient, error) {
 // ClientConn returns the grpc ClientConn object.
 func (w *NfdBaseClient) ClientConn() *grpc.ClientConn { return w.clientConn }
 
-// Connect creates a gRPC client connection to nfd-master.
+// Connect creates a gRPC client connection to nfd-master, blocking until the
+// connection is up or a timeout expires.
 func (w *NfdBaseClient) Connect() error {
+	return w.connect(grpc.WithBlock())
+}
+
+// ConnectNonBlocking creates a gRPC client connection to nfd-master without
+// waiting for the connection to be established. gRPC connects, and
+// re-connects, in the background.
+func (w *NfdBaseClient) ConnectNonBlocking() error {
+	return w.connect()
+}
+
+func (w *NfdBaseClient) connect(opts ...grpc.DialOption) error {
 	// Check that if a connection already exists
 	if w.clientConn != nil {
 		return fmt.Errorf("client connection already exists")
@@ -97,7 +109,7 @@ func (w *NfdBaseClient) Connect() error {
 	// Dial and create a client
 	dialCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
-	dialOpts := []grpc.DialOption{grpc.WithBlock()}
+	dialOpts := opts
 	if w.args.CaFile != "" || w.args.CertFile != "" || w.args.KeyFile != "" {
 		// Load client cert for client authentication
 		cert, err := tls.LoadX509KeyPair(w.args.CertFile, w.args.KeyFile)
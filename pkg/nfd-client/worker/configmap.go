@@ -0,0 +1,348 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	k8sclient "k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdclient "sigs.k8s.io/node-feature-discovery/pkg/nfd-client"
+)
+
+const (
+	// ConfigMapConfigKey is the key of the base worker configuration in the
+	// configuration ConfigMap
+	ConfigMapConfigKey = "nfd-worker.conf"
+	// ConfigMapOverridesKey is the key of the per-node configuration
+	// overrides in the configuration ConfigMap
+	ConfigMapOverridesKey = "nfd-worker-overrides.conf"
+)
+
+// configOverride is a configuration override applied on the nodes matching
+// the node selector.
+type configOverride struct {
+	// Name of the override, used in log and error messages
+	Name string `json:"name"`
+	// NodeSelector selects the nodes the override applies to. An empty
+	// selector matches all nodes.
+	NodeSelector map[string]string `json:"nodeSelector"`
+	// Config is the configuration to layer on top of the base configuration,
+	// in the same format as the config file.
+	Config json.RawMessage `json:"config"`
+}
+
+// configLayer is one piece of the configuration, parsed on top of the
+// preceding layers.
+type configLayer struct {
+	name string
+	data []byte
+}
+
+// configMapSource reads the worker configuration from a ConfigMap, watching
+// it and the labels of the node for changes.
+type configMapSource struct {
+	namespace  string
+	name       string
+	nodeName   string
+	lister     corev1listers.ConfigMapNamespaceLister
+	nodeLister corev1listers.NodeLister
+
+	// resourceVersion is the version of the ConfigMap last read, empty if
+	// the ConfigMap did not exist. Events of this version are ignored.
+	resourceVersion string
+	// overrides are the configuration overrides last read and matched the
+	// indices of those that applied to the node. Node updates that do not
+	// change the set of matching overrides are ignored.
+	overrides []configOverride
+	matched   []int
+	lock      sync.Mutex
+
+	events chan struct{}
+	stop   chan struct{}
+}
+
+// newKubeClient creates a client for the Kubernetes API.
+func newKubeClient(kubeconfigPath string) (k8sclient.Interface, error) {
+	kubeconfig, err := apihelper.GetKubeconfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return k8sclient.NewForConfig(kubeconfig)
+}
+
+// parseConfigMapRef parses a ConfigMap reference of the form
+// [<namespace>/]<name>. The namespace defaults to that of the pod, read from
+// the POD_NAMESPACE environment variable.
+func parseConfigMapRef(ref string) (string, string, error) {
+	namespace, name := os.Getenv("POD_NAMESPACE"), ref
+	if split := strings.SplitN(ref, "/", 2); len(split) == 2 {
+		namespace, name = split[0], split[1]
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid -config-map %q: name must be specified", ref)
+	}
+	if namespace == "" {
+		return "", "", fmt.Errorf("invalid -config-map %q: namespace must be specified or the POD_NAMESPACE environment variable set", ref)
+	}
+	return namespace, name, nil
+}
+
+// newConfigMapSource starts watching a ConfigMap and the node object and
+// waits until their current state has been read.
+func newConfigMapSource(client k8sclient.Interface, namespace, name, nodeName string) (*configMapSource, error) {
+	s := &configMapSource{
+		namespace: namespace,
+		name:      name,
+		nodeName:  nodeName,
+		events:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := informerFactory.Core().V1().ConfigMaps()
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(object interface{}) {
+			s.notify(object.(metav1.Object).GetResourceVersion())
+		},
+		UpdateFunc: func(oldObject, newObject interface{}) {
+			s.notify(newObject.(metav1.Object).GetResourceVersion())
+		},
+		DeleteFunc: func(object interface{}) { s.notify("") },
+	})
+	s.lister = informer.Lister().ConfigMaps(namespace)
+
+	// Only watch our own node, for the labels selecting the overrides
+	nodeInformerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", nodeName).String()
+		}))
+	nodeInformer := nodeInformerFactory.Core().V1().Nodes()
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(object interface{}) {
+			s.notifyNode(object.(*corev1.Node))
+		},
+		UpdateFunc: func(oldObject, newObject interface{}) {
+			s.notifyNode(newObject.(*corev1.Node))
+		},
+	})
+	s.nodeLister = nodeInformer.Lister()
+
+	informerFactory.Start(s.stop)
+	nodeInformerFactory.Start(s.stop)
+	for _, ok := range informerFactory.WaitForCacheSync(s.stop) {
+		if !ok {
+			s.close()
+			return nil, fmt.Errorf("failed to sync ConfigMap %s", s)
+		}
+	}
+	for _, ok := range nodeInformerFactory.WaitForCacheSync(s.stop) {
+		if !ok {
+			s.close()
+			return nil, fmt.Errorf("failed to sync node %q", nodeName)
+		}
+	}
+	// Record the initial state so that its notification is dropped
+	s.lock.Lock()
+	_, err := s.get()
+	s.lock.Unlock()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// String returns the namespaced name of the ConfigMap.
+func (s *configMapSource) String() string {
+	return s.namespace + "/" + s.name
+}
+
+// notify signals that the ConfigMap has changed, unless the given version
+// has already been read. An empty version denotes a deleted ConfigMap.
+func (s *configMapSource) notify(resourceVersion string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if resourceVersion == s.resourceVersion {
+		return
+	}
+
+	klog.V(2).Infof("ConfigMap %s changed", s)
+	s.signal()
+}
+
+// notifyNode signals that the configuration overrides matching the node have
+// changed, i.e. that the labels of the node changed so that a different set
+// of overrides applies.
+func (s *configMapSource) notifyNode(node *corev1.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if reflect.DeepEqual(matchingOverrides(s.overrides, node.Labels), s.matched) {
+		return
+	}
+
+	klog.V(2).Infof("configuration overrides matching node %q changed", s.nodeName)
+	s.signal()
+}
+
+// signal sends a change event, unless one is already pending. Must be called
+// with the lock held.
+func (s *configMapSource) signal() {
+	select {
+	case s.events <- struct{}{}:
+	default:
+	}
+}
+
+// close stops watching the ConfigMap.
+func (s *configMapSource) close() {
+	close(s.stop)
+}
+
+// get returns the ConfigMap from the informer cache, recording its version.
+// A nil ConfigMap is returned if it does not exist. Pending notifications are
+// dropped as the informer cache is updated before event handlers are called,
+// i.e. they concern the version being read or an older one. Must be called
+// with the lock held.
+func (s *configMapSource) get() (*corev1.ConfigMap, error) {
+	cm, err := s.lister.Get(s.name)
+	if errors.IsNotFound(err) {
+		cm = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap %s: %v", s, err)
+	}
+
+	s.resourceVersion = ""
+	if cm != nil {
+		s.resourceVersion = cm.ResourceVersion
+	}
+	select {
+	case <-s.events:
+	default:
+	}
+
+	return cm, nil
+}
+
+// layers returns the base configuration and the overrides that apply to the
+// node, in the order they should be parsed. The lock is held throughout so
+// that concurrent changes of the node are compared against the state read.
+func (s *configMapSource) layers() ([]configLayer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.overrides, s.matched = nil, nil
+
+	cm, err := s.get()
+	if err != nil {
+		return nil, err
+	} else if cm == nil {
+		klog.Infof("ConfigMap %s not found, using defaults", s)
+		return nil, nil
+	}
+
+	layers := []configLayer{}
+	if data, ok := cm.Data[ConfigMapConfigKey]; ok {
+		layers = append(layers, configLayer{
+			name: fmt.Sprintf("key %q of ConfigMap %s", ConfigMapConfigKey, s),
+			data: []byte(data)})
+	}
+
+	data, ok := cm.Data[ConfigMapOverridesKey]
+	if !ok {
+		return layers, nil
+	}
+	overrides := []configOverride{}
+	if err := yaml.Unmarshal([]byte(data), &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse key %q of ConfigMap %s: %v", ConfigMapOverridesKey, s, err)
+	}
+
+	node, err := s.nodeLister.Get(s.nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %q for selecting configuration overrides: %v", s.nodeName, err)
+	}
+	s.overrides = overrides
+	s.matched = matchingOverrides(overrides, node.Labels)
+	matched := s.matched
+	for i, o := range overrides {
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("#%d", i)
+		}
+		if len(matched) == 0 || matched[0] != i {
+			klog.V(1).Infof("configuration override %q does not match node %q", o.Name, s.nodeName)
+			continue
+		}
+		matched = matched[1:]
+		klog.Infof("applying configuration override %q", o.Name)
+		layers = append(layers, configLayer{
+			name: fmt.Sprintf("override %q in key %q of ConfigMap %s", o.Name, ConfigMapOverridesKey, s),
+			data: o.Config})
+	}
+
+	return layers, nil
+}
+
+// matchingOverrides returns the indices of the overrides whose node selector
+// matches the given node labels, nil if none matches.
+func matchingOverrides(overrides []configOverride, nodeLabels map[string]string) []int {
+	var matched []int
+	for i, o := range overrides {
+		if !labels.SelectorFromSet(o.NodeSelector).Matches(labels.Set(nodeLabels)) {
+			continue
+		}
+		matched = append(matched, i)
+	}
+	return matched
+}
+
+// startConfigMapWatch starts watching the ConfigMap holding the worker
+// configuration.
+func (w *nfdWorker) startConfigMapWatch() error {
+	namespace, name, err := parseConfigMapRef(w.args.ConfigMap)
+	if err != nil {
+		return err
+	}
+	client, err := w.newKubeClient()
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %v", err)
+	}
+	w.configMap, err = newConfigMapSource(client, namespace, name, nfdclient.NodeName())
+	return err
+}
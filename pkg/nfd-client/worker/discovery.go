@@ -0,0 +1,304 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/source"
+)
+
+// Policies for handling feature sources whose discovery timed out
+const (
+	// DiscoveryTimeoutPolicyKeep keeps the features and labels from the
+	// previous completed discovery of the source.
+	DiscoveryTimeoutPolicyKeep = "keep"
+	// DiscoveryTimeoutPolicyDrop drops all features and labels of the source.
+	DiscoveryTimeoutPolicyDrop = "drop"
+)
+
+// sourceStatus describes the latest feature discovery of a source.
+type sourceStatus struct {
+	// LastDiscovery is the time when the latest discovery was started
+	LastDiscovery time.Time `json:"lastDiscovery"`
+	// Duration is the time the latest discovery took, or has taken so far
+	// if it timed out and is still running
+	Duration time.Duration `json:"duration"`
+	// TimedOut is true if the latest discovery did not complete in time
+	TimedOut bool `json:"timedOut,omitempty"`
+	// Error from the latest discovery, if any
+	Error string `json:"error,omitempty"`
+}
+
+// sourceDiscovery holds the discovery state of one feature source. A
+// discovery that timed out is left running in the background and the source
+// is not accessed until it completes.
+type sourceDiscovery struct {
+	sync.Mutex
+
+	running bool
+	// features is a snapshot of the features of the latest completed
+	// discovery
+	features *feature.DomainFeatures
+	labels   source.FeatureLabels
+	status   sourceStatus
+}
+
+// discoveryTimeout returns the discovery timeout of a feature source.
+func (c *coreConfig) discoveryTimeout(name string) time.Duration {
+	if d, ok := c.SourceDiscoveryTimeouts[name]; ok {
+		return d.Duration
+	}
+	return c.DiscoveryTimeout.Duration
+}
+
+// discoverFeatures runs feature discovery of the given feature sources in
+// parallel and waits until each of them completes or times out.
+func (w *nfdWorker) discoverFeatures(sources []source.FeatureSource) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, s := range sources {
+		d := w.getSourceDiscovery(s.Name())
+
+		d.Lock()
+		running := d.running
+		d.running = true
+		d.Unlock()
+		if running {
+			klog.Warningf("previous discovery of %q source has not completed, skipping", s.Name())
+			continue
+		}
+
+		wg.Add(1)
+		go func(s source.FeatureSource, d *sourceDiscovery, timeout time.Duration) {
+			defer wg.Done()
+			discoverSource(s, d, timeout)
+		}(s, d, w.config.Core.discoveryTimeout(s.Name()))
+	}
+	wg.Wait()
+
+	if klog.V(1).Enabled() {
+		timings := make([]string, 0, len(sources))
+		for _, s := range sources {
+			d := w.getSourceDiscovery(s.Name())
+			d.Lock()
+			t := s.Name() + "=" + d.status.Duration.String()
+			if d.status.TimedOut {
+				t += "(timeout)"
+			}
+			d.Unlock()
+			timings = append(timings, t)
+		}
+		sort.Strings(timings)
+		klog.Infof("feature discovery completed in %v: %s", time.Since(start), strings.Join(timings, ", "))
+	}
+}
+
+// getSourceDiscovery returns the discovery state of a feature source.
+func (w *nfdWorker) getSourceDiscovery(name string) *sourceDiscovery {
+	w.discoveryLock.Lock()
+	defer w.discoveryLock.Unlock()
+
+	if w.discovery == nil {
+		w.discovery = make(map[string]*sourceDiscovery)
+	}
+	d, ok := w.discovery[name]
+	if !ok {
+		d = &sourceDiscovery{}
+		w.discovery[name] = d
+	}
+	return d
+}
+
+// lookupSourceDiscovery returns the discovery state of a feature source, if
+// the worker has run discovery of the source.
+func (w *nfdWorker) lookupSourceDiscovery(name string) (*sourceDiscovery, bool) {
+	w.discoveryLock.Lock()
+	defer w.discoveryLock.Unlock()
+
+	d, ok := w.discovery[name]
+	return d, ok
+}
+
+// discoveryStatus returns the status of the latest discovery of each source.
+func (w *nfdWorker) discoveryStatus() map[string]sourceStatus {
+	w.discoveryLock.Lock()
+	defer w.discoveryLock.Unlock()
+
+	ret := make(map[string]sourceStatus, len(w.discovery))
+	for name, d := range w.discovery {
+		d.Lock()
+		ret[name] = d.status
+		d.Unlock()
+	}
+	return ret
+}
+
+// discoverSource runs feature discovery of one source, waiting at most
+// timeout for it to complete. A non-positive timeout waits indefinitely.
+func discoverSource(s source.FeatureSource, d *sourceDiscovery, timeout time.Duration) {
+	klog.V(2).Infof("running discovery for %q source", s.Name())
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- s.Discover() }()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case err := <-done:
+		d.complete(s, start, err)
+	case <-timer:
+		klog.Errorf("feature discovery of %q source timed out after %v", s.Name(), timeout)
+		d.Lock()
+		d.status = sourceStatus{LastDiscovery: start, Duration: timeout, TimedOut: true}
+		d.Unlock()
+
+		// Let the discovery run to completion in the background
+		go func() {
+			err := <-done
+			klog.Infof("timed out feature discovery of %q source completed after %v", s.Name(), time.Since(start))
+			d.complete(s, start, err)
+		}()
+	}
+}
+
+// complete records the result of a completed discovery.
+func (d *sourceDiscovery) complete(s source.FeatureSource, start time.Time, err error) {
+	d.Lock()
+	defer d.Unlock()
+
+	// Sources reset their features on each discovery and then fill them in
+	// place, i.e. the features of a source must not be accessed while its
+	// discovery is running. Take a snapshot for that.
+	d.running = false
+	d.features = s.GetFeatures().DeepCopy()
+	d.status = sourceStatus{LastDiscovery: start, Duration: time.Since(start)}
+	if err != nil {
+		klog.Errorf("feature discovery of %q source failed: %v", s.Name(), err)
+		d.status.Error = err.Error()
+	}
+	klog.V(2).Infof("discovery of %q source took %v", s.Name(), d.status.Duration)
+}
+
+// getFeatures returns the snapshot of the features of a source. If the
+// discovery of the source is running the timeout policy determines whether
+// the features of the previous discovery are kept or dropped.
+func (d *sourceDiscovery) getFeatures(policy string) *feature.DomainFeatures {
+	if d.features == nil || (d.running && policy != DiscoveryTimeoutPolicyKeep) {
+		return feature.NewDomainFeatures()
+	}
+	return d.features
+}
+
+// discoveryLabelSource wraps a LabelSource whose features are discovered by
+// the worker. Labels of the source are not re-created while a discovery of
+// the source is running.
+type discoveryLabelSource struct {
+	source.LabelSource
+
+	discovery *sourceDiscovery
+	policy    string
+}
+
+// GetLabels method of the LabelSource interface
+func (s *discoveryLabelSource) GetLabels() (source.FeatureLabels, error) {
+	s.discovery.Lock()
+	defer s.discovery.Unlock()
+
+	if s.discovery.running {
+		if s.policy == DiscoveryTimeoutPolicyKeep && s.discovery.labels != nil {
+			klog.Warningf("discovery of %q source is running, using labels from the previous discovery", s.Name())
+			return s.discovery.labels, nil
+		}
+		klog.Warningf("discovery of %q source is running, dropping its labels", s.Name())
+		return source.FeatureLabels{}, nil
+	}
+
+	labels, err := s.LabelSource.GetLabels()
+	if err == nil {
+		s.discovery.labels = labels
+	}
+	return labels, err
+}
+
+// featureConsumerLabelSource wraps a FeatureConsumerSource, creating its
+// labels from the snapshots of the features of all sources instead of the
+// features the sources are possibly discovering concurrently.
+type featureConsumerLabelSource struct {
+	source.FeatureConsumerSource
+
+	features map[string]*feature.DomainFeatures
+}
+
+// GetLabels method of the LabelSource interface
+func (s *featureConsumerLabelSource) GetLabels() (source.FeatureLabels, error) {
+	return s.GetLabelsFromFeatures(s.features)
+}
+
+// getLabelSources returns the enabled label sources, taking into account
+// the state of the feature discovery.
+func (w *nfdWorker) getLabelSources() []source.LabelSource {
+	var features map[string]*feature.DomainFeatures
+
+	ret := make([]source.LabelSource, len(w.labelSources))
+	for i, s := range w.labelSources {
+		if c, ok := s.(source.FeatureConsumerSource); ok {
+			if features == nil {
+				features = w.getFeatures()
+			}
+			s = &featureConsumerLabelSource{FeatureConsumerSource: c, features: features}
+		}
+
+		if d, ok := w.lookupSourceDiscovery(s.Name()); ok {
+			ret[i] = &discoveryLabelSource{LabelSource: s, discovery: d, policy: w.config.Core.DiscoveryTimeoutPolicy}
+		} else {
+			ret[i] = s
+		}
+	}
+	return ret
+}
+
+// getFeatures returns raw features from all feature sources. For the sources
+// whose discovery is run by the worker the snapshots of the features are
+// returned.
+func (w *nfdWorker) getFeatures() map[string]*feature.DomainFeatures {
+	features := make(map[string]*feature.DomainFeatures)
+
+	for name, src := range source.GetAllFeatureSources() {
+		if d, ok := w.lookupSourceDiscovery(name); ok {
+			d.Lock()
+			features[name] = d.getFeatures(w.config.Core.DiscoveryTimeoutPolicy)
+			d.Unlock()
+		} else {
+			features[name] = src.GetFeatures()
+		}
+	}
+
+	return features
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	nfdclient "sigs.k8s.io/node-feature-discovery/pkg/nfd-client"
+	"sigs.k8s.io/node-feature-discovery/pkg/version"
+)
+
+// Output formats of the export mode
+const (
+	ExportFormatJSON = "json"
+	ExportFormatYAML = "yaml"
+)
+
+// export runs feature discovery once and writes the features and labels of
+// the enabled sources to the export file.
+func (w *nfdWorker) export() error {
+	switch w.args.ExportFormat {
+	case ExportFormatJSON, ExportFormatYAML:
+	default:
+		return fmt.Errorf("invalid export format %q", w.args.ExportFormat)
+	}
+
+	w.discoverFeatures(w.featureSources)
+
+	labels := createFeatureLabels(w.getLabelSources(), w.config.Core.LabelWhiteList.Regexp)
+
+	// Only export features of the enabled sources
+	allFeatures := w.getFeatures()
+	features := make(feature.Features, len(w.featureSources))
+	for _, s := range w.featureSources {
+		features[s.Name()] = allFeatures[s.Name()]
+	}
+
+	e := feature.NewNodeFeatureExport(nfdclient.NodeName(), version.Get(), features, labels)
+
+	var data []byte
+	var err error
+	if w.args.ExportFormat == ExportFormatJSON {
+		data, err = json.MarshalIndent(e, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(e)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal exported features: %v", err)
+	}
+
+	out := os.Stdout
+	if w.args.Export != "-" {
+		f, err := os.Create(w.args.Export)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("failed to write exported features: %v", err)
+	}
+	klog.Infof("features and labels exported to %q", w.args.Export)
+
+	return nil
+}
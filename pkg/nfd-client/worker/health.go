@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// readiness holds the readiness state of the worker. The worker is ready
+// when its latest attempt to communicate with nfd-master succeeded.
+type readiness struct {
+	sync.Mutex
+
+	ready  bool
+	reason string
+}
+
+// setReady marks the worker as ready.
+func (r *readiness) setReady() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.ready = true
+	r.reason = ""
+}
+
+// setNotReady marks the worker as not ready.
+func (r *readiness) setNotReady(reason string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.ready = false
+	r.reason = reason
+}
+
+// get returns the readiness state.
+func (r *readiness) get() (bool, string) {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.ready, r.reason
+}
+
+// ServeHTTP serves the readiness state.
+func (r *readiness) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	ready, reason := r.get()
+	if !ready {
+		if reason == "" {
+			reason = "labels not published yet"
+		}
+		http.Error(rw, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(rw, "ok")
+}
+
+// startHealthServer starts serving health endpoints of the worker.
+func (w *nfdWorker) startHealthServer() error {
+	mux := http.NewServeMux()
+	mux.Handle("/readyz", &w.readiness)
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) { fmt.Fprintln(rw, "ok") })
+
+	addr := net.JoinHostPort(w.healthAddress(), strconv.Itoa(w.args.HealthPort))
+	s, err := startHTTPServer("health", addr, mux)
+	if err != nil {
+		return err
+	}
+	w.healthServer = s
+	return nil
+}
+
+// healthAddress returns the address on which to serve the health endpoints.
+// Unless specified explicitly, the pod IP is used, falling back to the
+// loopback interface when not running in a pod.
+func (w *nfdWorker) healthAddress() string {
+	if w.args.HealthAddress != "" {
+		return w.args.HealthAddress
+	}
+	if podIP := os.Getenv("POD_IP"); podIP != "" {
+		return podIP
+	}
+	return "localhost"
+}
+
+// startHTTPServer starts serving HTTP requests in the background.
+func startHTTPServer(name, addr string, handler http.Handler) (*http.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	s := &http.Server{Handler: handler}
+
+	klog.Infof("serving %s endpoints at %s", name, lis.Addr())
+	go func() {
+		if err := s.Serve(lis); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("%s server failed: %v", name, err)
+		}
+	}()
+
+	return s, nil
+}
+
+// stopHTTPServer stops an HTTP server started with startHTTPServer.
+func stopHTTPServer(s *http.Server) {
+	if s != nil {
+		if err := s.Shutdown(context.Background()); err != nil {
+			klog.Errorf("failed to stop HTTP server: %v", err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+// introspectionState holds a snapshot of the worker state, served by the
+// introspection endpoints. The snapshot is updated by the worker so that the
+// endpoints do not access feature sources concurrently with the discovery.
+type introspectionState struct {
+	sync.Mutex
+
+	config   *NFDConfig
+	features feature.Features
+	labels   Labels
+}
+
+// workerStatus is the status served by the introspection endpoint.
+type workerStatus struct {
+	// Ready is the readiness state of the worker
+	Ready bool `json:"ready"`
+	// Reason why the worker is not ready
+	Reason string `json:"reason,omitempty"`
+	// Sources is the status of the latest discovery of each feature source
+	Sources map[string]sourceStatus `json:"sources"`
+	// Publish is the result of the latest attempt to publish labels
+	Publish publishResult `json:"publish"`
+}
+
+// setConfig updates the effective configuration.
+func (s *introspectionState) setConfig(c *NFDConfig) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.config = c
+}
+
+// setLabels updates the features and labels.
+func (s *introspectionState) setLabels(features feature.Features, labels Labels) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.features = features
+	s.labels = labels
+}
+
+// get returns the snapshot of the worker state.
+func (s *introspectionState) get() (*NFDConfig, feature.Features, Labels) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.config, s.features, s.labels
+}
+
+// startIntrospectionServer starts serving the introspection endpoints on the
+// loopback interface.
+func (w *nfdWorker) startIntrospectionServer() error {
+	s, err := startHTTPServer("introspection", fmt.Sprintf("localhost:%d", w.args.IntrospectionPort), w.introspectionHandler())
+	if err != nil {
+		return err
+	}
+	w.introspectionServer = s
+	return nil
+}
+
+// introspectionHandler returns the handler of the introspection endpoints.
+func (w *nfdWorker) introspectionHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/features", func(rw http.ResponseWriter, _ *http.Request) {
+		_, features, _ := w.introspection.get()
+		serveJSON(rw, features)
+	})
+	mux.HandleFunc("/labels", func(rw http.ResponseWriter, _ *http.Request) {
+		_, _, labels := w.introspection.get()
+		serveJSON(rw, labels)
+	})
+	mux.HandleFunc("/config", func(rw http.ResponseWriter, _ *http.Request) {
+		config, _, _ := w.introspection.get()
+		serveJSON(rw, config)
+	})
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, _ *http.Request) {
+		ready, reason := w.readiness.get()
+		serveJSON(rw, workerStatus{
+			Ready:   ready,
+			Reason:  reason,
+			Sources: w.discoveryStatus(),
+			Publish: w.publish.getResult(),
+		})
+	})
+	return mux
+}
+
+// serveJSON writes an object as the JSON response of an HTTP request.
+func serveJSON(rw http.ResponseWriter, obj interface{}) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		klog.Errorf("failed to marshal response: %v", err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(append(data, '\n'))
+}
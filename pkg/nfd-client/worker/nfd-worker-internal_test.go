@@ -17,7 +17,11 @@ limitations under the License.
 package worker
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -28,8 +32,19 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
 	"github.com/vektra/errors"
+	api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	fakek8sclient "k8s.io/client-go/kubernetes/fake"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	nfdclientset "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned"
+	fakenfdclient "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned/fake"
 	"sigs.k8s.io/node-feature-discovery/pkg/labeler"
+	nfdclient "sigs.k8s.io/node-feature-discovery/pkg/nfd-client"
+	"sigs.k8s.io/node-feature-discovery/pkg/nodeupdater"
 	"sigs.k8s.io/node-feature-discovery/pkg/utils"
 	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/cpu"
@@ -109,6 +124,9 @@ func TestConfigParse(t *testing.T) {
 				So(worker.config.Core.LabelSources, ShouldResemble, []string{"fake"})
 				So(worker.config.Core.FeatureSources, ShouldResemble, []string{"all"})
 				So(worker.config.Core.NoPublish, ShouldBeTrue)
+				So(worker.config.Core.discoveryTimeout("cpu"), ShouldEqual, 60*time.Second)
+				So(worker.config.Core.DiscoveryTimeoutPolicy, ShouldEqual, DiscoveryTimeoutPolicyKeep)
+				So(worker.config.Core.HeartbeatInterval.Duration, ShouldEqual, 10*time.Minute)
 			})
 		})
 		Convey("and a non-accessible file, but core cmdline flags and some overrides are specified", func() {
@@ -139,6 +157,14 @@ core:
   sources: ["system"]
   labelWhiteList: "foo"
   sleepInterval: "10s"
+  sourceIntervals:
+    cpu: "1h"
+    network: "100ms"
+  discoveryTimeout: "30s"
+  sourceDiscoveryTimeouts:
+    local: "2m"
+  discoveryTimeoutPolicy: "drop"
+  heartbeatInterval: "1h"
 sources:
   kernel:
     configOpts:
@@ -160,6 +186,13 @@ sources:
 				So(worker.config.Core.LabelSources, ShouldResemble, []string{"cpu", "kernel", "pci"}) // from cmdline
 				So(worker.config.Core.LabelWhiteList.String(), ShouldEqual, "foo")
 				So(worker.config.Core.SleepInterval.Duration, ShouldEqual, 10*time.Second)
+				So(worker.config.Core.sourceInterval("cpu"), ShouldEqual, time.Hour)
+				So(worker.config.Core.sourceInterval("network"), ShouldEqual, time.Second) // sanitized
+				So(worker.config.Core.sourceInterval("pci"), ShouldEqual, 10*time.Second)
+				So(worker.config.Core.discoveryTimeout("cpu"), ShouldEqual, 30*time.Second)
+				So(worker.config.Core.discoveryTimeout("local"), ShouldEqual, 2*time.Minute)
+				So(worker.config.Core.DiscoveryTimeoutPolicy, ShouldEqual, DiscoveryTimeoutPolicyDrop)
+				So(worker.config.Core.HeartbeatInterval.Duration, ShouldEqual, time.Hour)
 
 				// Verify feature source config
 				So(err, ShouldBeNil)
@@ -387,6 +420,279 @@ func TestCreateFeatureLabels(t *testing.T) {
 	})
 }
 
+// testSource is a feature and label source whose discovery can be blocked
+type testSource struct {
+	name     string
+	value    string
+	block    chan struct{}
+	features *feature.DomainFeatures
+}
+
+func (s *testSource) Name() string { return s.name }
+
+func (s *testSource) Priority() int { return 0 }
+
+func (s *testSource) Discover() error {
+	// Like real sources, reset the features and then fill them in place
+	s.features = feature.NewDomainFeatures()
+	if s.block != nil {
+		<-s.block
+	}
+	s.features.Values["test"] = feature.NewValueFeatures(map[string]string{"value": s.value})
+	return nil
+}
+
+func (s *testSource) GetFeatures() *feature.DomainFeatures { return s.features }
+
+func (s *testSource) GetLabels() (source.FeatureLabels, error) {
+	return source.FeatureLabels{"value": s.features.Values["test"].Elements["value"]}, nil
+}
+
+// testConsumerSource is a label source creating labels from the features of
+// another source
+type testConsumerSource struct {
+	source string
+}
+
+func (s *testConsumerSource) Name() string { return "consumer" }
+
+func (s *testConsumerSource) Priority() int { return 0 }
+
+func (s *testConsumerSource) GetLabels() (source.FeatureLabels, error) {
+	return nil, errors.New("features of the sources must not be accessed directly")
+}
+
+func (s *testConsumerSource) GetLabelsFromFeatures(features map[string]*feature.DomainFeatures) (source.FeatureLabels, error) {
+	labels := source.FeatureLabels{}
+	for k, v := range features[s.source].Values["test"].Elements {
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func TestDiscoverFeatures(t *testing.T) {
+	Convey("When running feature discovery", t, func() {
+		w, err := NewNfdWorker(&Args{})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		So(worker.configure("", ""), ShouldBeNil)
+
+		fast := &testSource{name: "fast", value: "1"}
+		slow := &testSource{name: "slow", value: "1"}
+		worker.featureSources = []source.FeatureSource{fast, slow}
+		worker.labelSources = []source.LabelSource{fast, slow}
+		worker.config.Core.SourceDiscoveryTimeouts = map[string]duration{"slow": {100 * time.Millisecond}}
+		emptyLabelWL := regexp.MustCompile("")
+
+		worker.discoverFeatures(worker.featureSources)
+		So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{"fast-value": "1", "slow-value": "1"})
+		So(worker.discovery["slow"].status.TimedOut, ShouldBeFalse)
+
+		Convey("a source that times out should not block other sources", func() {
+			fast.value = "2"
+			slow.value = "2"
+			slow.block = make(chan struct{})
+			worker.discoverFeatures(worker.featureSources)
+			Reset(func() {
+				select {
+				case <-slow.block:
+				default:
+					close(slow.block)
+				}
+			})
+
+			So(worker.discovery["fast"].status.TimedOut, ShouldBeFalse)
+			So(worker.discovery["slow"].status.TimedOut, ShouldBeTrue)
+
+			Convey("and previous features and labels should be kept by default", func() {
+				So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{"fast-value": "2", "slow-value": "1"})
+				f := worker.discovery["slow"].getFeatures(worker.config.Core.DiscoveryTimeoutPolicy)
+				So(f.Values["test"].Elements["value"], ShouldEqual, "1")
+			})
+
+			Convey("and features and labels should be dropped with the drop policy", func() {
+				worker.config.Core.DiscoveryTimeoutPolicy = DiscoveryTimeoutPolicyDrop
+				So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{"fast-value": "2"})
+				f := worker.discovery["slow"].getFeatures(worker.config.Core.DiscoveryTimeoutPolicy)
+				So(f.Values, ShouldBeEmpty)
+			})
+
+			Convey("and new results should be used after the discovery completes", func() {
+				worker.discoverFeatures(worker.featureSources)
+				So(worker.discovery["slow"].status.TimedOut, ShouldBeTrue)
+
+				close(slow.block)
+				isRunning := func() interface{} {
+					d := worker.discovery["slow"]
+					d.Lock()
+					defer d.Unlock()
+					return d.running
+				}
+				So(isRunning, withTimeout, 2*time.Second, ShouldBeFalse)
+				So(worker.discovery["slow"].status.TimedOut, ShouldBeFalse)
+				So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{"fast-value": "2", "slow-value": "2"})
+			})
+		})
+
+		Convey("label sources consuming features should get snapshots of the features", func() {
+			// Fake a running discovery of a registered source
+			d := worker.getSourceDiscovery(cpu.Name)
+			d.running = true
+			d.features = feature.NewDomainFeatures()
+			d.features.Values["test"] = feature.NewValueFeatures(map[string]string{"value": "snapshot"})
+			worker.labelSources = []source.LabelSource{&testConsumerSource{source: cpu.Name}}
+
+			So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{"consumer-value": "snapshot"})
+
+			worker.config.Core.DiscoveryTimeoutPolicy = DiscoveryTimeoutPolicyDrop
+			So(createFeatureLabels(worker.getLabelSources(), *emptyLabelWL), ShouldResemble, Labels{})
+		})
+	})
+}
+
+func TestDiscoverySchedule(t *testing.T) {
+	Convey("When scheduling feature discovery", t, func() {
+		cheap := &testSource{name: "cheap"}
+		volatile := &testSource{name: "volatile"}
+		static := &testSource{name: "static"}
+		sources := []source.FeatureSource{cheap, volatile, static}
+		config := &coreConfig{
+			SleepInterval: duration{time.Minute},
+			SourceIntervals: map[string]duration{
+				"cheap":    {time.Hour},
+				"volatile": {10 * time.Second},
+				"static":   {0},
+			},
+		}
+		schedule := make(discoverySchedule)
+		now := time.Now()
+
+		Convey("all sources should be due initially", func() {
+			So(schedule.due(sources, now), ShouldResemble, sources)
+			next, ok := schedule.next(sources)
+			So(ok, ShouldBeTrue)
+			So(next.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("after discovery each source should run on its own interval", func() {
+			schedule.update(sources, now, config)
+
+			So(schedule.due(sources, now), ShouldBeEmpty)
+			next, ok := schedule.next(sources)
+			So(ok, ShouldBeTrue)
+			So(next, ShouldEqual, now.Add(10*time.Second))
+
+			So(schedule.due(sources, now.Add(10*time.Second)), ShouldResemble, []source.FeatureSource{volatile})
+			So(schedule.due(sources, now.Add(time.Hour)), ShouldResemble, []source.FeatureSource{cheap, volatile})
+		})
+
+		Convey("sources without an interval should fall back to the sleep interval", func() {
+			delete(config.SourceIntervals, "volatile")
+			schedule.update(sources, now, config)
+
+			So(schedule.due(sources, now.Add(time.Minute)), ShouldResemble, []source.FeatureSource{volatile})
+		})
+
+		Convey("nothing should be scheduled if periodic discovery is disabled", func() {
+			config.SleepInterval = duration{0}
+			config.SourceIntervals = nil
+			schedule.update(sources, now, config)
+
+			So(schedule.due(sources, now.Add(24*time.Hour)), ShouldBeEmpty)
+			_, ok := schedule.next(sources)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+// fakeUeventListener is a UeventListener delivering events fed by the test
+type fakeUeventListener struct {
+	events chan utils.Uevent
+}
+
+func (l *fakeUeventListener) Events() <-chan utils.Uevent { return l.events }
+
+func (l *fakeUeventListener) Close() error { return nil }
+
+func TestUeventRediscovery(t *testing.T) {
+	Convey("When running nfd-worker with uevents enabled", t, func() {
+		noPublish := true
+		w, err := NewNfdWorker(&Args{
+			Options: `{"core": {"uevents": true, "ueventDebounce": "10ms", "sleepInterval": "0s"}}`,
+			Overrides: ConfigOverrideArgs{
+				FeatureSources: &utils.StringSliceVal{"pci", "system"},
+				LabelSources:   &utils.StringSliceVal{"system"},
+				NoPublish:      &noPublish},
+		})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+
+		events := make(chan utils.Uevent)
+		worker.newUeventListener = func() (utils.UeventListener, error) {
+			return &fakeUeventListener{events: events}, nil
+		}
+
+		lastDiscovery := func(name string) func() interface{} {
+			return func() interface{} { return worker.discoveryStatus()[name].LastDiscovery }
+		}
+
+		Convey("only the sources affected by uevents should be rediscovered", func() {
+			go func() { _ = w.Run() }()
+			defer w.Stop()
+
+			So(lastDiscovery("pci"), withTimeout, 2*time.Second, ShouldNotBeZeroValue)
+			So(lastDiscovery("system"), withTimeout, 2*time.Second, ShouldNotBeZeroValue)
+			status := worker.discoveryStatus()
+
+			events <- utils.Uevent{Action: "add", DevPath: "/devices/virtual/input/input42", Subsystem: "input"}
+			events <- utils.Uevent{Action: "add", DevPath: "/devices/pci0000:00/0000:00:1c.0/0000:3b:00.2", Subsystem: "pci"}
+			events <- utils.Uevent{Action: "bind", DevPath: "/devices/pci0000:00/0000:00:1c.0/0000:3b:00.2", Subsystem: "pci"}
+
+			So(lastDiscovery("pci"), withTimeout, 2*time.Second, ShouldHappenAfter, status["pci"].LastDiscovery)
+			So(worker.discoveryStatus()["system"].LastDiscovery, ShouldEqual, status["system"].LastDiscovery)
+		})
+
+		Convey("a steady stream of uevents should not postpone rediscovery indefinitely", func() {
+			go func() { _ = w.Run() }()
+			defer w.Stop()
+
+			So(lastDiscovery("pci"), withTimeout, 2*time.Second, ShouldNotBeZeroValue)
+			status := worker.discoveryStatus()
+
+			// Events arrive more often than the debounce time
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					select {
+					case events <- utils.Uevent{Action: "change", DevPath: "/devices/pci0000:00/0000:00:1c.0/0000:3b:00.2", Subsystem: "pci"}:
+					case <-done:
+						return
+					}
+					time.Sleep(2 * time.Millisecond)
+				}
+			}()
+
+			So(lastDiscovery("pci"), withTimeout, 2*time.Second, ShouldHappenAfter, status["pci"].LastDiscovery)
+		})
+	})
+}
+
+func TestUeventDelay(t *testing.T) {
+	Convey("When debouncing uevents", t, func() {
+		now := time.Now()
+		debounce := 2 * time.Second
+
+		Convey("the debounce time should be used when far from the deadline", func() {
+			So(ueventDelay(now, now.Add(20*time.Second), debounce), ShouldEqual, debounce)
+		})
+		Convey("the delay should not extend beyond the deadline", func() {
+			So(ueventDelay(now, now.Add(time.Second), debounce), ShouldEqual, time.Second)
+			So(ueventDelay(now, now.Add(-time.Second), debounce), ShouldEqual, time.Duration(0))
+		})
+	})
+}
+
 func TestAdvertiseFeatureLabels(t *testing.T) {
 	Convey("When advertising labels", t, func() {
 		w, err := NewNfdWorker(&Args{})
@@ -413,5 +719,584 @@ func TestAdvertiseFeatureLabels(t *testing.T) {
 				So(err, ShouldEqual, mockErr)
 			})
 		})
+		Convey("Unchanged labeling requests are suppressed", func() {
+			worker.config = newDefaultConfig()
+			mockClient.On("SetLabels", mock.AnythingOfType("*context.timerCtx"), mock.AnythingOfType("*labeler.SetLabelsRequest")).Return(&labeler.SetLabelsReply{}, nil)
+			So(worker.advertiseFeatureLabels(labels), ShouldBeNil)
+			So(worker.advertiseFeatureLabels(map[string]string{"feature-1": "value-1"}), ShouldBeNil)
+			mockClient.AssertNumberOfCalls(t, "SetLabels", 1)
+
+			Convey("Changed labels should be sent", func() {
+				So(worker.advertiseFeatureLabels(map[string]string{"feature-1": "value-2"}), ShouldBeNil)
+				mockClient.AssertNumberOfCalls(t, "SetLabels", 2)
+			})
+			Convey("Unchanged labels should be sent after the heartbeat interval", func() {
+				worker.config.Core.HeartbeatInterval = duration{time.Millisecond}
+				time.Sleep(2 * time.Millisecond)
+				So(worker.advertiseFeatureLabels(labels), ShouldBeNil)
+				mockClient.AssertNumberOfCalls(t, "SetLabels", 2)
+			})
+			Convey("Unchanged labels should be sent after a reset", func() {
+				worker.publish.reset()
+				So(worker.advertiseFeatureLabels(labels), ShouldBeNil)
+				mockClient.AssertNumberOfCalls(t, "SetLabels", 2)
+			})
+		})
+	})
+}
+
+func TestRequestHash(t *testing.T) {
+	Convey("When hashing labeling requests", t, func() {
+		newRequest := func(value string) *labeler.SetLabelsRequest {
+			f := feature.NewDomainFeatures()
+			f.Keys["key"] = feature.NewKeyFeatures("a", "b", "c", "d")
+			f.Values["value"] = feature.NewValueFeatures(map[string]string{"a": "1", "b": "2", "c": "3", "d": value})
+			f.Instances["instance"] = feature.NewInstanceFeatures([]feature.InstanceFeature{
+				*feature.NewInstanceFeature(map[string]string{"a": "1", "b": "2", "c": "3"}),
+			})
+			return &labeler.SetLabelsRequest{
+				NodeName: "node-1",
+				Labels:   map[string]string{"a": "1", "b": "2", "c": "3", "d": value},
+				Features: map[string]*feature.DomainFeatures{"a": f, "b": f, "c": f},
+			}
+		}
+		h1, err := requestHash(newRequest("4"))
+		So(err, ShouldBeNil)
+
+		Convey("Identical requests should have the same hash", func() {
+			for i := 0; i < 10; i++ {
+				h2, err := requestHash(newRequest("4"))
+				So(err, ShouldBeNil)
+				So(h2, ShouldResemble, h1)
+			}
+		})
+		Convey("Different requests should have different hashes", func() {
+			h2, err := requestHash(newRequest("5"))
+			So(err, ShouldBeNil)
+			So(h2, ShouldNotResemble, h1)
+		})
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	Convey("When nfd-master is not connected", t, func() {
+		w, err := NewNfdWorker(&Args{})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		worker.config = newDefaultConfig()
+
+		So(worker.updateLabels(), ShouldBeNil)
+
+		Convey("the labeling request should be cached and the worker not ready", func() {
+			So(worker.publish.getRequest(), ShouldNotBeNil)
+			ready, _ := worker.readiness.get()
+			So(ready, ShouldBeFalse)
+		})
+
+		Convey("the cached request should be sent after reconnecting", func() {
+			mockClient := &labeler.MockLabelerClient{}
+			mockClient.On("SetLabels", mock.AnythingOfType("*context.timerCtx"), worker.publish.getRequest()).Return(&labeler.SetLabelsReply{}, nil)
+			worker.client = mockClient
+
+			So(worker.reconnect(), ShouldBeNil)
+			mockClient.AssertNumberOfCalls(t, "SetLabels", 1)
+			ready, _ := worker.readiness.get()
+			So(ready, ShouldBeTrue)
+		})
+
+		Convey("a failed request should be reported", func() {
+			mockClient := &labeler.MockLabelerClient{}
+			mockClient.On("SetLabels", mock.AnythingOfType("*context.timerCtx"), mock.AnythingOfType("*labeler.SetLabelsRequest")).Return(&labeler.SetLabelsReply{}, errors.New("mock-error"))
+			worker.client = mockClient
+
+			So(worker.reconnect(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReadiness(t *testing.T) {
+	Convey("When serving readiness", t, func() {
+		r := &readiness{}
+		get := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+			return rec
+		}
+
+		Convey("worker should not be ready initially", func() {
+			So(get().Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+		Convey("worker should be ready after labels have been published", func() {
+			r.setReady()
+			So(get().Code, ShouldEqual, http.StatusOK)
+
+			Convey("and not ready after communication failures", func() {
+				r.setNotReady("failed to connect")
+				rec := get()
+				So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(rec.Body.String(), ShouldEqual, "failed to connect\n")
+			})
+		})
+	})
+
+	Convey("When determining the health server address", t, func() {
+		w := &nfdWorker{}
+		t.Setenv("POD_IP", "")
+
+		Convey("localhost should be used by default", func() {
+			So(w.healthAddress(), ShouldEqual, "localhost")
+		})
+		Convey("pod IP should be used if available", func() {
+			t.Setenv("POD_IP", "10.0.0.1")
+			So(w.healthAddress(), ShouldEqual, "10.0.0.1")
+		})
+		Convey("explicitly specified address should take precedence", func() {
+			t.Setenv("POD_IP", "10.0.0.1")
+			w.args.HealthAddress = "0.0.0.0"
+			So(w.healthAddress(), ShouldEqual, "0.0.0.0")
+		})
+	})
+}
+
+func TestIntrospection(t *testing.T) {
+	Convey("When serving introspection endpoints", t, func() {
+		w, err := NewNfdWorker(&Args{Overrides: ConfigOverrideArgs{
+			FeatureSources: &utils.StringSliceVal{"fake"},
+			LabelSources:   &utils.StringSliceVal{"fake"}}})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		So(worker.configure("", `{"core": {"sleepInterval": "30s", "noPublish": true}}`), ShouldBeNil)
+		worker.discoverFeatures(worker.featureSources)
+		So(worker.updateLabels(), ShouldBeNil)
+
+		handler := worker.introspectionHandler()
+		get := func(path string, obj interface{}) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(json.Unmarshal(rec.Body.Bytes(), obj), ShouldBeNil)
+		}
+
+		Convey("/features should return the raw features", func() {
+			features := feature.Features{}
+			get("/features", &features)
+			So(features, ShouldContainKey, "fake")
+			So(features["fake"].Keys, ShouldContainKey, "flag")
+		})
+		Convey("/labels should return the feature labels", func() {
+			labels := Labels{}
+			get("/labels", &labels)
+			So(labels, ShouldContainKey, "fake-fakefeature1")
+		})
+		Convey("/config should return the effective configuration", func() {
+			config := map[string]map[string]interface{}{}
+			get("/config", &config)
+			So(config["core"]["sleepInterval"], ShouldEqual, "30s")
+			So(config["core"]["featureSources"], ShouldResemble, []interface{}{"fake"})
+		})
+		Convey("/status should return the discovery status", func() {
+			status := workerStatus{}
+			get("/status", &status)
+			So(status.Ready, ShouldBeTrue)
+			So(status.Sources, ShouldContainKey, "fake")
+			So(status.Sources["fake"].LastDiscovery.IsZero(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestExport(t *testing.T) {
+	Convey("When running in export mode", t, func() {
+		exportFile := filepath.Join(t.TempDir(), "features.json")
+		w, err := NewNfdWorker(&Args{
+			Export:       exportFile,
+			ExportFormat: ExportFormatJSON,
+			Overrides: ConfigOverrideArgs{
+				FeatureSources: &utils.StringSliceVal{"fake"},
+				LabelSources:   &utils.StringSliceVal{"fake"}},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("features and labels of the enabled sources should be exported", func() {
+			So(w.Run(), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(exportFile)
+			So(err, ShouldBeNil)
+			e := feature.NodeFeatureExport{}
+			So(json.Unmarshal(data, &e), ShouldBeNil)
+			So(e.Schema, ShouldEqual, feature.ExportSchema)
+			So(len(e.Features), ShouldEqual, 1)
+			So(e.Features, ShouldContainKey, "fake")
+			So(e.Features["fake"].Keys, ShouldNotBeEmpty)
+			So(e.Labels, ShouldContainKey, "fake-fakefeature1")
+
+			// Field names are defined by the export schema
+			So(string(data), ShouldContainSubstring, `"schema"`)
+			So(string(data), ShouldContainSubstring, `"keys"`)
+			So(string(data), ShouldNotContainSubstring, `"Elements"`)
+		})
+		Convey("invalid export format should result in an error", func() {
+			w.(*nfdWorker).args.ExportFormat = "xml"
+			So(w.Run(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStandalone(t *testing.T) {
+	Convey("When running in standalone mode", t, func() {
+		rule := &nfdv1alpha1.NodeFeatureRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "rule-1", UID: "uid-1"},
+			Spec: nfdv1alpha1.NodeFeatureRuleSpec{
+				Rules: []nfdv1alpha1.Rule{{
+					Name:   "rule-1",
+					Labels: map[string]string{"rule-label": "true", "vendor.io/rule-label": "true"},
+				}},
+			},
+		}
+		mockHelper := &apihelper.MockAPIHelpers{}
+		mockClient := &k8sclient.Clientset{}
+		mockNode := &api.Node{}
+		mockNode.Name = "mock-node"
+
+		w, err := NewNfdWorker(&Args{
+			Standalone:   true,
+			ExtraLabelNs: utils.StringSetVal{"vendor.io": {}},
+			Overrides: ConfigOverrideArgs{
+				FeatureSources: &utils.StringSliceVal{"fake"},
+				LabelSources:   &utils.StringSliceVal{"fake"}}})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		nfdClient := fakenfdclient.NewSimpleClientset(rule)
+		worker.newKubeClients = func() (apihelper.APIHelpers, nfdclientset.Interface, error) {
+			return mockHelper, nfdClient, nil
+		}
+		So(worker.configure("", ""), ShouldBeNil)
+		So(worker.Connect(), ShouldBeNil)
+		defer worker.Disconnect()
+		worker.discoverFeatures(worker.featureSources)
+
+		hasLabel := func(name string) func([]apihelper.JsonPatch) bool {
+			return func(patches []apihelper.JsonPatch) bool {
+				for _, p := range patches {
+					if p.Op == "add" && p.Path == "/metadata/labels/"+strings.ReplaceAll(name, "/", "~1") {
+						return true
+					}
+				}
+				return false
+			}
+		}
+
+		Convey("the node should be updated with labels from sources and NodeFeatureRules", func() {
+			mockHelper.On("GetClient").Return(mockClient, nil)
+			mockHelper.On("GetNode", mockClient, mock.Anything).Return(mockNode, nil)
+			mockHelper.On("PatchNode", mockClient, "mock-node", mock.MatchedBy(func(p []apihelper.JsonPatch) bool {
+				return hasLabel(nodeupdater.FeatureLabelNs+"/fake-fakefeature1")(p) &&
+					hasLabel(nodeupdater.FeatureLabelNs+"/rule-label")(p) &&
+					hasLabel("vendor.io/rule-label")(p)
+			})).Return(nil)
+			mockHelper.On("PatchNodeStatus", mockClient, "mock-node", mock.Anything).Return(nil)
+
+			So(worker.updateLabels(), ShouldBeNil)
+			mockHelper.AssertNumberOfCalls(t, "PatchNode", 1)
+			ready, _ := worker.readiness.get()
+			So(ready, ShouldBeTrue)
+		})
+
+		Convey("changes to NodeFeatureRules should be applied without waiting for rediscovery", func() {
+			mockHelper.On("GetClient").Return(mockClient, nil)
+			mockHelper.On("GetNode", mockClient, mock.Anything).Return(mockNode, nil)
+			mockHelper.On("PatchNode", mockClient, "mock-node", mock.Anything).Return(nil).Once()
+			mockHelper.On("PatchNode", mockClient, "mock-node", mock.MatchedBy(hasLabel(nodeupdater.FeatureLabelNs+"/rule-2-label"))).Return(nil).Once()
+			mockHelper.On("PatchNodeStatus", mockClient, "mock-node", mock.Anything).Return(nil)
+			So(worker.updateLabels(), ShouldBeNil)
+
+			rule2 := rule.DeepCopy()
+			rule2.Name = "rule-2"
+			rule2.UID = "uid-2"
+			rule2.Spec.Rules[0].Name = "rule-2"
+			rule2.Spec.Rules[0].Labels = map[string]string{"rule-2-label": "true"}
+			_, err := nfdClient.NfdV1alpha1().NodeFeatureRules().Create(context.TODO(), rule2, metav1.CreateOptions{})
+			So(err, ShouldBeNil)
+
+			// Wait for the event of the new rule
+			for synced := false; !synced; {
+				select {
+				case <-worker.ruleEvents:
+					_, err := worker.ruleLister.Get("rule-2")
+					synced = err == nil
+				case <-time.After(5 * time.Second):
+					t.Fatal("no event received for the new NodeFeatureRule")
+				}
+			}
+			So(worker.resendLabelsRequest(), ShouldBeNil)
+			mockHelper.AssertNumberOfCalls(t, "PatchNode", 2)
+		})
+
+		Convey("failures to update the node should be reported", func() {
+			mockHelper.On("GetClient").Return(nil, errors.New("mock-error"))
+
+			So(worker.updateLabels(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConfigMap(t *testing.T) {
+	Convey("When reading the configuration from a ConfigMap", t, func() {
+		cm := &api.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfd-worker-conf", Namespace: "nfd", ResourceVersion: "1"},
+			Data: map[string]string{
+				ConfigMapConfigKey: `
+core:
+  noPublish: true
+  sleepInterval: 10s
+sources:
+  kernel:
+    configOpts: [DMI]
+`,
+				ConfigMapOverridesKey: `
+- name: pool-a
+  nodeSelector:
+    pool: a
+  config:
+    core:
+      sleepInterval: 20s
+- name: pool-b
+  nodeSelector:
+    pool: b
+  config:
+    core:
+      sleepInterval: 30s
+- name: all
+  config:
+    sources:
+      kernel:
+        kconfigFile: /foo/bar
+`,
+			},
+		}
+		node := &api.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   nfdclient.NodeName(),
+			Labels: map[string]string{"pool": "b"}}}
+		client := fakek8sclient.NewSimpleClientset(cm, node)
+
+		w, err := NewNfdWorker(&Args{ConfigMap: "nfd/nfd-worker-conf"})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		worker.newKubeClient = func() (k8sclient.Interface, error) { return client, nil }
+		So(worker.startConfigMapWatch(), ShouldBeNil)
+		defer worker.configMap.close()
+
+		Convey("the base config and the matching overrides should be applied in order", func() {
+			So(worker.configure("", ""), ShouldBeNil)
+			So(worker.config.Core.NoPublish, ShouldBeTrue)
+			So(worker.config.Core.SleepInterval.Duration, ShouldEqual, 30*time.Second)
+			kernelConf := worker.config.Sources["kernel"].(*kernel.Config)
+			So(kernelConf.ConfigOpts, ShouldResemble, []string{"DMI"})
+			So(kernelConf.KconfigFile, ShouldEqual, "/foo/bar")
+		})
+
+		Convey("events of the already read version should not be signaled", func() {
+			worker.configMap.notify("1")
+			So(worker.configMap.events, ShouldBeEmpty)
+		})
+
+		Convey("label changes of the node selecting other overrides should be signaled", func() {
+			So(worker.configure("", ""), ShouldBeNil)
+			So(worker.config.Core.SleepInterval.Duration, ShouldEqual, 30*time.Second)
+
+			// Labels not affecting the overrides are ignored
+			n := node.DeepCopy()
+			n.Labels["foo"] = "bar"
+			worker.configMap.notifyNode(n)
+			So(worker.configMap.events, ShouldBeEmpty)
+
+			n = node.DeepCopy()
+			n.Labels["pool"] = "a"
+			_, err := client.CoreV1().Nodes().Update(context.TODO(), n, metav1.UpdateOptions{})
+			So(err, ShouldBeNil)
+			select {
+			case <-worker.configMap.events:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no node label change event received")
+			}
+			So(worker.configure("", ""), ShouldBeNil)
+			So(worker.config.Core.SleepInterval.Duration, ShouldEqual, 20*time.Second)
+		})
+
+		Convey("changes to the ConfigMap should be signaled", func() {
+			cm = cm.DeepCopy()
+			cm.ResourceVersion = "2"
+			cm.Data = map[string]string{ConfigMapOverridesKey: `
+- name: broken
+  config:
+    core:
+      sleepInterval: foo
+`}
+			_, err := client.CoreV1().ConfigMaps("nfd").Update(context.TODO(), cm, metav1.UpdateOptions{})
+			So(err, ShouldBeNil)
+			select {
+			case <-worker.configMap.events:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no ConfigMap change event received")
+			}
+
+			Convey("and errors should point to the offending override", func() {
+				err := worker.configure("", "")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, `override "broken"`)
+			})
+		})
+
+		Convey("a missing ConfigMap should result in the default config", func() {
+			So(client.CoreV1().ConfigMaps("nfd").Delete(context.TODO(), cm.Name, metav1.DeleteOptions{}), ShouldBeNil)
+			select {
+			case <-worker.configMap.events:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no ConfigMap deletion event received")
+			}
+			So(worker.configure("", ""), ShouldBeNil)
+			So(worker.config.Core.NoPublish, ShouldBeFalse)
+			So(worker.config.Core.SleepInterval.Duration, ShouldEqual, 60*time.Second)
+		})
+	})
+
+	Convey("When parsing ConfigMap references", t, func() {
+		os.Setenv("POD_NAMESPACE", "pod-ns")
+		defer os.Unsetenv("POD_NAMESPACE")
+
+		ns, name, err := parseConfigMapRef("foo")
+		So(err, ShouldBeNil)
+		So(ns, ShouldEqual, "pod-ns")
+		So(name, ShouldEqual, "foo")
+
+		ns, name, err = parseConfigMapRef("bar/foo")
+		So(err, ShouldBeNil)
+		So(ns, ShouldEqual, "bar")
+		So(name, ShouldEqual, "foo")
+
+		_, _, err = parseConfigMapRef("bar/")
+		So(err, ShouldNotBeNil)
+
+		os.Unsetenv("POD_NAMESPACE")
+		_, _, err = parseConfigMapRef("foo")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	Convey("When validating the configuration", t, func() {
+		configFile := filepath.Join(t.TempDir(), "nfd-worker.conf")
+		writeConfig := func(data string) {
+			So(ioutil.WriteFile(configFile, []byte(data), 0644), ShouldBeNil)
+		}
+		w, err := NewNfdWorker(&Args{ConfigFile: configFile})
+		So(err, ShouldBeNil)
+		worker := w.(*nfdWorker)
+		out := &strings.Builder{}
+
+		Convey("a valid configuration should pass", func() {
+			writeConfig(`
+core:
+  sleepInterval: 30s
+sources:
+  cpu:
+    cpuid:
+      attributeBlacklist: [foo]
+  custom:
+    - name: rule
+      labels:
+        foo: bar
+      matchFeatures:
+        - feature: kernel.loadedmodule
+          matchExpressions:
+            kmod1: {op: Exists}
+`)
+			So(worker.validate(out), ShouldBeNil)
+			So(out.String(), ShouldBeEmpty)
+		})
+
+		Convey("all errors should be reported with file and line", func() {
+			writeConfig(`
+core:
+  sleepIntervall: 30s
+sources:
+  cpu:
+    cpuid:
+      attributeBlacklist: [foo]
+      attributeBlaclist: [bar]
+  foo: {}
+  custom:
+    - name: rule-1
+    - name: rule-2
+      matchFeatures:
+        - feature: loadedmodule
+`)
+			worker.args.Options = `{"core": {"noPublish": "maybe"}}`
+			So(worker.validate(out), ShouldNotBeNil)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 5)
+			So(lines[0], ShouldStartWith, configFile+":3: core.sleepIntervall: unknown field")
+			So(lines[1], ShouldStartWith, configFile+":8: sources.cpu.attributeBlaclist: unknown field")
+			So(lines[2], ShouldStartWith, configFile+":14: sources.custom[1].matchFeatures[0]: invalid feature")
+			So(lines[3], ShouldStartWith, configFile+":9: sources.foo: unknown feature source")
+			So(lines[4], ShouldStartWith, "-options:1: core: ")
+		})
+
+		Convey("syntax errors should be reported with file and line", func() {
+			writeConfig("core:\n  sleepInterval: 30s\n\tnoPublish: true\n")
+			So(worker.validate(out), ShouldNotBeNil)
+			So(out.String(), ShouldStartWith, configFile+":3: ")
+		})
+
+		Convey("the configuration overrides file should be validated", func() {
+			overridesFile := filepath.Join(filepath.Dir(configFile), ConfigMapOverridesKey)
+			writeConfig("core:\n  sleepInterval: 30s\n")
+
+			So(ioutil.WriteFile(overridesFile, []byte(`
+- name: valid
+  nodeSelector:
+    pool: a
+  config:
+    core:
+      sleepInterval: 10s
+`), 0644), ShouldBeNil)
+			So(worker.validate(out), ShouldBeNil)
+			So(out.String(), ShouldBeEmpty)
+
+			So(ioutil.WriteFile(overridesFile, []byte(`
+- name: invalid
+  nodeSelector:
+    pool: "a b"
+  config:
+    core:
+      sleepIntervall: 10s
+    sources:
+      foo: {}
+- name: typo
+  nodeSelektor:
+    pool: b
+`), 0644), ShouldBeNil)
+			So(worker.validate(out), ShouldNotBeNil)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 1)
+			So(lines[0], ShouldStartWith, overridesFile+":11: nodeSelektor: unknown field")
+
+			out.Reset()
+			So(ioutil.WriteFile(overridesFile, []byte(`
+- name: invalid
+  nodeSelector:
+    pool: "a b"
+  config:
+    core:
+      sleepIntervall: 10s
+    sources:
+      foo: {}
+`), 0644), ShouldBeNil)
+			So(worker.validate(out), ShouldNotBeNil)
+			lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 3)
+			So(lines[0], ShouldStartWith, overridesFile+":4: [0].nodeSelector: ")
+			So(lines[1], ShouldStartWith, overridesFile+":7: [0].config.core.sleepIntervall: unknown field")
+			So(lines[2], ShouldStartWith, overridesFile+":9: [0].config.sources.foo: unknown feature source")
+		})
 	})
 }
@@ -20,21 +20,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/net/context"
 	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 
-	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdclientset "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned"
+	nfdlisters "sigs.k8s.io/node-feature-discovery/pkg/generated/listers/nfd/v1alpha1"
 	pb "sigs.k8s.io/node-feature-discovery/pkg/labeler"
 	nfdclient "sigs.k8s.io/node-feature-discovery/pkg/nfd-client"
+	"sigs.k8s.io/node-feature-discovery/pkg/nodeupdater"
 	"sigs.k8s.io/node-feature-discovery/pkg/utils"
 	"sigs.k8s.io/node-feature-discovery/pkg/version"
 	"sigs.k8s.io/node-feature-discovery/source"
@@ -55,18 +62,25 @@ import (
 
 // NFDConfig contains the configuration settings of NfdWorker.
 type NFDConfig struct {
-	Core    coreConfig
-	Sources sourcesConfig
+	Core    coreConfig    `json:"core"`
+	Sources sourcesConfig `json:"sources"`
 }
 
 type coreConfig struct {
-	Klog           map[string]string
-	LabelWhiteList utils.RegexpVal
-	NoPublish      bool
-	FeatureSources []string
-	Sources        *[]string
-	LabelSources   []string
-	SleepInterval  duration
+	Klog                    map[string]string   `json:"klog"`
+	LabelWhiteList          utils.RegexpVal     `json:"labelWhiteList"`
+	NoPublish               bool                `json:"noPublish"`
+	FeatureSources          []string            `json:"featureSources"`
+	Sources                 *[]string           `json:"sources,omitempty"`
+	LabelSources            []string            `json:"labelSources"`
+	SleepInterval           duration            `json:"sleepInterval"`
+	SourceIntervals         map[string]duration `json:"sourceIntervals,omitempty"`
+	DiscoveryTimeout        duration            `json:"discoveryTimeout"`
+	SourceDiscoveryTimeouts map[string]duration `json:"sourceDiscoveryTimeouts,omitempty"`
+	DiscoveryTimeoutPolicy  string              `json:"discoveryTimeoutPolicy"`
+	Uevents                 bool                `json:"uevents"`
+	UeventDebounce          duration            `json:"ueventDebounce"`
+	HeartbeatInterval       duration            `json:"heartbeatInterval"`
 }
 
 type sourcesConfig map[string]source.Config
@@ -78,9 +92,21 @@ type Labels map[string]string
 type Args struct {
 	nfdclient.Args
 
-	ConfigFile string
-	Oneshot    bool
-	Options    string
+	ConfigFile        string
+	ConfigMap         string
+	Export            string
+	ExportFormat      string
+	ExtraLabelNs      utils.StringSetVal
+	HealthAddress     string
+	HealthPort        int
+	Instance          string
+	IntrospectionPort int
+	Kubeconfig        string
+	Oneshot           bool
+	Options           string
+	ResourceLabels    utils.StringSetVal
+	Standalone        bool
+	ValidateConfig    bool
 
 	Klog      map[string]*utils.KlogFlagVal
 	Overrides ConfigOverrideArgs
@@ -104,10 +130,33 @@ type nfdWorker struct {
 	certWatch      *utils.FsWatcher
 	client         pb.LabelerClient
 	configFilePath string
+	configMap      *configMapSource
 	config         *NFDConfig
 	stop           chan struct{} // channel for signaling stop
 	featureSources []source.FeatureSource
 	labelSources   []source.LabelSource
+	discovery      map[string]*sourceDiscovery
+	discoveryLock  sync.Mutex
+	schedule       discoverySchedule
+	publish        publishState
+	readiness      readiness
+	healthServer   *http.Server
+
+	introspection       introspectionState
+	introspectionServer *http.Server
+
+	uevents           utils.UeventListener
+	newUeventListener func() (utils.UeventListener, error)
+	newKubeClient     func() (k8sclient.Interface, error)
+
+	// Standalone mode
+	annotationNs     string
+	updater          *nodeupdater.Updater
+	ruleCache        *nodeupdater.RuleCache
+	ruleLister       nfdlisters.NodeFeatureRuleLister
+	ruleInformerStop chan struct{}
+	ruleEvents       chan struct{}
+	newKubeClients   func() (apihelper.APIHelpers, nfdclientset.Interface, error)
 }
 
 type duration struct {
@@ -127,6 +176,26 @@ func NewNfdWorker(args *Args) (nfdclient.NfdClient, error) {
 		args:   *args,
 		config: &NFDConfig{},
 		stop:   make(chan struct{}, 1),
+
+		schedule: make(discoverySchedule),
+		publish:  publishState{reconnected: make(chan struct{}, 1)},
+
+		ruleEvents: make(chan struct{}, 1),
+
+		newUeventListener: utils.NewUeventListener,
+		newKubeClient: func() (k8sclient.Interface, error) {
+			return newKubeClient(args.Kubeconfig)
+		},
+		newKubeClients: func() (apihelper.APIHelpers, nfdclientset.Interface, error) {
+			return newKubeClients(args.Kubeconfig)
+		},
+	}
+
+	if args.Standalone {
+		nfd.annotationNs, err = nodeupdater.InstanceAnnotationNs(args.Instance)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if args.ConfigFile != "" {
@@ -139,11 +208,15 @@ func NewNfdWorker(args *Args) (nfdclient.NfdClient, error) {
 func newDefaultConfig() *NFDConfig {
 	return &NFDConfig{
 		Core: coreConfig{
-			LabelWhiteList: utils.RegexpVal{Regexp: *regexp.MustCompile("")},
-			SleepInterval:  duration{60 * time.Second},
-			FeatureSources: []string{"all"},
-			LabelSources:   []string{"all"},
-			Klog:           make(map[string]string),
+			LabelWhiteList:         utils.RegexpVal{Regexp: *regexp.MustCompile("")},
+			SleepInterval:          duration{60 * time.Second},
+			DiscoveryTimeout:       duration{60 * time.Second},
+			DiscoveryTimeoutPolicy: DiscoveryTimeoutPolicyKeep,
+			UeventDebounce:         duration{2 * time.Second},
+			HeartbeatInterval:      duration{10 * time.Minute},
+			FeatureSources:         []string{"all"},
+			LabelSources:           []string{"all"},
+			Klog:                   make(map[string]string),
 		},
 	}
 }
@@ -154,48 +227,99 @@ func (w *nfdWorker) Run() error {
 	klog.Infof("Node Feature Discovery Worker %s", version.Get())
 	klog.Infof("NodeName: '%s'", nfdclient.NodeName())
 
+	if w.args.ValidateConfig {
+		return w.validate(os.Stderr)
+	}
+
 	// Create watcher for config file and read initial configuration
 	configWatch, err := utils.CreateFsWatcher(time.Second, w.configFilePath)
 	if err != nil {
 		return err
 	}
+	configEvents := configWatch.Events
+	if w.args.ConfigMap != "" {
+		if err := w.startConfigMapWatch(); err != nil {
+			return err
+		}
+		defer w.configMap.close()
+		configEvents = w.configMap.events
+	}
 	if err := w.configure(w.configFilePath, w.args.Options); err != nil {
 		return err
 	}
 
+	// In export mode features are discovered once, without connecting to
+	// nfd-master
+	if w.args.Export != "" {
+		return w.export()
+	}
+
+	w.configureUevents()
+	defer w.stopUevents()
+
 	// Create watcher for TLS certificates
 	w.certWatch, err = utils.CreateFsWatcher(time.Second, w.args.CaFile, w.args.CertFile, w.args.KeyFile)
 	if err != nil {
 		return err
 	}
 
-	// Connect to NFD master
-	err = w.Connect()
-	if err != nil {
-		return fmt.Errorf("failed to connect: %v", err)
+	// Serve health endpoints
+	if w.args.HealthPort > 0 {
+		if err := w.startHealthServer(); err != nil {
+			return err
+		}
+		defer stopHTTPServer(w.healthServer)
 	}
+
+	// Serve introspection endpoints
+	if w.args.IntrospectionPort > 0 {
+		if err := w.startIntrospectionServer(); err != nil {
+			return err
+		}
+		defer stopHTTPServer(w.introspectionServer)
+	}
+
+	// Failed communication with nfd-master is retried with a backoff. In
+	// one-shot mode failures are fatal.
+	backoff := newRetryBackoff()
+	var retryTrigger <-chan time.Time
+	retry := func(err error) error {
+		w.readiness.setNotReady(err.Error())
+		if w.args.Oneshot {
+			return err
+		}
+		if retryTrigger == nil {
+			d := backoff.Step()
+			klog.Errorf("%v, retrying in %v", err, d)
+			retryTrigger = time.After(d)
+		}
+		return nil
+	}
+
+	// Connect to NFD master
 	defer w.Disconnect()
+	if err := w.Connect(); err != nil {
+		if err := retry(fmt.Errorf("failed to connect: %v", err)); err != nil {
+			return err
+		}
+	}
 
 	labelTrigger := time.After(0)
+	var rediscoveryTrigger <-chan time.Time
+	var rediscoveryDeadline time.Time
+	rediscoverySources := make(map[string]bool)
 	for {
 		select {
 		case <-labelTrigger:
-			// Run feature discovery
-			for _, s := range w.featureSources {
-				klog.V(2).Infof("running discovery for %q source", s.Name())
-				if err := s.Discover(); err != nil {
-					klog.Errorf("feature discovery of %q source failed: %v", s.Name(), err)
-				}
-			}
-
-			// Get the set of feature labels.
-			labels := createFeatureLabels(w.labelSources, w.config.Core.LabelWhiteList.Regexp)
-
-			// Update the node with the feature labels.
-			if w.client != nil {
-				err := w.advertiseFeatureLabels(labels)
-				if err != nil {
-					return fmt.Errorf("failed to advertise labels: %s", err.Error())
+			// Run feature discovery of the sources that are due
+			now := time.Now()
+			sources := w.schedule.due(w.featureSources, now)
+			w.discoverFeatures(sources)
+			w.schedule.update(sources, now, &w.config.Core)
+
+			if err := w.updateLabels(); err != nil {
+				if err := retry(err); err != nil {
+					return err
 				}
 			}
 
@@ -203,11 +327,9 @@ func (w *nfdWorker) Run() error {
 				return nil
 			}
 
-			if w.config.Core.SleepInterval.Duration > 0 {
-				labelTrigger = time.After(w.config.Core.SleepInterval.Duration)
-			}
+			labelTrigger = w.nextDiscoveryTrigger()
 
-		case <-configWatch.Events:
+		case <-configEvents:
 			klog.Infof("reloading configuration")
 			if err := w.configure(w.configFilePath, w.args.Options); err != nil {
 				return err
@@ -215,20 +337,99 @@ func (w *nfdWorker) Run() error {
 			// Manage connection to master
 			if w.config.Core.NoPublish {
 				w.Disconnect()
-			} else if w.ClientConn() == nil {
-				if err := w.Connect(); err != nil {
+			} else if w.ClientConn() == nil && retryTrigger == nil {
+				// Connect asynchronously so that a slow or unreachable
+				// nfd-master does not block the event loop
+				retryTrigger = time.After(0)
+			}
+			// Always re-discover and re-label after a re-config event. This
+			// way the new config comes into effect even if the sleep interval
+			// is long (or infinite)
+			w.schedule = make(discoverySchedule)
+			labelTrigger = time.After(0)
+			w.configureUevents()
+
+		case e, ok := <-w.ueventEvents():
+			if !ok {
+				klog.Errorf("uevent listener stopped unexpectedly, hotplug-driven rediscovery disabled")
+				w.uevents = nil
+				break
+			}
+			if s := w.ueventSource(e); s != nil {
+				klog.V(2).Infof("%s uevent of %s (subsystem %s), scheduling rediscovery of %q source", e.Action, e.DevPath, e.Subsystem, s.Name())
+				rediscoverySources[s.Name()] = true
+				// Debounce, hotplug typically generates a burst of events.
+				// A steady stream of events does not postpone the discovery
+				// indefinitely, though.
+				now := time.Now()
+				debounce := w.config.Core.UeventDebounce.Duration
+				if rediscoveryDeadline.IsZero() {
+					rediscoveryDeadline = now.Add(ueventMaxDelayFactor * debounce)
+				}
+				rediscoveryTrigger = time.After(ueventDelay(now, rediscoveryDeadline, debounce))
+			}
+
+		case <-rediscoveryTrigger:
+			sources := make([]source.FeatureSource, 0, len(rediscoverySources))
+			for _, s := range w.featureSources {
+				if rediscoverySources[s.Name()] {
+					sources = append(sources, s)
+				}
+			}
+			rediscoverySources = make(map[string]bool)
+			rediscoveryTrigger = nil
+			rediscoveryDeadline = time.Time{}
+
+			klog.Infof("running hotplug-triggered feature discovery")
+			now := time.Now()
+			w.discoverFeatures(sources)
+			w.schedule.update(sources, now, &w.config.Core)
+			labelTrigger = w.nextDiscoveryTrigger()
+
+			if err := w.updateLabels(); err != nil {
+				if err := retry(err); err != nil {
 					return err
 				}
 			}
-			// Always re-label after a re-config event. This way the new config
-			// comes into effect even if the sleep interval is long (or infinite)
-			labelTrigger = time.After(0)
+
+		case <-retryTrigger:
+			retryTrigger = nil
+			if err := w.reconnect(); err != nil {
+				if err := retry(err); err != nil {
+					return err
+				}
+				break
+			}
+			klog.Infof("communication with nfd-master restored")
+			backoff = newRetryBackoff()
+
+		case <-w.publish.reconnected:
+			if retryTrigger == nil {
+				if err := w.reconnect(); err != nil {
+					if err := retry(err); err != nil {
+						return err
+					}
+				}
+			}
+
+		case <-w.ruleEvents:
+			// NodeFeatureRules changed, re-evaluate the rules even if the
+			// features have not changed
+			if retryTrigger == nil {
+				if err := w.resendLabelsRequest(); err != nil {
+					if err := retry(err); err != nil {
+						return err
+					}
+				}
+			}
 
 		case <-w.certWatch.Events:
 			klog.Infof("TLS certificate update, renewing connection to nfd-master")
 			w.Disconnect()
-			if err := w.Connect(); err != nil {
-				return err
+			if err := w.reconnect(); err != nil {
+				if err := retry(err); err != nil {
+					return err
+				}
 			}
 
 		case <-w.stop:
@@ -240,6 +441,60 @@ func (w *nfdWorker) Run() error {
 	}
 }
 
+// updateLabels creates the feature labels and advertises them to nfd-master.
+func (w *nfdWorker) updateLabels() error {
+	// Get the set of feature labels.
+	labels := createFeatureLabels(w.getLabelSources(), w.config.Core.LabelWhiteList.Regexp)
+	w.introspection.setLabels(w.getFeatures(), labels)
+
+	// Update the node with the feature labels.
+	if w.connected() {
+		err := w.advertiseFeatureLabels(labels)
+		if err != nil {
+			return fmt.Errorf("failed to advertise labels: %s", err.Error())
+		}
+		w.readiness.setReady()
+	} else if w.config.Core.NoPublish {
+		w.readiness.setReady()
+	} else {
+		// Not connected, the request is sent after reconnecting
+		w.publish.setRequest(w.newLabelsRequest(labels))
+	}
+	return nil
+}
+
+// reconnect connects to nfd-master, if not connected, and re-sends the latest
+// labeling request.
+func (w *nfdWorker) reconnect() error {
+	if w.config.Core.NoPublish {
+		return nil
+	}
+	if !w.connected() {
+		if err := w.Connect(); err != nil {
+			return fmt.Errorf("failed to connect: %v", err)
+		}
+	}
+	if err := w.resendLabelsRequest(); err != nil {
+		return fmt.Errorf("failed to advertise labels: %v", err)
+	}
+	if w.publish.getRequest() != nil {
+		w.readiness.setReady()
+	}
+	return nil
+}
+
+// newRetryBackoff returns the backoff for retrying failed communication with
+// nfd-master.
+func newRetryBackoff() *wait.Backoff {
+	return &wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    math.MaxInt32,
+		Cap:      5 * time.Minute,
+	}
+}
+
 // Stop NfdWorker
 func (w *nfdWorker) Stop() {
 	select {
@@ -248,19 +503,34 @@ func (w *nfdWorker) Stop() {
 	}
 }
 
-// Connect creates a client connection to the NFD master
+// Connect creates a client connection to the NFD master, or, in standalone
+// mode, to the Kubernetes API
 func (w *nfdWorker) Connect() error {
 	// Return a dummy connection in case of dry-run
 	if w.config.Core.NoPublish {
 		return nil
 	}
 
-	if err := w.NfdBaseClient.Connect(); err != nil {
+	if w.args.Standalone {
+		return w.connectAPI()
+	}
+
+	// Don't block the event loop waiting for nfd-master. In one-shot mode
+	// there is no event loop and failure to connect is fatal.
+	connect := w.NfdBaseClient.ConnectNonBlocking
+	if w.args.Oneshot {
+		connect = w.NfdBaseClient.Connect
+	}
+	if err := connect(); err != nil {
 		return err
 	}
 
 	w.client = pb.NewLabelerClient(w.ClientConn())
 
+	// Always send the first request over a new connection
+	w.publish.reset()
+	go w.publish.watchConnection(w.ClientConn())
+
 	return nil
 }
 
@@ -268,6 +538,14 @@ func (w *nfdWorker) Connect() error {
 func (w *nfdWorker) Disconnect() {
 	w.NfdBaseClient.Disconnect()
 	w.client = nil
+	w.updater = nil
+	w.stopRuleInformer()
+}
+
+// connected returns true if the worker is able to publish labels, i.e. it is
+// connected to nfd-master or, in standalone mode, to the Kubernetes API.
+func (w *nfdWorker) connected() bool {
+	return w.client != nil || w.updater != nil
 }
 func (c *coreConfig) sanitize() {
 	if c.SleepInterval.Duration > 0 && c.SleepInterval.Duration < time.Second {
@@ -275,6 +553,20 @@ func (c *coreConfig) sanitize() {
 			c.SleepInterval.Duration.String())
 		c.SleepInterval = duration{time.Second}
 	}
+	for name, d := range c.SourceIntervals {
+		if d.Duration > 0 && d.Duration < time.Second {
+			klog.Warningf("too short interval specified for %q source (%s), forcing to 1s",
+				name, d.Duration.String())
+			c.SourceIntervals[name] = duration{time.Second}
+		}
+	}
+	switch c.DiscoveryTimeoutPolicy {
+	case DiscoveryTimeoutPolicyKeep, DiscoveryTimeoutPolicyDrop:
+	default:
+		klog.Warningf("invalid discoveryTimeoutPolicy %q specified, using %q",
+			c.DiscoveryTimeoutPolicy, DiscoveryTimeoutPolicyKeep)
+		c.DiscoveryTimeoutPolicy = DiscoveryTimeoutPolicyKeep
+	}
 }
 
 func (w *nfdWorker) configureCore(c coreConfig) error {
@@ -399,8 +691,20 @@ func (w *nfdWorker) configure(filepath string, overrides string) error {
 		c.Sources[s.Name()] = s.NewConfig()
 	}
 
-	// Try to read and parse config file
-	if filepath != "" {
+	if w.configMap != nil {
+		// Parse the base config and the matching overrides from the ConfigMap
+		layers, err := w.configMap.layers()
+		if err != nil {
+			return err
+		}
+		for _, l := range layers {
+			if err := yaml.Unmarshal(l.data, c); err != nil {
+				return fmt.Errorf("failed to parse %s: %s", l.name, err)
+			}
+			klog.Infof("configuration from %s parsed", l.name)
+		}
+	} else if filepath != "" {
+		// Try to read and parse config file
 		data, err := ioutil.ReadFile(filepath)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -414,15 +718,15 @@ func (w *nfdWorker) configure(filepath string, overrides string) error {
 				return fmt.Errorf("failed to parse config file: %s", err)
 			}
 
-			if c.Core.Sources != nil {
-				klog.Warningf("found deprecated 'core.sources' config file option, please use 'core.labelSources' instead")
-				c.Core.LabelSources = *c.Core.Sources
-			}
-
 			klog.Infof("configuration file %q parsed", filepath)
 		}
 	}
 
+	if c.Core.Sources != nil {
+		klog.Warningf("found deprecated 'core.sources' config file option, please use 'core.labelSources' instead")
+		c.Core.LabelSources = *c.Core.Sources
+	}
+
 	// Parse config overrides
 	if err := yaml.Unmarshal([]byte(overrides), c); err != nil {
 		return fmt.Errorf("failed to parse -options: %s", err)
@@ -447,6 +751,7 @@ func (w *nfdWorker) configure(filepath string, overrides string) error {
 	c.Core.sanitize()
 
 	w.config = c
+	w.introspection.setConfig(c)
 
 	if err := w.configureCore(c.Core); err != nil {
 		return err
@@ -544,36 +849,28 @@ func getFeatureLabels(source source.LabelSource, labelWhiteList regexp.Regexp) (
 	return labels, nil
 }
 
-// getFeatures returns raw features from all feature sources
-func getFeatures() map[string]*feature.DomainFeatures {
-	features := make(map[string]*feature.DomainFeatures)
-
-	for name, src := range source.GetAllFeatureSources() {
-		features[name] = src.GetFeatures()
-	}
-
-	return features
+// newLabelsRequest creates a labeling request for nfd-master.
+func (w *nfdWorker) newLabelsRequest(labels Labels) *pb.SetLabelsRequest {
+	return &pb.SetLabelsRequest{Labels: labels,
+		Features:   w.getFeatures(),
+		NfdVersion: version.Get(),
+		NodeName:   nfdclient.NodeName()}
 }
 
 // advertiseFeatureLabels advertises the feature labels to a Kubernetes node
 // via the NFD server.
 func (w *nfdWorker) advertiseFeatureLabels(labels Labels) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	labelReq := w.newLabelsRequest(labels)
 
-	klog.Infof("sending labeling request to nfd-master")
+	// Cache the request so that it can be re-sent after reconnecting
+	w.publish.setRequest(labelReq)
 
-	labelReq := pb.SetLabelsRequest{Labels: labels,
-		Features:   getFeatures(),
-		NfdVersion: version.Get(),
-		NodeName:   nfdclient.NodeName()}
-	_, err := w.client.SetLabels(ctx, &labelReq)
-	if err != nil {
-		klog.Errorf("failed to set node labels: %v", err)
-		return err
-	}
+	return w.sendLabelsRequest(labelReq, false)
+}
 
-	return nil
+// MarshalJSON implements the Marshaler interface from "encoding/json"
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
 }
 
 // UnmarshalJSON implements the Unmarshaler interface from "encoding/json"
@@ -18,6 +18,7 @@ package worker_test
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"regexp"
 	"testing"
@@ -108,6 +109,37 @@ func TestRun(t *testing.T) {
 	})
 }
 
+func TestRunUnreachableMaster(t *testing.T) {
+	Convey("When running nfd-worker against an unreachable nfd-master", t, func() {
+		// Connections are never accepted so gRPC never gets past the
+		// handshake
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer lis.Close()
+
+		args := &worker.Args{
+			Args:      nfdclient.Args{Server: lis.Addr().String()},
+			Overrides: worker.ConfigOverrideArgs{LabelSources: &utils.StringSliceVal{"fake"}},
+		}
+		w, err := worker.NewNfdWorker(args)
+		So(err, ShouldBeNil)
+
+		errs := make(chan error)
+		go func() { errs <- w.Run() }()
+
+		Convey("Stop should be handled without waiting for the connection", func() {
+			time.Sleep(500 * time.Millisecond)
+			w.Stop()
+			select {
+			case err := <-errs:
+				So(err, ShouldBeNil)
+			case <-time.After(5 * time.Second):
+				t.Fatal("nfd-worker did not stop")
+			}
+		})
+	})
+}
+
 func TestRunTls(t *testing.T) {
 	masterArgs := &master.Args{
 		CaFile:         data.FilePath("ca.crt"),
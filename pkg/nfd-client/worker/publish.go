@@ -0,0 +1,216 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+
+	pb "sigs.k8s.io/node-feature-discovery/pkg/labeler"
+)
+
+// publishState tracks the labeling requests sent to nfd-master. It is used for
+// suppressing requests that would not change anything and for re-sending the
+// latest request after the connection to nfd-master has been re-established.
+type publishState struct {
+	sync.Mutex
+
+	// request is the latest labeling request created
+	request  *pb.SetLabelsRequest
+	hash     []byte
+	lastSent time.Time
+	result   publishResult
+
+	// reconnected signals that the connection to nfd-master was re-established
+	reconnected chan struct{}
+}
+
+// publishResult describes the latest attempt to send a labeling request to
+// nfd-master.
+type publishResult struct {
+	// Time of the attempt
+	Time time.Time `json:"time"`
+	// Skipped is true if the request was suppressed as nothing had changed
+	Skipped bool `json:"skipped,omitempty"`
+	// Error from sending the request, if any
+	Error string `json:"error,omitempty"`
+}
+
+// requestHash returns a hash of a labeling request.
+func requestHash(r *pb.SetLabelsRequest) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// unchanged returns true if a request with the given hash was the latest one
+// sent and the heartbeat interval has not elapsed since sending it. A
+// non-positive heartbeat interval disables the suppression of requests.
+func (p *publishState) unchanged(hash []byte, heartbeat time.Duration) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if heartbeat <= 0 || p.hash == nil || !bytes.Equal(hash, p.hash) {
+		return false
+	}
+	return time.Since(p.lastSent) < heartbeat
+}
+
+// sent records a successfully sent labeling request.
+func (p *publishState) sent(hash []byte) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.hash = hash
+	p.lastSent = time.Now()
+}
+
+// setResult records the result of an attempt to send a labeling request.
+func (p *publishState) setResult(skipped bool, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.result = publishResult{Time: time.Now(), Skipped: skipped}
+	if err != nil {
+		p.result.Error = err.Error()
+	}
+}
+
+// getResult returns the result of the latest attempt to send a labeling
+// request.
+func (p *publishState) getResult() publishResult {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.result
+}
+
+// reset forces the next labeling request to be sent.
+func (p *publishState) reset() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.hash = nil
+}
+
+// setRequest caches the latest labeling request.
+func (p *publishState) setRequest(r *pb.SetLabelsRequest) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.request = r
+}
+
+// getRequest returns the latest labeling request, if any.
+func (p *publishState) getRequest() *pb.SetLabelsRequest {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.request
+}
+
+// watchConnection forces the next labeling request to be sent whenever the
+// connection to nfd-master is interrupted, e.g. because nfd-master was
+// restarted and lost its state, and signals when the connection has been
+// re-established. Returns when the connection is closed.
+func (p *publishState) watchConnection(conn *grpc.ClientConn) {
+	state := conn.GetState()
+	// A connection that is not up yet is signalled when established
+	interrupted := state != connectivity.Ready
+	for conn.WaitForStateChange(context.Background(), state) {
+		state = conn.GetState()
+		switch state {
+		case connectivity.Shutdown:
+			return
+		case connectivity.Ready:
+			if interrupted {
+				klog.Infof("connection to nfd-master established")
+				interrupted = false
+				select {
+				case p.reconnected <- struct{}{}:
+				default:
+				}
+			}
+		default:
+			if !interrupted {
+				klog.Warningf("connection to nfd-master interrupted (%s)", state)
+				interrupted = true
+			}
+			p.reset()
+		}
+	}
+}
+
+// sendLabelsRequest sends a labeling request to nfd-master or, in standalone
+// mode, processes it locally. The request is suppressed if it is identical to
+// the previous one, unless force is true.
+func (w *nfdWorker) sendLabelsRequest(r *pb.SetLabelsRequest, force bool) error {
+	// Skip the request if nothing has changed since the previous one
+	hash, err := requestHash(r)
+	if err != nil {
+		klog.Errorf("failed to hash labeling request: %v", err)
+	} else if !force && w.publish.unchanged(hash, w.config.Core.HeartbeatInterval.Duration) {
+		klog.Infof("features and labels unchanged, not sending labeling request to nfd-master")
+		w.publish.setResult(true, nil)
+		return nil
+	}
+
+	if w.args.Standalone {
+		klog.Infof("updating node %q", r.NodeName)
+		err = w.updateNode(r)
+	} else if conn := w.ClientConn(); conn != nil && conn.GetState() != connectivity.Ready {
+		// Fail fast instead of blocking until the connection is up. The
+		// request is re-sent when the connection has been established.
+		err = fmt.Errorf("connection to nfd-master not ready (%s)", conn.GetState())
+	} else {
+		klog.Infof("sending labeling request to nfd-master")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, err = w.client.SetLabels(ctx, r)
+	}
+	w.publish.setResult(false, err)
+	if err != nil {
+		klog.Errorf("failed to set node labels: %v", err)
+		w.publish.reset()
+		return err
+	}
+	w.publish.sent(hash)
+
+	return nil
+}
+
+// resendLabelsRequest re-sends the latest labeling request to nfd-master.
+func (w *nfdWorker) resendLabelsRequest() error {
+	r := w.publish.getRequest()
+	if r == nil || !w.connected() {
+		return nil
+	}
+	klog.Infof("re-sending the latest labeling request")
+	return w.sendLabelsRequest(r, true)
+}
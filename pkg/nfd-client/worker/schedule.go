@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"time"
+
+	"sigs.k8s.io/node-feature-discovery/source"
+)
+
+// discoverySchedule tracks when each feature source is due for discovery.
+// Sources without an entry are due immediately. A zero time means that the
+// source is not re-discovered periodically.
+type discoverySchedule map[string]time.Time
+
+// sourceInterval returns the interval between periodic discoveries of a
+// feature source.
+func (c *coreConfig) sourceInterval(name string) time.Duration {
+	if d, ok := c.SourceIntervals[name]; ok {
+		return d.Duration
+	}
+	return c.SleepInterval.Duration
+}
+
+// due returns the sources that are due for discovery at the given time.
+func (s discoverySchedule) due(sources []source.FeatureSource, now time.Time) []source.FeatureSource {
+	ret := []source.FeatureSource{}
+	for _, src := range sources {
+		if t, ok := s[src.Name()]; !ok || (!t.IsZero() && !t.After(now)) {
+			ret = append(ret, src)
+		}
+	}
+	return ret
+}
+
+// update schedules the next discovery of sources that were discovered at the
+// given time.
+func (s discoverySchedule) update(sources []source.FeatureSource, now time.Time, c *coreConfig) {
+	for _, src := range sources {
+		if interval := c.sourceInterval(src.Name()); interval > 0 {
+			s[src.Name()] = now.Add(interval)
+		} else {
+			s[src.Name()] = time.Time{}
+		}
+	}
+}
+
+// next returns the time of the next scheduled discovery of the given sources.
+// Returns false if no discovery is scheduled.
+func (s discoverySchedule) next(sources []source.FeatureSource) (time.Time, bool) {
+	var next time.Time
+	for _, src := range sources {
+		t, ok := s[src.Name()]
+		if !ok {
+			return time.Time{}, true
+		}
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// nextDiscoveryTrigger returns a channel that fires when the next discovery
+// is due, or nil if no discovery is scheduled.
+func (w *nfdWorker) nextDiscoveryTrigger() <-chan time.Time {
+	next, ok := w.schedule.next(w.featureSources)
+	if !ok {
+		return nil
+	}
+	return time.After(time.Until(next))
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	nfdclientset "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned"
+	nfdinformers "sigs.k8s.io/node-feature-discovery/pkg/generated/informers/externalversions"
+	pb "sigs.k8s.io/node-feature-discovery/pkg/labeler"
+	"sigs.k8s.io/node-feature-discovery/pkg/nodeupdater"
+)
+
+// ruleCacheSyncTimeout is the maximum time to wait for the initial list of
+// NodeFeatureRule objects.
+const ruleCacheSyncTimeout = 30 * time.Second
+
+// newKubeClients creates the Kubernetes API clients used in the standalone
+// mode.
+func newKubeClients(kubeconfigPath string) (apihelper.APIHelpers, nfdclientset.Interface, error) {
+	kubeconfig, err := apihelper.GetKubeconfig(kubeconfigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	nfdClient, err := nfdclientset.NewForConfig(kubeconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return apihelper.K8sHelpers{Kubeconfig: kubeconfig}, nfdClient, nil
+}
+
+// connectAPI initializes the Kubernetes API clients of the standalone mode and
+// starts watching NodeFeatureRule objects.
+func (w *nfdWorker) connectAPI() error {
+	helper, nfdClient, err := w.newKubeClients()
+	if err != nil {
+		return err
+	}
+	if err := w.startRuleInformer(nfdClient); err != nil {
+		return err
+	}
+	w.updater = &nodeupdater.Updater{APIHelper: helper, AnnotationNs: w.annotationNs}
+
+	return nil
+}
+
+// startRuleInformer starts an informer for NodeFeatureRule objects. Changes
+// to the rules are signalled through ruleEvents so that the node is
+// re-labeled without waiting for the next discovery round.
+func (w *nfdWorker) startRuleInformer(nfdClient nfdclientset.Interface) error {
+	rules := nodeupdater.NewRuleCache()
+	notify := func() {
+		select {
+		case w.ruleEvents <- struct{}{}:
+		default:
+		}
+	}
+
+	informerFactory := nfdinformers.NewSharedInformerFactory(nfdClient, 0)
+	informer := informerFactory.Nfd().V1alpha1().NodeFeatureRules()
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(object interface{}) {
+			key, _ := cache.MetaNamespaceKeyFunc(object)
+			klog.V(2).Infof("NodeFeatureRule %v added", key)
+			if r, ok := object.(*nfdv1alpha1.NodeFeatureRule); ok {
+				rules.Update(r)
+			}
+			notify()
+		},
+		UpdateFunc: func(oldObject, newObject interface{}) {
+			key, _ := cache.MetaNamespaceKeyFunc(newObject)
+			klog.V(2).Infof("NodeFeatureRule %v updated", key)
+			if r, ok := newObject.(*nfdv1alpha1.NodeFeatureRule); ok {
+				rules.Update(r)
+			}
+			notify()
+		},
+		DeleteFunc: func(object interface{}) {
+			key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(object)
+			klog.V(2).Infof("NodeFeatureRule %v deleted", key)
+			if tombstone, ok := object.(cache.DeletedFinalStateUnknown); ok {
+				object = tombstone.Obj
+			}
+			if r, ok := object.(*nfdv1alpha1.NodeFeatureRule); ok {
+				rules.Delete(r)
+			}
+			notify()
+		},
+	})
+
+	stop := make(chan struct{})
+	informerFactory.Start(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ruleCacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		close(stop)
+		return fmt.Errorf("failed to sync NodeFeatureRule cache")
+	}
+
+	w.ruleCache = rules
+	w.ruleLister = informer.Lister()
+	w.ruleInformerStop = stop
+
+	return nil
+}
+
+// stopRuleInformer stops the NodeFeatureRule informer, if running.
+func (w *nfdWorker) stopRuleInformer() {
+	if w.ruleInformerStop != nil {
+		close(w.ruleInformerStop)
+	}
+	w.ruleInformerStop = nil
+	w.ruleLister = nil
+	w.ruleCache = nil
+}
+
+// updateNode processes a labeling request locally, in the same way as
+// nfd-master does, and updates the node object directly.
+func (w *nfdWorker) updateNode(r *pb.SetLabelsRequest) error {
+	ruleLabels, err := w.ruleLabels(r)
+	if err != nil {
+		return err
+	}
+
+	rawLabels := make(nodeupdater.Labels, len(r.Labels)+len(ruleLabels))
+	for k, v := range r.Labels {
+		rawLabels[k] = v
+	}
+	for k, v := range ruleLabels {
+		rawLabels[k] = v
+	}
+
+	labels, extendedResources := nodeupdater.FilterFeatureLabels(rawLabels, w.args.ExtraLabelNs, w.config.Core.LabelWhiteList.Regexp, w.args.ResourceLabels)
+
+	// Advertise NFD worker version as an annotation
+	annotations := nodeupdater.Annotations{w.updater.AnnotationName(nodeupdater.WorkerVersionAnnotation): r.NfdVersion}
+
+	return w.updater.UpdateNodeFeatures(r.NodeName, labels, annotations, extendedResources)
+}
+
+// ruleLabels evaluates the NodeFeatureRule objects of the cluster against the
+// features of a labeling request. An error is returned if the rules cannot be
+// listed as that would cause all rule-based labels to be removed.
+func (w *nfdWorker) ruleLabels(r *pb.SetLabelsRequest) (nodeupdater.Labels, error) {
+	ruleSpecs, err := w.ruleLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NodeFeatureRule resources: %v", err)
+	}
+	klog.V(1).Infof("evaluating %d NodeFeatureRules", len(ruleSpecs))
+
+	// Copy the features so that the node domain is not stored in the request
+	features := make(map[string]*feature.DomainFeatures, len(r.Features)+1)
+	for k, v := range r.Features {
+		features[k] = v
+	}
+	w.updater.AddNodeFeatures(features, r.NodeName)
+
+	return w.ruleCache.EvaluateRules(r.NodeName, ruleSpecs, features), nil
+}
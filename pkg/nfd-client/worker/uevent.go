@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+	"sigs.k8s.io/node-feature-discovery/source"
+	"sigs.k8s.io/node-feature-discovery/source/memory"
+	"sigs.k8s.io/node-feature-discovery/source/network"
+	"sigs.k8s.io/node-feature-discovery/source/pci"
+	"sigs.k8s.io/node-feature-discovery/source/storage"
+	"sigs.k8s.io/node-feature-discovery/source/usb"
+)
+
+// ueventMaxDelayFactor limits how long a steady stream of uevents may
+// postpone rediscovery: discovery is run at the latest ueventMaxDelayFactor
+// times core.ueventDebounce after the first uevent of a burst.
+const ueventMaxDelayFactor = 10
+
+// ueventSources maps the subsystems of kernel uevents to the feature sources
+// whose features are affected by them. Uevents are broadcast to all network
+// namespaces, except for uevents of the "net" subsystem which the kernel only
+// delivers to the network namespace of the network device. Thus, nfd-worker
+// must run with hostNetwork enabled in order to receive uevents about the
+// network devices of the host.
+var ueventSources = map[string]string{
+	"pci":   pci.Name,
+	"usb":   usb.Name,
+	"net":   network.Name,
+	"block": storage.Name,
+	"nd":    memory.Name,
+}
+
+// configureUevents starts or stops listening to kernel uevents, according to
+// the configuration.
+func (w *nfdWorker) configureUevents() {
+	if w.config.Core.Uevents && w.uevents == nil {
+		l, err := w.newUeventListener()
+		if err != nil {
+			klog.Errorf("failed to listen to kernel uevents, hotplug-driven rediscovery disabled: %v", err)
+			return
+		}
+		klog.Infof("listening to kernel uevents")
+		w.uevents = l
+	} else if !w.config.Core.Uevents && w.uevents != nil {
+		klog.Infof("stopped listening to kernel uevents")
+		w.stopUevents()
+	}
+}
+
+// stopUevents stops listening to kernel uevents.
+func (w *nfdWorker) stopUevents() {
+	if w.uevents != nil {
+		if err := w.uevents.Close(); err != nil {
+			klog.Errorf("failed to close uevent listener: %v", err)
+		}
+		w.uevents = nil
+	}
+}
+
+// ueventEvents returns the channel delivering kernel uevents, or nil if
+// uevents are not listened to.
+func (w *nfdWorker) ueventEvents() <-chan utils.Uevent {
+	if w.uevents == nil {
+		return nil
+	}
+	return w.uevents.Events()
+}
+
+// ueventSource returns the enabled feature source affected by a uevent, if
+// any.
+func (w *nfdWorker) ueventSource(e utils.Uevent) source.FeatureSource {
+	name, ok := ueventSources[e.Subsystem]
+	if !ok {
+		return nil
+	}
+	for _, s := range w.featureSources {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// ueventDelay returns how long to wait before running the discovery triggered
+// by a uevent received at now. The discovery is debounced, but not postponed
+// beyond deadline.
+func ueventDelay(now, deadline time.Time, debounce time.Duration) time.Duration {
+	if d := deadline.Sub(now); d < debounce {
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+	return debounce
+}
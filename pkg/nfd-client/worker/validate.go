@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+	"sigs.k8s.io/node-feature-discovery/source"
+	"sigs.k8s.io/node-feature-discovery/source/custom"
+)
+
+// validate checks the configuration file, the configuration overrides file
+// next to it, the -options and the custom rule files, writing all errors found to out. An error is returned if the
+// configuration is invalid.
+func (w *nfdWorker) validate(out io.Writer) error {
+	errs := []string{}
+
+	if w.configFilePath != "" {
+		data, err := ioutil.ReadFile(w.configFilePath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", w.configFilePath, err))
+			}
+		} else {
+			for _, err := range validateConfigData(data) {
+				errs = append(errs, utils.FormatYAMLError(w.configFilePath, data, err))
+			}
+		}
+	}
+	if w.configFilePath != "" {
+		overridesPath := filepath.Join(filepath.Dir(w.configFilePath), ConfigMapOverridesKey)
+		data, err := ioutil.ReadFile(overridesPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", overridesPath, err))
+			}
+		} else {
+			for _, err := range validateOverridesData(data) {
+				errs = append(errs, utils.FormatYAMLError(overridesPath, data, err))
+			}
+		}
+	}
+	for _, err := range validateConfigData([]byte(w.args.Options)) {
+		errs = append(errs, utils.FormatYAMLError("-options", []byte(w.args.Options), err))
+	}
+
+	// Check the effective configuration as a whole. Only done if the parts
+	// were valid, to avoid reporting the same errors twice.
+	if len(errs) == 0 {
+		if err := w.configure(w.configFilePath, w.args.Options); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			for _, err := range custom.ValidateRules(custom.Directory) {
+				errs = append(errs, formatRuleFileError(err))
+			}
+		}
+	}
+
+	for _, e := range errs {
+		fmt.Fprintln(out, e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("configuration validation failed: %d error(s) found", len(errs))
+	}
+	klog.Infof("configuration successfully validated")
+	return nil
+}
+
+// validateConfigData strictly parses configuration data, validating the
+// config of each configurable source.
+func validateConfigData(data []byte) []error {
+	raw := struct {
+		Core    json.RawMessage            `json:"core"`
+		Sources map[string]json.RawMessage `json:"sources"`
+	}{}
+	if err := utils.UnmarshalYAMLStrict(data, &raw); err != nil {
+		return []error{err}
+	}
+
+	errs := []error{}
+	if raw.Core != nil {
+		c := newDefaultConfig().Core
+		if err := utils.UnmarshalYAMLStrict(raw.Core, &c); err != nil {
+			errs = append(errs, utils.WrapFieldError("core", err))
+		}
+	}
+
+	confSources := source.GetAllConfigurableSources()
+	names := make([]string, 0, len(raw.Sources))
+	for n := range raw.Sources {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		path := "sources." + n
+		s, ok := confSources[n]
+		if !ok {
+			errs = append(errs, &utils.FieldError{Path: path, Err: fmt.Errorf("unknown feature source")})
+			continue
+		}
+
+		var err error
+		if vs, ok := s.(source.ValidatingSource); ok {
+			err = vs.ValidateConfig(raw.Sources[n])
+		} else {
+			err = utils.UnmarshalYAMLStrict(raw.Sources[n], s.NewConfig())
+		}
+		if err != nil {
+			errs = append(errs, utils.WrapFieldError(path, err))
+		}
+	}
+	return errs
+}
+
+// validateOverridesData strictly parses a list of configuration overrides,
+// validating the node selector and the configuration of each override.
+func validateOverridesData(data []byte) []error {
+	overrides := []configOverride{}
+	if err := utils.UnmarshalYAMLStrict(data, &overrides); err != nil {
+		return []error{err}
+	}
+
+	errs := []error{}
+	for i, o := range overrides {
+		path := fmt.Sprintf("[%d]", i)
+		if _, err := labels.ValidatedSelectorFromSet(o.NodeSelector); err != nil {
+			errs = append(errs, utils.WrapFieldError(path+".nodeSelector", err))
+		}
+		for _, err := range validateConfigData(o.Config) {
+			errs = append(errs, utils.WrapFieldError(path+".config", err))
+		}
+	}
+	return errs
+}
+
+// formatRuleFileError formats an error returned by custom rule validation,
+// adding line context for errors in rule files.
+func formatRuleFileError(err error) string {
+	var fe *custom.RuleFileError
+	if !errors.As(err, &fe) {
+		return err.Error()
+	}
+	data, _ := ioutil.ReadFile(fe.File)
+	return utils.FormatYAMLError(fe.File, data, fe.Err)
+}
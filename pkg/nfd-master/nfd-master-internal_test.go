@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/smartystreets/assertions"
 	. "github.com/smartystreets/goconvey/convey"
@@ -29,10 +30,11 @@ import (
 	"github.com/vektra/errors"
 	"golang.org/x/net/context"
 	api "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sclient "k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	fakenfdclient "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned/fake"
 	"sigs.k8s.io/node-feature-discovery/pkg/labeler"
 	"sigs.k8s.io/node-feature-discovery/pkg/utils"
 	"sigs.k8s.io/node-feature-discovery/pkg/version"
@@ -58,6 +60,7 @@ func newMockMaster(apihelper apihelper.APIHelpers) *nfdMaster {
 		annotationNs: AnnotationNsBase,
 		args:         Args{LabelWhiteList: utils.RegexpVal{Regexp: *regexp.MustCompile("")}},
 		apihelper:    apihelper,
+		nodeRequests: make(map[string]*labeler.SetLabelsRequest),
 	}
 }
 
@@ -216,82 +219,6 @@ func TestUpdateMasterNode(t *testing.T) {
 	})
 }
 
-func TestAddingExtResources(t *testing.T) {
-	Convey("When adding extended resources", t, func() {
-		mockMaster := newMockMaster(nil)
-		Convey("When there are no matching labels", func() {
-			mockNode := newMockNode()
-			mockResourceLabels := ExtendedResources{}
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(len(patches), ShouldEqual, 0)
-		})
-
-		Convey("When there are matching labels", func() {
-			mockNode := newMockNode()
-			mockResourceLabels := ExtendedResources{"feature-1": "1", "feature-2": "2"}
-			expectedPatches := []apihelper.JsonPatch{
-				apihelper.NewJsonPatch("add", "/status/capacity", "feature-1", "1"),
-				apihelper.NewJsonPatch("add", "/status/capacity", "feature-2", "2"),
-			}
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(sortJsonPatches(patches), ShouldResemble, sortJsonPatches(expectedPatches))
-		})
-
-		Convey("When the resource already exists", func() {
-			mockNode := newMockNode()
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
-			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-1": "1"}
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(len(patches), ShouldEqual, 0)
-		})
-
-		Convey("When the resource already exists but its capacity has changed", func() {
-			mockNode := newMockNode()
-			mockNode.Status.Capacity[api.ResourceName("feature-1")] = *resource.NewQuantity(2, resource.BinarySI)
-			mockResourceLabels := ExtendedResources{"feature-1": "1"}
-			expectedPatches := []apihelper.JsonPatch{
-				apihelper.NewJsonPatch("replace", "/status/capacity", "feature-1", "1"),
-				apihelper.NewJsonPatch("replace", "/status/allocatable", "feature-1", "1"),
-			}
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(sortJsonPatches(patches), ShouldResemble, sortJsonPatches(expectedPatches))
-		})
-	})
-}
-
-func TestRemovingExtResources(t *testing.T) {
-	Convey("When removing extended resources", t, func() {
-		mockMaster := newMockMaster(nil)
-		Convey("When none are removed", func() {
-			mockNode := newMockNode()
-			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-1": "1", FeatureLabelNs + "/feature-2": "2"}
-			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-1,feature-2"
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(len(patches), ShouldEqual, 0)
-		})
-		Convey("When the related label is gone", func() {
-			mockNode := newMockNode()
-			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-4": "", FeatureLabelNs + "/feature-2": "2"}
-			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-4,feature-2"
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-4")] = *resource.NewQuantity(4, resource.BinarySI)
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(len(patches), ShouldBeGreaterThan, 0)
-		})
-		Convey("When the extended resource is no longer wanted", func() {
-			mockNode := newMockNode()
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
-			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
-			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-2": "2"}
-			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-1,feature-2"
-			patches := mockMaster.createExtendedResourcePatches(mockNode, mockResourceLabels)
-			So(len(patches), ShouldBeGreaterThan, 0)
-		})
-	})
-}
-
 func TestSetLabels(t *testing.T) {
 	Convey("When servicing SetLabels request", t, func() {
 		const workerName = "mock-worker"
@@ -433,81 +360,61 @@ func TestSetLabels(t *testing.T) {
 	})
 }
 
-func TestCreatePatches(t *testing.T) {
-	Convey("When creating JSON patches", t, func() {
-		existingItems := map[string]string{"key-1": "val-1", "key-2": "val-2", "key-3": "val-3"}
-		jsonPath := "/root"
-
-		Convey("When when there are neither itmes to remoe nor to add or update", func() {
-			p := createPatches([]string{"foo", "bar"}, existingItems, map[string]string{}, jsonPath)
-			So(len(p), ShouldEqual, 0)
-		})
-
-		Convey("When when there are itmes to remoe but none to add or update", func() {
-			p := createPatches([]string{"key-2", "key-3", "foo"}, existingItems, map[string]string{}, jsonPath)
-			expected := []apihelper.JsonPatch{
-				apihelper.NewJsonPatch("remove", jsonPath, "key-2", ""),
-				apihelper.NewJsonPatch("remove", jsonPath, "key-3", ""),
+func TestReevaluateRules(t *testing.T) {
+	Convey("When NodeFeatureRules change", t, func() {
+		mockHelper := &apihelper.MockAPIHelpers{}
+		mockMaster := newMockMaster(mockHelper)
+		mockClient := &k8sclient.Clientset{}
+		nfdClient := fakenfdclient.NewSimpleClientset()
+		mockMaster.nfdController = newNfdController(nfdClient)
+		defer mockMaster.nfdController.stop()
+
+		ruleLabel := apihelper.NewJsonPatch("add", "/metadata/labels", FeatureLabelNs+"/rule-label", "true")
+		hasRuleLabel := func(patches []apihelper.JsonPatch) bool {
+			for _, p := range patches {
+				if p == ruleLabel {
+					return true
+				}
 			}
-			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
-		})
+			return false
+		}
 
-		Convey("When when there are no itmes to remove but new items to add", func() {
-			newItems := map[string]string{"new-key": "new-val", "key-1": "new-1"}
-			p := createPatches([]string{"key-1"}, existingItems, newItems, jsonPath)
-			expected := []apihelper.JsonPatch{
-				apihelper.NewJsonPatch("add", jsonPath, "new-key", newItems["new-key"]),
-				apihelper.NewJsonPatch("replace", jsonPath, "key-1", newItems["key-1"]),
-			}
-			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
-		})
+		mockHelper.On("GetClient").Return(mockClient, nil)
+		mockHelper.On("GetNode", mockClient, mockNodeName).Return(newMockNode(), nil)
+		mockHelper.On("PatchNodeStatus", mockClient, mockNodeName, mock.Anything).Return(nil)
+		mockHelper.On("PatchNode", mockClient, mockNodeName, mock.MatchedBy(func(p []apihelper.JsonPatch) bool { return !hasRuleLabel(p) })).Return(nil).Once()
 
-		Convey("When when there are items to remove add and update", func() {
-			newItems := map[string]string{"new-key": "new-val", "key-2": "new-2", "key-4": "val-4"}
-			p := createPatches([]string{"key-1", "key-2", "key-3", "foo"}, existingItems, newItems, jsonPath)
-			expected := []apihelper.JsonPatch{
-				apihelper.NewJsonPatch("add", jsonPath, "new-key", newItems["new-key"]),
-				apihelper.NewJsonPatch("add", jsonPath, "key-4", newItems["key-4"]),
-				apihelper.NewJsonPatch("replace", jsonPath, "key-2", newItems["key-2"]),
-				apihelper.NewJsonPatch("remove", jsonPath, "key-1", ""),
-				apihelper.NewJsonPatch("remove", jsonPath, "key-3", ""),
-			}
-			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
-		})
-	})
-}
+		mockReq := &labeler.SetLabelsRequest{NodeName: mockNodeName, NfdVersion: "0.1-test", Labels: map[string]string{"feature-1": "1"}}
+		_, err := mockMaster.SetLabels(context.Background(), mockReq)
+		So(err, ShouldBeNil)
 
-func TestRemoveLabelsWithPrefix(t *testing.T) {
-	Convey("When removing labels", t, func() {
-		n := &api.Node{
-			ObjectMeta: meta_v1.ObjectMeta{
-				Labels: map[string]string{
-					"single-label": "123",
-					"multiple_A":   "a",
-					"multiple_B":   "b",
+		Convey("the latest labeling requests should be re-processed", func() {
+			rule := &nfdv1alpha1.NodeFeatureRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule-1"},
+				Spec: nfdv1alpha1.NodeFeatureRuleSpec{
+					Rules: []nfdv1alpha1.Rule{{Name: "rule", Labels: map[string]string{"rule-label": "true"}}},
 				},
-			},
-		}
+			}
+			_, err := nfdClient.NfdV1alpha1().NodeFeatureRules().Create(context.Background(), rule, metav1.CreateOptions{})
+			So(err, ShouldBeNil)
 
-		Convey("a unique label should be removed", func() {
-			p := removeLabelsWithPrefix(n, "single")
-			So(p, ShouldResemble, []apihelper.JsonPatch{apihelper.NewJsonPatch("remove", "/metadata/labels", "single-label", "")})
-		})
+			select {
+			case <-mockMaster.ruleUpdates():
+			case <-time.After(5 * time.Second):
+				t.Fatal("NodeFeatureRule update not received")
+			}
 
-		Convey("a non-unique search string should remove all matching keys", func() {
-			p := removeLabelsWithPrefix(n, "multiple")
-			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches([]apihelper.JsonPatch{
-				apihelper.NewJsonPatch("remove", "/metadata/labels", "multiple_A", ""),
-				apihelper.NewJsonPatch("remove", "/metadata/labels", "multiple_B", ""),
-			}))
+			mockHelper.On("PatchNode", mockClient, mockNodeName, mock.MatchedBy(hasRuleLabel)).Return(nil).Once()
+			mockMaster.reevaluateRules()
+			mockHelper.AssertExpectations(t)
+			So(mockReq.Labels, ShouldResemble, map[string]string{"feature-1": "1"})
 		})
 
-		Convey("a search string with no matches should not alter labels", func() {
-			removeLabelsWithPrefix(n, "unique")
-			So(n.Labels, ShouldContainKey, "single-label")
-			So(n.Labels, ShouldContainKey, "multiple_A")
-			So(n.Labels, ShouldContainKey, "multiple_B")
-			So(len(n.Labels), ShouldEqual, 3)
+		Convey("nodes whose update fails should be forgotten", func() {
+			mockHelper.ExpectedCalls = nil
+			mockHelper.On("GetClient").Return(nil, errors.New("mock-error"))
+			mockMaster.reevaluateRules()
+			So(mockMaster.nodeRequests, ShouldBeEmpty)
 		})
 	})
 }
@@ -22,11 +22,7 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"path"
-	"regexp"
-	"sort"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/k8stopologyawareschedwg/noderesourcetopology-api/pkg/apis/topology/v1alpha1"
@@ -36,7 +32,6 @@ import (
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/peer"
-	api "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -46,7 +41,9 @@ import (
 	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	nfdclientset "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned"
 	pb "sigs.k8s.io/node-feature-discovery/pkg/labeler"
+	"sigs.k8s.io/node-feature-discovery/pkg/nodeupdater"
 	topologypb "sigs.k8s.io/node-feature-discovery/pkg/topologyupdater"
 	"sigs.k8s.io/node-feature-discovery/pkg/utils"
 	"sigs.k8s.io/node-feature-discovery/pkg/version"
@@ -54,35 +51,35 @@ import (
 
 const (
 	// FeatureLabelNs is the namespace for feature labels
-	FeatureLabelNs = "feature.node.kubernetes.io"
+	FeatureLabelNs = nodeupdater.FeatureLabelNs
 
 	// FeatureLabelSubNsSuffix is the suffix for allowed feature label sub-namespaces
-	FeatureLabelSubNsSuffix = "." + FeatureLabelNs
+	FeatureLabelSubNsSuffix = nodeupdater.FeatureLabelSubNsSuffix
 
 	// ProfileLabelNs is the namespace for profile labels
-	ProfileLabelNs = "profile.node.kubernetes.io"
+	ProfileLabelNs = nodeupdater.ProfileLabelNs
 
 	// ProfileLabelSubNsSuffix is the suffix for allowed profile label sub-namespaces
-	ProfileLabelSubNsSuffix = "." + ProfileLabelNs
+	ProfileLabelSubNsSuffix = nodeupdater.ProfileLabelSubNsSuffix
 
 	// AnnotationNsBase namespace for all NFD-related annotations
-	AnnotationNsBase = "nfd.node.kubernetes.io"
+	AnnotationNsBase = nodeupdater.AnnotationNsBase
 
 	// NFD Annotations
-	extendedResourceAnnotation = "extended-resources"
-	featureLabelAnnotation     = "feature-labels"
-	masterVersionAnnotation    = "master.version"
-	workerVersionAnnotation    = "worker.version"
+	extendedResourceAnnotation = nodeupdater.ExtendedResourceAnnotation
+	featureLabelAnnotation     = nodeupdater.FeatureLabelAnnotation
+	masterVersionAnnotation    = nodeupdater.MasterVersionAnnotation
+	workerVersionAnnotation    = nodeupdater.WorkerVersionAnnotation
 )
 
 // Labels are a Kubernetes representation of discovered features.
-type Labels map[string]string
+type Labels = nodeupdater.Labels
 
 // ExtendedResources are k8s extended resources which are created from discovered features.
-type ExtendedResources map[string]string
+type ExtendedResources = nodeupdater.ExtendedResources
 
 // Annotations are used for NFD-related node metadata
-type Annotations map[string]string
+type Annotations = nodeupdater.Annotations
 
 // Args holds command line arguments
 type Args struct {
@@ -118,26 +115,27 @@ type nfdMaster struct {
 	ready        chan bool
 	apihelper    apihelper.APIHelpers
 	kubeconfig   *restclient.Config
+
+	// nodeRequests holds the latest labeling request of each node for
+	// re-evaluating NodeFeatureRules when they change
+	nodeRequests     map[string]*pb.SetLabelsRequest
+	nodeRequestsLock sync.Mutex
 }
 
 // NewNfdMaster creates a new NfdMaster server instance.
 func NewNfdMaster(args *Args) (NfdMaster, error) {
 	nfd := &nfdMaster{args: *args,
-		nodeName: os.Getenv("NODE_NAME"),
-		ready:    make(chan bool, 1),
-		stop:     make(chan struct{}, 1),
+		nodeName:     os.Getenv("NODE_NAME"),
+		ready:        make(chan bool, 1),
+		stop:         make(chan struct{}, 1),
+		nodeRequests: make(map[string]*pb.SetLabelsRequest),
 	}
 
-	if args.Instance == "" {
-		nfd.annotationNs = AnnotationNsBase
-	} else {
-		if ok, _ := regexp.MatchString(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`, args.Instance); !ok {
-			return nfd, fmt.Errorf("invalid -instance %q: instance name "+
-				"must start and end with an alphanumeric character and may only contain "+
-				"alphanumerics, `-`, `_` or `.`", args.Instance)
-		}
-		nfd.annotationNs = args.Instance + "." + AnnotationNsBase
+	annotationNs, err := nodeupdater.InstanceAnnotationNs(args.Instance)
+	if err != nil {
+		return nfd, err
 	}
+	nfd.annotationNs = annotationNs
 
 	// Check TLS related args
 	if args.CertFile != "" || args.KeyFile != "" || args.CaFile != "" {
@@ -183,7 +181,7 @@ func (m *nfdMaster) Run() error {
 			return err
 		}
 		klog.Info("starting nfd api controller")
-		m.nfdController = newNfdController(kubeconfig)
+		m.nfdController = newNfdController(nfdclientset.NewForConfigOrDie(kubeconfig))
 	}
 
 	if !m.args.NoPublish {
@@ -247,6 +245,9 @@ func (m *nfdMaster) Run() error {
 			}
 			klog.Infof("gRPC server stopped")
 
+		case <-m.ruleUpdates():
+			m.reevaluateRules()
+
 		case <-m.stop:
 			klog.Infof("shutting down nfd-master")
 			certWatch.Close()
@@ -299,101 +300,18 @@ func (m *nfdMaster) prune() error {
 	for _, node := range nodes.Items {
 		klog.Infof("pruning node %q...", node.Name)
 
-		// Prune labels and extended resources
-		err := m.updateNodeFeatures(node.Name, Labels{}, Annotations{}, ExtendedResources{})
-		if err != nil {
-			return fmt.Errorf("failed to prune labels from node %q: %v", node.Name, err)
-		}
-
-		// Prune annotations
-		node, err := m.apihelper.GetNode(cli, node.Name)
-		if err != nil {
+		if err := m.updater().PruneNode(node.Name); err != nil {
 			return err
 		}
-		for a := range node.Annotations {
-			if strings.HasPrefix(a, m.annotationNs) {
-				delete(node.Annotations, a)
-			}
-		}
-		err = m.apihelper.UpdateNode(cli, node)
-		if err != nil {
-			return fmt.Errorf("failed to prune annotations from node %q: %v", node.Name, err)
-		}
 	}
 	return nil
 }
 
 // Advertise NFD master information
 func (m *nfdMaster) updateMasterNode() error {
-	cli, err := m.apihelper.GetClient()
-	if err != nil {
-		return err
-	}
-	node, err := m.apihelper.GetNode(cli, m.nodeName)
-	if err != nil {
-		return err
-	}
-
 	// Advertise NFD version as an annotation
-	p := createPatches(nil,
-		node.Annotations,
-		Annotations{m.annotationName(masterVersionAnnotation): version.Get()},
-		"/metadata/annotations")
-	err = m.apihelper.PatchNode(cli, node.Name, p)
-	if err != nil {
-		return fmt.Errorf("failed to patch node annotations: %v", err)
-	}
-
-	return nil
-}
-
-// Filter labels by namespace and name whitelist, and, turn selected labels
-// into extended resources. This function also handles proper namespacing of
-// labels and ERs, i.e. adds the possibly missing default namespace for labels
-// arriving through the gRPC API.
-func filterFeatureLabels(labels Labels, extraLabelNs map[string]struct{}, labelWhiteList regexp.Regexp, extendedResourceNames map[string]struct{}) (Labels, ExtendedResources) {
-	outLabels := Labels{}
-
-	for label, value := range labels {
-		// Add possibly missing default ns
-		label := addNs(label, FeatureLabelNs)
-
-		ns, name := splitNs(label)
-
-		// Check label namespace, filter out if ns is not whitelisted
-		if ns != FeatureLabelNs && ns != ProfileLabelNs &&
-			!strings.HasSuffix(ns, FeatureLabelSubNsSuffix) && !strings.HasSuffix(ns, ProfileLabelSubNsSuffix) {
-			if _, ok := extraLabelNs[ns]; !ok {
-				klog.Errorf("Namespace %q is not allowed. Ignoring label %q\n", ns, label)
-				continue
-			}
-		}
-
-		// Skip if label doesn't match labelWhiteList
-		if !labelWhiteList.MatchString(name) {
-			klog.Errorf("%s (%s) does not match the whitelist (%s) and will not be published.", name, label, labelWhiteList.String())
-			continue
-		}
-		outLabels[label] = value
-	}
-
-	// Remove labels which are intended to be extended resources
-	extendedResources := ExtendedResources{}
-	for extendedResourceName := range extendedResourceNames {
-		// Add possibly missing default ns
-		extendedResourceName = addNs(extendedResourceName, FeatureLabelNs)
-		if value, ok := outLabels[extendedResourceName]; ok {
-			if _, err := strconv.Atoi(value); err != nil {
-				klog.Errorf("bad label value (%s: %s) encountered for extended resource: %s", extendedResourceName, value, err.Error())
-				continue // non-numeric label can't be used
-			}
-
-			extendedResources[extendedResourceName] = value
-			delete(outLabels, extendedResourceName)
-		}
-	}
-
-	return outLabels, extendedResources
+	return m.updater().UpdateNodeAnnotations(m.nodeName,
+		Annotations{m.annotationName(masterVersionAnnotation): version.Get()})
 }
 
 func verifyNodeName(cert *x509.Certificate, nodeName string) error {
@@ -423,17 +341,32 @@ func (m *nfdMaster) SetLabels(c context.Context, r *pb.SetLabelsRequest) (*pb.Se
 		klog.Infof("received labeling request for node %q", r.NodeName)
 	}
 
-	// Mix in CR-originated labels
-	rawLabels := make(map[string]string)
-	if r.Labels != nil {
-		// NOTE: we effectively mangle the request struct by not creating a deep copy of the map
-		rawLabels = r.Labels
+	if m.nfdController != nil {
+		m.nodeRequestsLock.Lock()
+		m.nodeRequests[r.NodeName] = r
+		m.nodeRequestsLock.Unlock()
+	}
+
+	if err := m.processLabelingRequest(r); err != nil {
+		return &pb.SetLabelsReply{}, err
+	}
+	return &pb.SetLabelsReply{}, nil
+}
+
+// processLabelingRequest updates the node object according to a labeling
+// request, mixing in the labels created by NodeFeatureRules.
+func (m *nfdMaster) processLabelingRequest(r *pb.SetLabelsRequest) error {
+	// Mix in CR-originated labels. Work on a copy as the request may be
+	// re-processed when the rules change.
+	rawLabels := make(map[string]string, len(r.Labels))
+	for k, v := range r.Labels {
+		rawLabels[k] = v
 	}
 	for k, v := range m.crLabels(r) {
 		rawLabels[k] = v
 	}
 
-	labels, extendedResources := filterFeatureLabels(rawLabels, m.args.ExtraLabelNs, m.args.LabelWhiteList.Regexp, m.args.ResourceLabels)
+	labels, extendedResources := nodeupdater.FilterFeatureLabels(rawLabels, m.args.ExtraLabelNs, m.args.LabelWhiteList.Regexp, m.args.ResourceLabels)
 
 	if !m.args.NoPublish {
 		// Advertise NFD worker version as an annotation
@@ -442,10 +375,44 @@ func (m *nfdMaster) SetLabels(c context.Context, r *pb.SetLabelsRequest) (*pb.Se
 		err := m.updateNodeFeatures(r.NodeName, labels, annotations, extendedResources)
 		if err != nil {
 			klog.Errorf("failed to advertise labels: %v", err)
-			return &pb.SetLabelsReply{}, err
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleUpdates returns the channel notifying about changes in NodeFeatureRule
+// objects, or nil if the NodeFeatureRule controller is not running.
+func (m *nfdMaster) ruleUpdates() <-chan struct{} {
+	if m.nfdController == nil {
+		return nil
+	}
+	return m.nfdController.updates
+}
+
+// reevaluateRules re-processes the latest labeling request of every node so
+// that changes in NodeFeatureRules take effect without waiting for the
+// workers to send new requests. Nodes whose update fails, e.g. because the
+// node has been deleted, are forgotten until they send a new request.
+func (m *nfdMaster) reevaluateRules() {
+	m.nodeRequestsLock.Lock()
+	requests := make([]*pb.SetLabelsRequest, 0, len(m.nodeRequests))
+	for _, r := range m.nodeRequests {
+		requests = append(requests, r)
+	}
+	m.nodeRequestsLock.Unlock()
+
+	klog.Infof("NodeFeatureRules changed, re-evaluating rules for %d nodes", len(requests))
+	for _, r := range requests {
+		if err := m.processLabelingRequest(r); err != nil {
+			klog.Errorf("failed to update node %q: %v", r.NodeName, err)
+			m.nodeRequestsLock.Lock()
+			if m.nodeRequests[r.NodeName] == r {
+				delete(m.nodeRequests, r.NodeName)
+			}
+			m.nodeRequestsLock.Unlock()
 		}
 	}
-	return &pb.SetLabelsReply{}, nil
 }
 
 func authorizeClient(c context.Context, checkNodeName bool, nodeName string) error {
@@ -502,106 +469,42 @@ func (m *nfdMaster) crLabels(r *pb.SetLabelsRequest) map[string]string {
 		return nil
 	}
 
-	l := make(map[string]string)
 	ruleSpecs, err := m.nfdController.lister.List(labels.Everything())
-	sort.Slice(ruleSpecs, func(i, j int) bool {
-		return ruleSpecs[i].Name < ruleSpecs[j].Name
-	})
-
 	if err != nil {
 		klog.Errorf("failed to list NodeFeatureRule resources: %v", err)
 		return nil
 	}
 
-	// Process all rule CRs
-	for _, spec := range ruleSpecs {
-		switch {
-		case klog.V(3).Enabled():
-			h := fmt.Sprintf("executing NodeFeatureRule %q:", spec.ObjectMeta.Name)
-			utils.KlogDump(3, h, "  ", spec.Spec)
-		case klog.V(1).Enabled():
-			klog.Infof("executing NodeFeatureRule %q", spec.ObjectMeta.Name)
-		}
-		for _, rule := range spec.Spec.Rules {
-			ruleOut, err := rule.Execute(r.Features)
-			if err != nil {
-				klog.Errorf("failed to process Rule %q: %v", rule.Name, err)
-				continue
-			}
-
-			for k, v := range ruleOut.Labels {
-				l[k] = v
-			}
-
-			// Feed back rule output to features map for subsequent rules to match
-			feature.InsertFeatureValues(r.Features, nfdv1alpha1.RuleBackrefDomain, nfdv1alpha1.RuleBackrefFeature, ruleOut.Labels)
-			feature.InsertFeatureValues(r.Features, nfdv1alpha1.RuleBackrefDomain, nfdv1alpha1.RuleBackrefFeature, ruleOut.Vars)
-		}
+	// Inject features from the node object for the rules to match against.
+	// The node domain is reserved and never taken from the request. Work on
+	// a copy in order not to modify the request.
+	features := make(map[string]*feature.DomainFeatures, len(r.Features)+1)
+	for k, v := range r.Features {
+		features[k] = v
+	}
+	if m.args.NoPublish {
+		delete(features, nfdv1alpha1.NodeDomain)
+	} else {
+		m.updater().AddNodeFeatures(features, r.NodeName)
 	}
 
-	return l
+	return m.nfdController.rules.EvaluateRules(r.NodeName, ruleSpecs, features)
+}
+
+// updater returns the helper for updating node objects.
+func (m *nfdMaster) updater() *nodeupdater.Updater {
+	return &nodeupdater.Updater{APIHelper: m.apihelper, AnnotationNs: m.annotationNs}
 }
 
 // updateNodeFeatures ensures the Kubernetes node object is up to date,
 // creating new labels and extended resources where necessary and removing
 // outdated ones. Also updates the corresponding annotations.
 func (m *nfdMaster) updateNodeFeatures(nodeName string, labels Labels, annotations Annotations, extendedResources ExtendedResources) error {
-	cli, err := m.apihelper.GetClient()
-	if err != nil {
-		return err
-	}
-
-	// Get the worker node object
-	node, err := m.apihelper.GetNode(cli, nodeName)
-	if err != nil {
-		return err
-	}
-
-	// Store names of labels in an annotation
-	labelKeys := make([]string, 0, len(labels))
-	for key := range labels {
-		// Drop the ns part for labels in the default ns
-		labelKeys = append(labelKeys, strings.TrimPrefix(key, FeatureLabelNs+"/"))
-	}
-	sort.Strings(labelKeys)
-	annotations[m.annotationName(featureLabelAnnotation)] = strings.Join(labelKeys, ",")
-
-	// Store names of extended resources in an annotation
-	extendedResourceKeys := make([]string, 0, len(extendedResources))
-	for key := range extendedResources {
-		// Drop the ns part if in the default ns
-		extendedResourceKeys = append(extendedResourceKeys, strings.TrimPrefix(key, FeatureLabelNs+"/"))
-	}
-	sort.Strings(extendedResourceKeys)
-	annotations[m.annotationName(extendedResourceAnnotation)] = strings.Join(extendedResourceKeys, ",")
-
-	// Create JSON patches for changes in labels and annotations
-	oldLabels := stringToNsNames(node.Annotations[m.annotationName(featureLabelAnnotation)], FeatureLabelNs)
-	patches := createPatches(oldLabels, node.Labels, labels, "/metadata/labels")
-	patches = append(patches, createPatches(nil, node.Annotations, annotations, "/metadata/annotations")...)
-
-	// Also, remove all labels with the old prefix, and the old version label
-	patches = append(patches, removeLabelsWithPrefix(node, "node.alpha.kubernetes-incubator.io/nfd")...)
-	patches = append(patches, removeLabelsWithPrefix(node, "node.alpha.kubernetes-incubator.io/node-feature-discovery")...)
-
-	// Patch the node object in the apiserver
-	err = m.apihelper.PatchNode(cli, node.Name, patches)
-	if err != nil {
-		return fmt.Errorf("error while patching node object: %v", err)
-	}
-
-	// patch node status with extended resource changes
-	patches = m.createExtendedResourcePatches(node, extendedResources)
-	err = m.apihelper.PatchNodeStatus(cli, node.Name, patches)
-	if err != nil {
-		return fmt.Errorf("error while patching extended resources: %v", err)
-	}
-
-	return err
+	return m.updater().UpdateNodeFeatures(nodeName, labels, annotations, extendedResources)
 }
 
 func (m *nfdMaster) annotationName(name string) string {
-	return path.Join(m.annotationNs, name)
+	return m.updater().AnnotationName(name)
 }
 
 func (m *nfdMaster) getKubeconfig() (*restclient.Config, error) {
@@ -612,114 +515,6 @@ func (m *nfdMaster) getKubeconfig() (*restclient.Config, error) {
 	return m.kubeconfig, err
 }
 
-// Remove any labels having the given prefix
-func removeLabelsWithPrefix(n *api.Node, search string) []apihelper.JsonPatch {
-	var p []apihelper.JsonPatch
-
-	for k := range n.Labels {
-		if strings.HasPrefix(k, search) {
-			p = append(p, apihelper.NewJsonPatch("remove", "/metadata/labels", k, ""))
-		}
-	}
-
-	return p
-}
-
-// createPatches is a generic helper that returns json patch operations to perform
-func createPatches(removeKeys []string, oldItems map[string]string, newItems map[string]string, jsonPath string) []apihelper.JsonPatch {
-	patches := []apihelper.JsonPatch{}
-
-	// Determine items to remove
-	for _, key := range removeKeys {
-		if _, ok := oldItems[key]; ok {
-			if _, ok := newItems[key]; !ok {
-				patches = append(patches, apihelper.NewJsonPatch("remove", jsonPath, key, ""))
-			}
-		}
-	}
-
-	// Determine items to add or replace
-	for key, newVal := range newItems {
-		if oldVal, ok := oldItems[key]; ok {
-			if newVal != oldVal {
-				patches = append(patches, apihelper.NewJsonPatch("replace", jsonPath, key, newVal))
-			}
-		} else {
-			patches = append(patches, apihelper.NewJsonPatch("add", jsonPath, key, newVal))
-		}
-	}
-
-	return patches
-}
-
-// createExtendedResourcePatches returns a slice of operations to perform on
-// the node status
-func (m *nfdMaster) createExtendedResourcePatches(n *api.Node, extendedResources ExtendedResources) []apihelper.JsonPatch {
-	patches := []apihelper.JsonPatch{}
-
-	// Form a list of namespaced resource names managed by us
-	oldResources := stringToNsNames(n.Annotations[m.annotationName(extendedResourceAnnotation)], FeatureLabelNs)
-
-	// figure out which resources to remove
-	for _, resource := range oldResources {
-		if _, ok := n.Status.Capacity[api.ResourceName(resource)]; ok {
-			// check if the ext resource is still needed
-			if _, extResNeeded := extendedResources[resource]; !extResNeeded {
-				patches = append(patches, apihelper.NewJsonPatch("remove", "/status/capacity", resource, ""))
-				patches = append(patches, apihelper.NewJsonPatch("remove", "/status/allocatable", resource, ""))
-			}
-		}
-	}
-
-	// figure out which resources to replace and which to add
-	for resource, value := range extendedResources {
-		// check if the extended resource already exists with the same capacity in the node
-		if quantity, ok := n.Status.Capacity[api.ResourceName(resource)]; ok {
-			val, _ := quantity.AsInt64()
-			if strconv.FormatInt(val, 10) != value {
-				patches = append(patches, apihelper.NewJsonPatch("replace", "/status/capacity", resource, value))
-				patches = append(patches, apihelper.NewJsonPatch("replace", "/status/allocatable", resource, value))
-			}
-		} else {
-			patches = append(patches, apihelper.NewJsonPatch("add", "/status/capacity", resource, value))
-			// "allocatable" gets added implicitly after adding to capacity
-		}
-	}
-
-	return patches
-}
-
-// addNs adds a namespace if one isn't already found from src string
-func addNs(src string, nsToAdd string) string {
-	if strings.Contains(src, "/") {
-		return src
-	}
-	return path.Join(nsToAdd, src)
-}
-
-// splitNs splits a name into its namespace and name parts
-func splitNs(fullname string) (string, string) {
-	split := strings.SplitN(fullname, "/", 2)
-	if len(split) == 2 {
-		return split[0], split[1]
-	}
-	return "", fullname
-}
-
-// stringToNsNames is a helper for converting a string of comma-separated names
-// into a slice of fully namespaced names
-func stringToNsNames(cslist, ns string) []string {
-	var names []string
-	if cslist != "" {
-		names = strings.Split(cslist, ",")
-		for i, name := range names {
-			// Expect that names may omit the ns part
-			names[i] = addNs(name, ns)
-		}
-	}
-	return names
-}
-
 func modifyCR(topoUpdaterZones []*v1alpha1.Zone) []v1alpha1.Zone {
 	zones := make([]v1alpha1.Zone, len(topoUpdaterZones))
 	// TODO: Avoid copying of data to allow returning the zone info
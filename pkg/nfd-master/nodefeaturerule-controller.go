@@ -20,7 +20,6 @@ import (
 	"time"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 
@@ -29,35 +28,60 @@ import (
 	nfdscheme "sigs.k8s.io/node-feature-discovery/pkg/generated/clientset/versioned/scheme"
 	nfdinformers "sigs.k8s.io/node-feature-discovery/pkg/generated/informers/externalversions"
 	nfdlisters "sigs.k8s.io/node-feature-discovery/pkg/generated/listers/nfd/v1alpha1"
+	"sigs.k8s.io/node-feature-discovery/pkg/nodeupdater"
 )
 
 type nfdController struct {
 	lister nfdlisters.NodeFeatureRuleLister
+	// rules holds the compiled rules of the NodeFeatureRule objects
+	rules *nodeupdater.RuleCache
+	// updates is notified when NodeFeatureRule objects change
+	updates chan struct{}
 
 	stopChan chan struct{}
 }
 
-func newNfdController(config *restclient.Config) *nfdController {
+func newNfdController(nfdClient nfdclientset.Interface) *nfdController {
 	c := &nfdController{
+		rules:    nodeupdater.NewRuleCache(),
+		updates:  make(chan struct{}, 1),
 		stopChan: make(chan struct{}, 1),
 	}
 
-	nfdClient := nfdclientset.NewForConfigOrDie(config)
-
 	informerFactory := nfdinformers.NewSharedInformerFactory(nfdClient, 5*time.Minute)
 	informer := informerFactory.Nfd().V1alpha1().NodeFeatureRules()
 	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(object interface{}) {
 			key, _ := cache.MetaNamespaceKeyFunc(object)
 			klog.V(2).Infof("NodeFeatureRule %v added", key)
+			if r, ok := object.(*nfdv1alpha1.NodeFeatureRule); ok {
+				c.rules.Update(r)
+			}
+			c.notify()
 		},
 		UpdateFunc: func(oldObject, newObject interface{}) {
+			r, ok := newObject.(*nfdv1alpha1.NodeFeatureRule)
+			if old, isRule := oldObject.(*nfdv1alpha1.NodeFeatureRule); ok && isRule && old.ResourceVersion == r.ResourceVersion {
+				// Periodic resync, nothing changed
+				return
+			}
 			key, _ := cache.MetaNamespaceKeyFunc(newObject)
 			klog.V(2).Infof("NodeFeatureRule %v updated", key)
+			if ok {
+				c.rules.Update(r)
+			}
+			c.notify()
 		},
 		DeleteFunc: func(object interface{}) {
-			key, _ := cache.MetaNamespaceKeyFunc(object)
+			key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(object)
 			klog.V(2).Infof("NodeFeatureRule %v deleted", key)
+			if tombstone, ok := object.(cache.DeletedFinalStateUnknown); ok {
+				object = tombstone.Obj
+			}
+			if r, ok := object.(*nfdv1alpha1.NodeFeatureRule); ok {
+				c.rules.Delete(r)
+			}
+			c.notify()
 		},
 	})
 	informerFactory.Start(c.stopChan)
@@ -69,6 +93,15 @@ func newNfdController(config *restclient.Config) *nfdController {
 	return c
 }
 
+// notify signals a change in the NodeFeatureRule objects. Multiple changes
+// are coalesced into one notification.
+func (c *nfdController) notify() {
+	select {
+	case c.updates <- struct{}{}:
+	default:
+	}
+}
+
 func (c *nfdController) stop() {
 	select {
 	case c.stopChan <- struct{}{}:
@@ -0,0 +1,195 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfdruleconvert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+	"sigs.k8s.io/node-feature-discovery/source/custom"
+)
+
+// Output formats
+const (
+	// OutputRules outputs a plain list of rules
+	OutputRules = "rules"
+	// OutputNodeFeatureRule outputs a NodeFeatureRule object
+	OutputNodeFeatureRule = "nodefeaturerule"
+)
+
+// Args holds command line arguments
+type Args struct {
+	InputFiles utils.StringSliceVal
+	Name       string
+	Output     string
+	Strict     bool
+}
+
+// Run reads custom rules from the files and directories specified in args,
+// converts legacy rules into the nfdv1alpha1.Rule format and writes the
+// result to out. Semantic differences between the legacy and the converted
+// rules are reported to notesOut. In strict mode, an error is returned if
+// there were any differences.
+func Run(args *Args, out, notesOut io.Writer) error {
+	if len(args.InputFiles) == 0 {
+		return fmt.Errorf("no input files specified")
+	}
+
+	rules := []custom.CustomRule{}
+	for _, path := range args.InputFiles {
+		r, err := LoadPath(path)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r...)
+	}
+
+	converted, notes := custom.ConvertRules(rules)
+	for _, n := range notes {
+		fmt.Fprintf(notesOut, "WARNING: %s\n", n)
+	}
+
+	var obj interface{}
+	switch args.Output {
+	case OutputRules, "":
+		obj = converted
+	case OutputNodeFeatureRule:
+		obj = nfdv1alpha1.NodeFeatureRule{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: nfdv1alpha1.SchemeGroupVersion.String(),
+				Kind:       "NodeFeatureRule",
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: args.Name},
+			Spec:       nfdv1alpha1.NodeFeatureRuleSpec{Rules: converted},
+		}
+	default:
+		return fmt.Errorf("invalid output format %q", args.Output)
+	}
+
+	data, err := marshalYAML(obj)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+
+	if args.Strict && len(notes) > 0 {
+		return fmt.Errorf("%d semantic difference(s) found in the conversion", len(notes))
+	}
+	return nil
+}
+
+// LoadPath reads custom rules from a file or a directory. Files may contain
+// nfd-worker configuration or a plain list of custom rules. Directories are
+// read like the custom.d directory of nfd-worker, i.e. including files in
+// the first level of subdirectories but skipping hidden files.
+func LoadPath(path string) ([]custom.CustomRule, error) {
+	return loadPath(path, true)
+}
+
+func loadPath(path string, recursive bool) ([]custom.CustomRule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []custom.CustomRule{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || (e.IsDir() && !recursive) {
+			continue
+		}
+		r, err := loadPath(filepath.Join(path, e.Name()), false)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r...)
+	}
+	return rules, nil
+}
+
+func loadFile(path string) ([]custom.CustomRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A plain list of custom rules
+	rules := []custom.CustomRule{}
+	if err := yaml.Unmarshal(data, &rules); err == nil {
+		return rules, nil
+	}
+
+	// nfd-worker configuration
+	config := struct {
+		Sources struct {
+			Custom []custom.CustomRule `json:"custom"`
+		} `json:"sources"`
+	}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	return config.Sources.Custom, nil
+}
+
+// marshalYAML serializes an object into YAML, leaving out empty fields.
+func marshalYAML(obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(pruneEmpty(raw))
+}
+
+// pruneEmpty removes null values and empty templates from objects.
+func pruneEmpty(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if e == nil || ((k == "labelsTemplate" || k == "varsTemplate") && e == "") {
+				delete(t, k)
+			} else {
+				t[k] = pruneEmpty(e)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = pruneEmpty(t[i])
+		}
+	}
+	return v
+}
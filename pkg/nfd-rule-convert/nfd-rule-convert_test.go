@@ -0,0 +1,120 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfdruleconvert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testWorkerConfig = `
+core:
+  labelWhiteList: ""
+sources:
+  custom:
+  - name: "kmod"
+    matchOn:
+    - loadedKMod: ["kmod1"]
+`
+
+const testCustomRules = `
+- name: "kconfig"
+  matchOn:
+  - kConfig: ["PREEMPT=y"]
+`
+
+func writeFile(dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	So(os.WriteFile(path, []byte(data), 0644), ShouldBeNil)
+	return path
+}
+
+func TestLoadPath(t *testing.T) {
+	Convey("When loading custom rules", t, func() {
+		dir := t.TempDir()
+		writeFile(dir, "worker.conf", testWorkerConfig)
+		So(os.Mkdir(filepath.Join(dir, "sub"), 0755), ShouldBeNil)
+		writeFile(dir, "sub/rules.yaml", testCustomRules)
+		writeFile(dir, ".hidden", testCustomRules)
+
+		Convey("Rules should be read from worker config and plain rule files", func() {
+			rules, err := LoadPath(dir)
+			So(err, ShouldBeNil)
+			So(len(rules), ShouldEqual, 2)
+			So(rules[0].LegacyRule.Name, ShouldEqual, "kconfig")
+			So(rules[1].LegacyRule.Name, ShouldEqual, "kmod")
+		})
+
+		Convey("Non-existent path should result in an error", func() {
+			_, err := LoadPath(filepath.Join(dir, "non-existent"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRun(t *testing.T) {
+	Convey("When running nfd-rule-convert", t, func() {
+		dir := t.TempDir()
+		args := &Args{
+			InputFiles: []string{writeFile(dir, "worker.conf", testWorkerConfig)},
+			Name:       "converted",
+		}
+		out := &bytes.Buffer{}
+		notesOut := &bytes.Buffer{}
+
+		Convey("Converted rules should be printed as a list", func() {
+			So(Run(args, out, notesOut), ShouldBeNil)
+			So(out.String(), ShouldEqual, `- labels:
+    custom-kmod: "true"
+  matchFeatures:
+  - feature: kernel.loadedmodule
+    matchExpressions:
+      kmod1:
+        op: Exists
+  name: kmod
+`)
+			So(notesOut.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Converted rules should be printed as a NodeFeatureRule", func() {
+			args.Output = OutputNodeFeatureRule
+			So(Run(args, out, notesOut), ShouldBeNil)
+			So(out.String(), ShouldStartWith, "apiVersion: nfd.k8s-sigs.io/v1alpha1\nkind: NodeFeatureRule\nmetadata:\n")
+			So(out.String(), ShouldContainSubstring, "  name: converted\n")
+		})
+
+		Convey("Semantic differences should be reported", func() {
+			args.InputFiles = append(args.InputFiles, writeFile(dir, "rules.yaml", testCustomRules))
+			So(Run(args, out, notesOut), ShouldBeNil)
+			So(notesOut.String(), ShouldStartWith, `WARNING: rule "kconfig": matchOn[0]: kConfig "PREEMPT"`)
+
+			Convey("and result in an error in strict mode", func() {
+				args.Strict = true
+				So(Run(args, out, notesOut), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Invalid output format should result in an error", func() {
+			args.Output = "xml"
+			So(Run(args, out, notesOut), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfdruleeval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+	"sigs.k8s.io/node-feature-discovery/source/custom"
+)
+
+// Args holds command line arguments
+type Args struct {
+	FeaturesFile string
+	Output       string
+	RuleFiles    utils.StringSliceVal
+	Trace        bool
+	FailOnError  bool
+}
+
+// Output is the result of evaluating a set of rules.
+type Output struct {
+	Labels map[string]string `json:"labels"`
+	Vars   map[string]string `json:"vars"`
+}
+
+// RuleSet is a set of rules to evaluate.
+type RuleSet struct {
+	// NodeFeatureRules are evaluated like nfd-master does, i.e. ordered by
+	// the name of the NodeFeatureRule object.
+	NodeFeatureRules []nfdv1alpha1.NodeFeatureRule
+	// CustomRules are evaluated like the custom feature source of nfd-worker
+	// does, i.e. in the order they were specified.
+	CustomRules []nfdv1alpha1.Rule
+}
+
+// Run loads the rules and features specified in args, evaluates the rules and
+// writes the output to out. If tracing is enabled, evaluation traces are
+// written to traceOut.
+func Run(args *Args, out, traceOut io.Writer) error {
+	if len(args.RuleFiles) == 0 {
+		return fmt.Errorf("no rule files specified")
+	}
+	if args.FeaturesFile == "" {
+		return fmt.Errorf("no features file specified")
+	}
+
+	rules := &RuleSet{}
+	for _, path := range args.RuleFiles {
+		if err := rules.LoadFile(path); err != nil {
+			return err
+		}
+	}
+
+	features, err := LoadFeatures(args.FeaturesFile)
+	if err != nil {
+		return err
+	}
+
+	if !args.Trace {
+		traceOut = nil
+	}
+	output, err := rules.Evaluate(features, traceOut, args.FailOnError)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch args.Output {
+	case "yaml", "":
+		data, err = yaml.Marshal(output)
+	case "json":
+		data, err = json.MarshalIndent(output, "", "  ")
+		data = append(data, '\n')
+	default:
+		return fmt.Errorf("invalid output format %q", args.Output)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// LoadFile reads rules from a file. The file may contain (possibly multiple
+// YAML documents of) NodeFeatureRule objects, NodeFeatureRuleList objects,
+// nfd-worker configuration with custom rules or a plain list of custom rules,
+// i.e. the format of the custom.d configuration directory.
+func (rs *RuleSet) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read rule file: %w", err)
+	}
+	if err := rs.Load(data); err != nil {
+		return fmt.Errorf("failed to parse rule file %q: %w", path, err)
+	}
+	return nil
+}
+
+// Load parses rules from YAML or JSON data. See LoadFile for the supported
+// formats.
+func (rs *RuleSet) Load(data []byte) error {
+	decoder := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			continue
+		}
+
+		if raw[0] == '[' {
+			// A list of custom rules
+			if err := rs.addCustomRules(raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var obj struct {
+			Kind    string `json:"kind"`
+			Sources *struct {
+				Custom json.RawMessage `json:"custom"`
+			} `json:"sources"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return err
+		}
+
+		switch {
+		case obj.Kind == "NodeFeatureRule":
+			nfr := nfdv1alpha1.NodeFeatureRule{}
+			if err := json.Unmarshal(raw, &nfr); err != nil {
+				return err
+			}
+			rs.NodeFeatureRules = append(rs.NodeFeatureRules, nfr)
+		case obj.Kind == "NodeFeatureRuleList":
+			list := nfdv1alpha1.NodeFeatureRuleList{}
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return err
+			}
+			rs.NodeFeatureRules = append(rs.NodeFeatureRules, list.Items...)
+		case obj.Sources != nil:
+			// nfd-worker configuration
+			if len(obj.Sources.Custom) > 0 {
+				if err := rs.addCustomRules(obj.Sources.Custom); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unrecognized data: must be a NodeFeatureRule, NodeFeatureRuleList, nfd-worker configuration or a list of custom rules")
+		}
+	}
+	return nil
+}
+
+func (rs *RuleSet) addCustomRules(data []byte) error {
+	rules := []custom.CustomRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		switch {
+		case r.LegacyRule != nil:
+			// Legacy rules directly probe the host and cannot be evaluated
+			// against a set of features
+			klog.Warningf("skipping legacy custom rule %q", r.LegacyRule.Name)
+		case r.Rule != nil:
+			rs.CustomRules = append(rs.CustomRules, r.Rule.Rule)
+		}
+	}
+	return nil
+}
+
+// LoadFeatures reads a set of features from a JSON or YAML file. The file may
+// contain a plain set of features or features exported by nfd-worker.
+func LoadFeatures(path string) (feature.Features, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read features file: %w", err)
+	}
+
+	features, err := parseFeatures(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse features file %q: %w", path, err)
+	}
+	return features, nil
+}
+
+func parseFeatures(data []byte) (feature.Features, error) {
+	var obj struct {
+		Schema string `json:"schema"`
+	}
+	if err := yaml.Unmarshal(data, &obj); err == nil && obj.Schema != "" {
+		if obj.Schema != feature.ExportSchema {
+			return nil, fmt.Errorf("unsupported schema %q of exported features, expected %q", obj.Schema, feature.ExportSchema)
+		}
+		e := feature.NodeFeatureExport{}
+		if err := yaml.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		return e.GetFeatures(), nil
+	}
+
+	features := feature.Features{}
+	if err := yaml.Unmarshal(data, &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// Evaluate executes all rules of the set against features. Custom rules and
+// NodeFeatureRules are evaluated separately, both of them seeing only the
+// output of preceding rules of the same kind, like they would in nfd-worker
+// and nfd-master, respectively. Rule dependencies are resolved within each
+// kind, too. The output of all rules is combined. If traceOut is not nil, an
+// evaluation trace of each rule is written to it.
+//
+// Like in nfd-worker and nfd-master, rules that fail to evaluate (or whose
+// dependencies cannot be resolved) are reported and skipped. If failOnError is
+// true, evaluation is instead aborted and an error returned on the first
+// failure.
+func (rs *RuleSet) Evaluate(features feature.Features, traceOut io.Writer, failOnError bool) (*Output, error) {
+	out := &Output{
+		Labels: make(map[string]string),
+		Vars:   make(map[string]string),
+	}
+
+	if len(rs.CustomRules) > 0 {
+		rules := make([]*nfdv1alpha1.Rule, len(rs.CustomRules))
+		for i := range rs.CustomRules {
+			rules[i] = &rs.CustomRules[i]
+		}
+		if err := executeRules(rules, nil, copyFeatures(features), out, traceOut, failOnError); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rs.NodeFeatureRules) > 0 {
+		nfrs := make([]*nfdv1alpha1.NodeFeatureRule, len(rs.NodeFeatureRules))
+		for i := range rs.NodeFeatureRules {
+			nfrs[i] = &rs.NodeFeatureRules[i]
+		}
+		sort.SliceStable(nfrs, func(i, j int) bool { return nfrs[i].Name < nfrs[j].Name })
+
+		rules := []*nfdv1alpha1.Rule{}
+		owners := []*nfdv1alpha1.NodeFeatureRule{}
+		for _, nfr := range nfrs {
+			for i := range nfr.Spec.Rules {
+				rules = append(rules, &nfr.Spec.Rules[i])
+				owners = append(owners, nfr)
+			}
+		}
+		if err := executeRules(rules, owners, features, out, traceOut, failOnError); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// executeRules evaluates a list of rules. If owners is nil, the rules are
+// custom rules that see the output of all preceding rules. Otherwise, owners
+// specifies the NodeFeatureRule object of each rule, which determines the
+// scope of their vars. Errors are reported and the failing rules skipped,
+// unless failOnError is true in which case the first error is returned.
+func executeRules(rules []*nfdv1alpha1.Rule, owners []*nfdv1alpha1.NodeFeatureRule, features feature.Features, out *Output, traceOut io.Writer, failOnError bool) error {
+	order, err := nfdv1alpha1.OrderRules(rules)
+	if err != nil {
+		if failOnError {
+			return fmt.Errorf("failed to resolve rule dependencies: %w", err)
+		}
+		klog.Errorf("failed to resolve rule dependencies, skipping affected rules: %v", err)
+	}
+
+	backrefs := nfdv1alpha1.NewBackrefScopes()
+	evalCtx := nfdv1alpha1.NewEvalContext()
+	for _, i := range order {
+		rule := rules[i]
+
+		ruleFeatures := features
+		if owners != nil {
+			ruleFeatures = backrefs.Features(features, owners[i].Name)
+		}
+
+		var ruleOut nfdv1alpha1.RuleOutput
+		var err error
+		if traceOut != nil {
+			var trace *nfdv1alpha1.RuleTrace
+			ruleOut, trace, err = evalCtx.ExecuteWithTrace(rule, ruleFeatures)
+			fmt.Fprint(traceOut, trace)
+		} else {
+			ruleOut, err = evalCtx.Execute(rule, ruleFeatures)
+		}
+		if err != nil {
+			if failOnError {
+				return fmt.Errorf("failed to process rule %q: %w", rule.Name, err)
+			}
+			klog.Errorf("failed to process rule %q: %v", rule.Name, err)
+			continue
+		}
+
+		for k, v := range ruleOut.Labels {
+			out.Labels[k] = v
+		}
+		for k, v := range ruleOut.Vars {
+			out.Vars[k] = v
+		}
+
+		// Feed back rule output to features map for subsequent rules to match
+		if owners != nil {
+			if err := backrefs.Add(owners[i].Name, ruleOut, owners[i].Spec.ExportVars); err != nil {
+				klog.Warning(err)
+			}
+		} else {
+			feature.InsertFeatureValues(features, nfdv1alpha1.RuleBackrefDomain, nfdv1alpha1.RuleBackrefFeature, ruleOut.Labels)
+			feature.InsertFeatureValues(features, nfdv1alpha1.RuleBackrefDomain, nfdv1alpha1.RuleBackrefFeature, ruleOut.Vars)
+		}
+	}
+	return nil
+}
+
+// copyFeatures makes a copy of features that is deep enough for inserting
+// rule backreferences without affecting the original.
+func copyFeatures(features feature.Features) feature.Features {
+	ret := make(feature.Features, len(features))
+	for domain, f := range features {
+		if f == nil {
+			continue
+		}
+		df := feature.NewDomainFeatures()
+		for k, v := range f.Keys {
+			df.Keys[k] = v
+		}
+		for k, v := range f.Values {
+			elems := make(map[string]string, len(v.Elements))
+			for ek, ev := range v.Elements {
+				elems[ek] = ev
+			}
+			df.Values[k] = feature.NewValueFeatures(elems)
+		}
+		for k, v := range f.Instances {
+			df.Instances[k] = v
+		}
+		ret[domain] = df
+	}
+	return ret
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nfdruleeval
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+)
+
+const testFeatures = `
+cpu:
+  keys:
+    cpuid:
+      elements:
+        AVX: {}
+        AVX2: {}
+kernel:
+  values:
+    version:
+      elements:
+        major: "5"
+        minor: "15"
+pci:
+  instances:
+    device:
+      elements:
+      - attributes:
+          vendor: "8086"
+          class: "0200"
+`
+
+const testNodeFeatureRules = `
+apiVersion: nfd.k8s-sigs.io/v1alpha1
+kind: NodeFeatureRule
+metadata:
+  name: b-rules
+spec:
+  rules:
+  - name: "backref rule"
+    labels:
+      backref: "true"
+    matchFeatures:
+    - feature: rule.matched
+      matchExpressions:
+        avx: {op: Exists}
+        kernel-major: {op: Gt, value: ["4"]}
+---
+apiVersion: nfd.k8s-sigs.io/v1alpha1
+kind: NodeFeatureRule
+metadata:
+  name: a-rules
+spec:
+  exportVars: ["kernel-major"]
+  rules:
+  - name: "cpu rule"
+    labels:
+      avx: "true"
+    varsTemplate: |
+      {{ range .kernel.version }}kernel-{{ .Name }}={{ .Value }}
+      {{ end }}
+    matchFeatures:
+    - feature: cpu.cpuid
+      matchExpressions:
+        AVX: {op: Exists}
+    - feature: kernel.version
+      matchExpressions:
+        major: {op: Exists}
+  - name: "pci rule"
+    labelsTemplate: |
+      {{ range .pci.device }}pci-{{ .class }}.present=true
+      {{ end }}
+    matchFeatures:
+    - feature: pci.device
+      matchExpressions:
+        vendor: {op: In, value: ["8086"]}
+`
+
+const testWorkerConfig = `
+core:
+  sleepInterval: 60s
+sources:
+  custom:
+  - name: "custom rule"
+    labels:
+      custom-avx2: "true"
+    matchFeatures:
+    - feature: cpu.cpuid
+      matchExpressions:
+        AVX2: {op: Exists}
+  - name: "custom backref rule"
+    labels:
+      custom-backref: "true"
+    matchFeatures:
+    - feature: rule.matched
+      matchExpressions:
+        custom-avx2: {op: Exists}
+  - name: "legacy rule"
+    value: "true"
+    matchOn:
+    - loadedKMod: ["foo"]
+`
+
+const testExport = `
+schema: nfd-worker-export/v1alpha1
+nfdVersion: v0.11.0
+timestamp: "2022-06-01T12:00:00Z"
+features:
+  cpu:
+    keys:
+      cpuid: [AVX]
+    values:
+      model:
+        vendor_id: Intel
+  pci:
+    instances:
+      device:
+      - vendor: "8086"
+        class: "0200"
+labels:
+  cpu-cpuid.AVX: "true"
+`
+
+func writeFile(dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	So(os.WriteFile(path, []byte(data), 0644), ShouldBeNil)
+	return path
+}
+
+func TestEvaluate(t *testing.T) {
+	Convey("When evaluating rules", t, func() {
+		dir := t.TempDir()
+		featuresFile := writeFile(dir, "features.yaml", testFeatures)
+
+		Convey("Features exported by nfd-worker should be loaded", func() {
+			features, err := LoadFeatures(writeFile(dir, "export.yaml", testExport))
+			So(err, ShouldBeNil)
+			So(features, ShouldContainKey, "cpu")
+			So(features["cpu"].Keys["cpuid"].Elements, ShouldContainKey, "AVX")
+			So(features["cpu"].Values["model"].Elements, ShouldResemble, map[string]string{"vendor_id": "Intel"})
+			So(features["pci"].Instances["device"].Elements, ShouldResemble, []feature.InstanceFeature{
+				*feature.NewInstanceFeature(map[string]string{"vendor": "8086", "class": "0200"}),
+			})
+
+			_, err = LoadFeatures(writeFile(dir, "export-v2.yaml", strings.Replace(testExport, "v1alpha1", "v2", 1)))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("NodeFeatureRules should be evaluated in the order of their name", func() {
+			rules := &RuleSet{}
+			So(rules.Load([]byte(testNodeFeatureRules)), ShouldBeNil)
+			So(len(rules.NodeFeatureRules), ShouldEqual, 2)
+
+			features, err := LoadFeatures(featuresFile)
+			So(err, ShouldBeNil)
+
+			out, err := rules.Evaluate(features, nil, false)
+			So(err, ShouldBeNil)
+			So(out.Labels, ShouldResemble, map[string]string{
+				"avx":              "true",
+				"pci-0200.present": "true",
+				"backref":          "true",
+			})
+			So(out.Vars, ShouldResemble, map[string]string{"kernel-major": "5"})
+
+			Convey("And vars that are not exported should not be visible to other NodeFeatureRules", func() {
+				rules.NodeFeatureRules[1].Spec.ExportVars = nil
+				out, err := rules.Evaluate(features, nil, false)
+				So(err, ShouldBeNil)
+				So(out.Labels, ShouldNotContainKey, "backref")
+			})
+
+			Convey("And the input features should not be modified", func() {
+				_, ok := features["rule"]
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("Custom rules of nfd-worker configuration should be evaluated", func() {
+			rules := &RuleSet{}
+			So(rules.Load([]byte(testWorkerConfig)), ShouldBeNil)
+			// Legacy rule should be skipped
+			So(len(rules.CustomRules), ShouldEqual, 2)
+
+			features, err := LoadFeatures(featuresFile)
+			So(err, ShouldBeNil)
+
+			out, err := rules.Evaluate(features, nil, false)
+			So(err, ShouldBeNil)
+			So(out.Labels, ShouldResemble, map[string]string{
+				"custom-avx2":    "true",
+				"custom-backref": "true",
+			})
+		})
+
+		Convey("Custom rules and NodeFeatureRules should not see each other's output", func() {
+			rules := &RuleSet{}
+			So(rules.Load([]byte(`
+- name: "custom rule"
+  labels:
+    avx: "true"
+  matchFeatures:
+  - feature: cpu.cpuid
+    matchExpressions:
+      AVX: {op: Exists}
+`)), ShouldBeNil)
+			So(rules.Load([]byte(`
+apiVersion: nfd.k8s-sigs.io/v1alpha1
+kind: NodeFeatureRule
+metadata:
+  name: rules
+spec:
+  rules:
+  - name: "kernel rule"
+    labels:
+      kernel: "true"
+    matchFeatures:
+    - feature: kernel.version
+      matchExpressions:
+        major: {op: Exists}
+  - name: "backref rule"
+    labels:
+      backref: "true"
+    matchFeatures:
+    - feature: rule.matched
+      matchExpressions:
+        avx: {op: Exists}
+`)), ShouldBeNil)
+
+			features, err := LoadFeatures(featuresFile)
+			So(err, ShouldBeNil)
+
+			out, err := rules.Evaluate(features, nil, false)
+			So(err, ShouldBeNil)
+			So(out.Labels, ShouldResemble, map[string]string{"avx": "true", "kernel": "true"})
+		})
+
+		Convey("Failing rules should be skipped unless aborting on errors was requested", func() {
+			rules := &RuleSet{}
+			So(rules.Load([]byte(`
+- name: "failing rule"
+  labels:
+    failing: "true"
+  matchFeatures:
+  - feature: nonexistent.feature
+    matchExpressions:
+      foo: {op: Exists}
+- name: "missing dependency"
+  labels:
+    missing: "true"
+  dependsOn: ["nonexistent rule"]
+- name: "avx rule"
+  labels:
+    avx: "true"
+  matchFeatures:
+  - feature: cpu.cpuid
+    matchExpressions:
+      AVX: {op: Exists}
+`)), ShouldBeNil)
+
+			features, err := LoadFeatures(featuresFile)
+			So(err, ShouldBeNil)
+
+			out, err := rules.Evaluate(features, nil, false)
+			So(err, ShouldBeNil)
+			So(out.Labels, ShouldResemble, map[string]string{"avx": "true"})
+
+			_, err = rules.Evaluate(features, nil, true)
+			So(err, ShouldNotBeNil)
+
+			rules.CustomRules = rules.CustomRules[:1]
+			_, err = rules.Evaluate(features, nil, true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `failed to process rule "failing rule"`)
+		})
+
+		Convey("Unrecognized data should be rejected", func() {
+			rules := &RuleSet{}
+			So(rules.Load([]byte("foo: bar")), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRun(t *testing.T) {
+	Convey("When running nfd-rule-eval", t, func() {
+		dir := t.TempDir()
+		args := &Args{
+			FeaturesFile: writeFile(dir, "features.yaml", testFeatures),
+			RuleFiles:    []string{writeFile(dir, "rules.yaml", testNodeFeatureRules)},
+		}
+		out := &bytes.Buffer{}
+		traceOut := &bytes.Buffer{}
+
+		Convey("Labels and vars should be printed as YAML", func() {
+			So(Run(args, out, traceOut), ShouldBeNil)
+			So(out.String(), ShouldEqual, `labels:
+  avx: "true"
+  backref: "true"
+  pci-0200.present: "true"
+vars:
+  kernel-major: "5"
+`)
+			So(traceOut.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Labels and vars should be printed as JSON", func() {
+			args.Output = "json"
+			So(Run(args, out, traceOut), ShouldBeNil)
+			So(out.String(), ShouldStartWith, "{\n  \"labels\": {")
+		})
+
+		Convey("Evaluation traces should be printed when requested", func() {
+			args.Trace = true
+			So(Run(args, out, traceOut), ShouldBeNil)
+			So(traceOut.String(), ShouldContainSubstring, `rule "cpu rule": matched`)
+			So(traceOut.String(), ShouldContainSubstring, `rule "backref rule": matched`)
+		})
+
+		Convey("Invalid output format should result in an error", func() {
+			args.Output = "xml"
+			So(Run(args, out, traceOut), ShouldNotBeNil)
+		})
+
+		Convey("Missing features file should result in an error", func() {
+			args.FeaturesFile = filepath.Join(dir, "non-existent.yaml")
+			So(Run(args, out, traceOut), ShouldNotBeNil)
+		})
+	})
+}
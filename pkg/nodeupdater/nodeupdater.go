@@ -0,0 +1,445 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeupdater implements updating of node objects with feature labels,
+// annotations and extended resources. It is shared by nfd-master and the
+// standalone mode of nfd-worker.
+package nodeupdater
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+)
+
+const (
+	// FeatureLabelNs is the namespace for feature labels
+	FeatureLabelNs = "feature.node.kubernetes.io"
+
+	// FeatureLabelSubNsSuffix is the suffix for allowed feature label sub-namespaces
+	FeatureLabelSubNsSuffix = "." + FeatureLabelNs
+
+	// ProfileLabelNs is the namespace for profile labels
+	ProfileLabelNs = "profile.node.kubernetes.io"
+
+	// ProfileLabelSubNsSuffix is the suffix for allowed profile label sub-namespaces
+	ProfileLabelSubNsSuffix = "." + ProfileLabelNs
+
+	// AnnotationNsBase namespace for all NFD-related annotations
+	AnnotationNsBase = "nfd.node.kubernetes.io"
+
+	// ExtendedResourceAnnotation is the name of the annotation listing the
+	// extended resources managed by NFD
+	ExtendedResourceAnnotation = "extended-resources"
+
+	// FeatureLabelAnnotation is the name of the annotation listing the
+	// feature labels managed by NFD
+	FeatureLabelAnnotation = "feature-labels"
+
+	// MasterVersionAnnotation is the name of the annotation holding the
+	// version of nfd-master
+	MasterVersionAnnotation = "master.version"
+
+	// WorkerVersionAnnotation is the name of the annotation holding the
+	// version of nfd-worker
+	WorkerVersionAnnotation = "worker.version"
+)
+
+// Labels are a Kubernetes representation of discovered features.
+type Labels map[string]string
+
+// ExtendedResources are k8s extended resources which are created from discovered features.
+type ExtendedResources map[string]string
+
+// Annotations are used for NFD-related node metadata
+type Annotations map[string]string
+
+// Updater updates node objects via the Kubernetes API.
+type Updater struct {
+	// APIHelper is used for accessing the Kubernetes API
+	APIHelper apihelper.APIHelpers
+	// AnnotationNs is the namespace of NFD annotations, specific to the
+	// NFD instance
+	AnnotationNs string
+}
+
+// InstanceAnnotationNs returns the namespace of the NFD annotations of an NFD
+// instance. An empty instance name stands for the default instance.
+func InstanceAnnotationNs(instance string) (string, error) {
+	if instance == "" {
+		return AnnotationNsBase, nil
+	}
+	if ok, _ := regexp.MatchString(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`, instance); !ok {
+		return "", fmt.Errorf("invalid -instance %q: instance name "+
+			"must start and end with an alphanumeric character and may only contain "+
+			"alphanumerics, `-`, `_` or `.`", instance)
+	}
+	return instance + "." + AnnotationNsBase, nil
+}
+
+// AnnotationName returns the fully namespaced name of an NFD annotation.
+func (u *Updater) AnnotationName(name string) string {
+	return path.Join(u.AnnotationNs, name)
+}
+
+// GetNode is a helper for fetching a node object from the API server.
+func (u *Updater) GetNode(nodeName string) (*api.Node, error) {
+	cli, err := u.APIHelper.GetClient()
+	if err != nil {
+		return nil, err
+	}
+	return u.APIHelper.GetNode(cli, nodeName)
+}
+
+// UpdateNodeFeatures ensures the Kubernetes node object is up to date,
+// creating new labels and extended resources where necessary and removing
+// outdated ones. Also updates the corresponding annotations.
+func (u *Updater) UpdateNodeFeatures(nodeName string, labels Labels, annotations Annotations, extendedResources ExtendedResources) error {
+	cli, err := u.APIHelper.GetClient()
+	if err != nil {
+		return err
+	}
+
+	// Get the worker node object
+	node, err := u.APIHelper.GetNode(cli, nodeName)
+	if err != nil {
+		return err
+	}
+
+	// Store names of labels in an annotation
+	labelKeys := make([]string, 0, len(labels))
+	for key := range labels {
+		// Drop the ns part for labels in the default ns
+		labelKeys = append(labelKeys, strings.TrimPrefix(key, FeatureLabelNs+"/"))
+	}
+	sort.Strings(labelKeys)
+	annotations[u.AnnotationName(FeatureLabelAnnotation)] = strings.Join(labelKeys, ",")
+
+	// Store names of extended resources in an annotation
+	extendedResourceKeys := make([]string, 0, len(extendedResources))
+	for key := range extendedResources {
+		// Drop the ns part if in the default ns
+		extendedResourceKeys = append(extendedResourceKeys, strings.TrimPrefix(key, FeatureLabelNs+"/"))
+	}
+	sort.Strings(extendedResourceKeys)
+	annotations[u.AnnotationName(ExtendedResourceAnnotation)] = strings.Join(extendedResourceKeys, ",")
+
+	// Create JSON patches for changes in labels and annotations
+	oldLabels := stringToNsNames(node.Annotations[u.AnnotationName(FeatureLabelAnnotation)], FeatureLabelNs)
+	patches := createPatches(oldLabels, node.Labels, labels, "/metadata/labels")
+	patches = append(patches, createPatches(nil, node.Annotations, annotations, "/metadata/annotations")...)
+
+	// Also, remove all labels with the old prefix, and the old version label
+	patches = append(patches, removeLabelsWithPrefix(node, "node.alpha.kubernetes-incubator.io/nfd")...)
+	patches = append(patches, removeLabelsWithPrefix(node, "node.alpha.kubernetes-incubator.io/node-feature-discovery")...)
+
+	// Patch the node object in the apiserver
+	err = u.APIHelper.PatchNode(cli, node.Name, patches)
+	if err != nil {
+		return fmt.Errorf("error while patching node object: %v", err)
+	}
+
+	// patch node status with extended resource changes
+	patches = u.createExtendedResourcePatches(node, extendedResources)
+	err = u.APIHelper.PatchNodeStatus(cli, node.Name, patches)
+	if err != nil {
+		return fmt.Errorf("error while patching extended resources: %v", err)
+	}
+
+	return err
+}
+
+// UpdateNodeAnnotations adds or updates annotations of a node, leaving other
+// annotations intact.
+func (u *Updater) UpdateNodeAnnotations(nodeName string, annotations Annotations) error {
+	cli, err := u.APIHelper.GetClient()
+	if err != nil {
+		return err
+	}
+	node, err := u.APIHelper.GetNode(cli, nodeName)
+	if err != nil {
+		return err
+	}
+
+	p := createPatches(nil, node.Annotations, annotations, "/metadata/annotations")
+	err = u.APIHelper.PatchNode(cli, node.Name, p)
+	if err != nil {
+		return fmt.Errorf("failed to patch node annotations: %v", err)
+	}
+
+	return nil
+}
+
+// PruneNode erases all NFD related labels, extended resources and annotations
+// from a node.
+func (u *Updater) PruneNode(nodeName string) error {
+	// Prune labels and extended resources
+	err := u.UpdateNodeFeatures(nodeName, Labels{}, Annotations{}, ExtendedResources{})
+	if err != nil {
+		return fmt.Errorf("failed to prune labels from node %q: %v", nodeName, err)
+	}
+
+	// Prune annotations
+	cli, err := u.APIHelper.GetClient()
+	if err != nil {
+		return err
+	}
+	node, err := u.APIHelper.GetNode(cli, nodeName)
+	if err != nil {
+		return err
+	}
+	for a := range node.Annotations {
+		if strings.HasPrefix(a, u.AnnotationNs) {
+			delete(node.Annotations, a)
+		}
+	}
+	err = u.APIHelper.UpdateNode(cli, node)
+	if err != nil {
+		return fmt.Errorf("failed to prune annotations from node %q: %v", node.Name, err)
+	}
+	return nil
+}
+
+// NodeFeatures creates the special "node" feature domain from the metadata
+// and status of a node object. Labels and annotations owned by NFD are left
+// out so that rules cannot match against their own output. NFD owns all
+// labels in the feature and profile namespaces (and their sub-namespaces),
+// whoever created them, plus the labels in extra namespaces that are listed
+// in the feature-labels annotation.
+func (u *Updater) NodeFeatures(node *api.Node) *feature.DomainFeatures {
+	features := feature.NewDomainFeatures()
+
+	nfdLabels := make(map[string]struct{})
+	for _, l := range stringToNsNames(node.Annotations[u.AnnotationName(FeatureLabelAnnotation)], FeatureLabelNs) {
+		nfdLabels[l] = struct{}{}
+	}
+	nodeLabels := make(map[string]string, len(node.Labels))
+	for k, v := range node.Labels {
+		if ns, _ := splitNs(k); isNfdNs(ns) {
+			continue
+		}
+		if _, ok := nfdLabels[k]; !ok {
+			nodeLabels[k] = v
+		}
+	}
+	features.Values[nfdv1alpha1.NodeLabelFeature] = feature.NewValueFeatures(nodeLabels)
+
+	nodeAnnotations := make(map[string]string, len(node.Annotations))
+	for k, v := range node.Annotations {
+		if !strings.HasPrefix(k, u.AnnotationNs+"/") {
+			nodeAnnotations[k] = v
+		}
+	}
+	features.Values[nfdv1alpha1.NodeAnnotationFeature] = feature.NewValueFeatures(nodeAnnotations)
+
+	info := node.Status.NodeInfo
+	features.Values[nfdv1alpha1.NodeInfoFeature] = feature.NewValueFeatures(map[string]string{
+		"architecture":            info.Architecture,
+		"containerRuntimeVersion": info.ContainerRuntimeVersion,
+		"kernelVersion":           info.KernelVersion,
+		"kubeProxyVersion":        info.KubeProxyVersion,
+		"kubeletVersion":          info.KubeletVersion,
+		"operatingSystem":         info.OperatingSystem,
+		"osImage":                 info.OSImage,
+	})
+
+	features.Values[nfdv1alpha1.NodeCapacityFeature] = feature.NewValueFeatures(resourceListToValues(node.Status.Capacity))
+	features.Values[nfdv1alpha1.NodeAllocatableFeature] = feature.NewValueFeatures(resourceListToValues(node.Status.Allocatable))
+
+	return features
+}
+
+// isNfdNs returns true if a label namespace is one of the namespaces
+// reserved for NFD.
+func isNfdNs(ns string) bool {
+	return ns == FeatureLabelNs || ns == ProfileLabelNs ||
+		strings.HasSuffix(ns, FeatureLabelSubNsSuffix) || strings.HasSuffix(ns, ProfileLabelSubNsSuffix)
+}
+
+// resourceListToValues converts a list of resources into string values
+func resourceListToValues(resources api.ResourceList) map[string]string {
+	values := make(map[string]string, len(resources))
+	for name, quantity := range resources {
+		values[string(name)] = quantity.String()
+	}
+	return values
+}
+
+// FilterFeatureLabels filters labels by namespace and name whitelist, and,
+// turns selected labels into extended resources. This function also handles
+// proper namespacing of labels and ERs, i.e. adds the possibly missing default
+// namespace for labels arriving through the gRPC API.
+func FilterFeatureLabels(labels Labels, extraLabelNs map[string]struct{}, labelWhiteList regexp.Regexp, extendedResourceNames map[string]struct{}) (Labels, ExtendedResources) {
+	outLabels := Labels{}
+
+	for label, value := range labels {
+		// Add possibly missing default ns
+		label := addNs(label, FeatureLabelNs)
+
+		ns, name := splitNs(label)
+
+		// Check label namespace, filter out if ns is not whitelisted
+		if !isNfdNs(ns) {
+			if _, ok := extraLabelNs[ns]; !ok {
+				klog.Errorf("Namespace %q is not allowed. Ignoring label %q\n", ns, label)
+				continue
+			}
+		}
+
+		// Skip if label doesn't match labelWhiteList
+		if !labelWhiteList.MatchString(name) {
+			klog.Errorf("%s (%s) does not match the whitelist (%s) and will not be published.", name, label, labelWhiteList.String())
+			continue
+		}
+		outLabels[label] = value
+	}
+
+	// Remove labels which are intended to be extended resources
+	extendedResources := ExtendedResources{}
+	for extendedResourceName := range extendedResourceNames {
+		// Add possibly missing default ns
+		extendedResourceName = addNs(extendedResourceName, FeatureLabelNs)
+		if value, ok := outLabels[extendedResourceName]; ok {
+			if _, err := strconv.Atoi(value); err != nil {
+				klog.Errorf("bad label value (%s: %s) encountered for extended resource: %s", extendedResourceName, value, err.Error())
+				continue // non-numeric label can't be used
+			}
+
+			extendedResources[extendedResourceName] = value
+			delete(outLabels, extendedResourceName)
+		}
+	}
+
+	return outLabels, extendedResources
+}
+
+// Remove any labels having the given prefix
+func removeLabelsWithPrefix(n *api.Node, search string) []apihelper.JsonPatch {
+	var p []apihelper.JsonPatch
+
+	for k := range n.Labels {
+		if strings.HasPrefix(k, search) {
+			p = append(p, apihelper.NewJsonPatch("remove", "/metadata/labels", k, ""))
+		}
+	}
+
+	return p
+}
+
+// createPatches is a generic helper that returns json patch operations to perform
+func createPatches(removeKeys []string, oldItems map[string]string, newItems map[string]string, jsonPath string) []apihelper.JsonPatch {
+	patches := []apihelper.JsonPatch{}
+
+	// Determine items to remove
+	for _, key := range removeKeys {
+		if _, ok := oldItems[key]; ok {
+			if _, ok := newItems[key]; !ok {
+				patches = append(patches, apihelper.NewJsonPatch("remove", jsonPath, key, ""))
+			}
+		}
+	}
+
+	// Determine items to add or replace
+	for key, newVal := range newItems {
+		if oldVal, ok := oldItems[key]; ok {
+			if newVal != oldVal {
+				patches = append(patches, apihelper.NewJsonPatch("replace", jsonPath, key, newVal))
+			}
+		} else {
+			patches = append(patches, apihelper.NewJsonPatch("add", jsonPath, key, newVal))
+		}
+	}
+
+	return patches
+}
+
+// createExtendedResourcePatches returns a slice of operations to perform on
+// the node status
+func (u *Updater) createExtendedResourcePatches(n *api.Node, extendedResources ExtendedResources) []apihelper.JsonPatch {
+	patches := []apihelper.JsonPatch{}
+
+	// Form a list of namespaced resource names managed by us
+	oldResources := stringToNsNames(n.Annotations[u.AnnotationName(ExtendedResourceAnnotation)], FeatureLabelNs)
+
+	// figure out which resources to remove
+	for _, resource := range oldResources {
+		if _, ok := n.Status.Capacity[api.ResourceName(resource)]; ok {
+			// check if the ext resource is still needed
+			if _, extResNeeded := extendedResources[resource]; !extResNeeded {
+				patches = append(patches, apihelper.NewJsonPatch("remove", "/status/capacity", resource, ""))
+				patches = append(patches, apihelper.NewJsonPatch("remove", "/status/allocatable", resource, ""))
+			}
+		}
+	}
+
+	// figure out which resources to replace and which to add
+	for resource, value := range extendedResources {
+		// check if the extended resource already exists with the same capacity in the node
+		if quantity, ok := n.Status.Capacity[api.ResourceName(resource)]; ok {
+			val, _ := quantity.AsInt64()
+			if strconv.FormatInt(val, 10) != value {
+				patches = append(patches, apihelper.NewJsonPatch("replace", "/status/capacity", resource, value))
+				patches = append(patches, apihelper.NewJsonPatch("replace", "/status/allocatable", resource, value))
+			}
+		} else {
+			patches = append(patches, apihelper.NewJsonPatch("add", "/status/capacity", resource, value))
+			// "allocatable" gets added implicitly after adding to capacity
+		}
+	}
+
+	return patches
+}
+
+// addNs adds a namespace if one isn't already found from src string
+func addNs(src string, nsToAdd string) string {
+	if strings.Contains(src, "/") {
+		return src
+	}
+	return path.Join(nsToAdd, src)
+}
+
+// splitNs splits a name into its namespace and name parts
+func splitNs(fullname string) (string, string) {
+	split := strings.SplitN(fullname, "/", 2)
+	if len(split) == 2 {
+		return split[0], split[1]
+	}
+	return "", fullname
+}
+
+// stringToNsNames is a helper for converting a string of comma-separated names
+// into a slice of fully namespaced names
+func stringToNsNames(cslist, ns string) []string {
+	var names []string
+	if cslist != "" {
+		names = strings.Split(cslist, ",")
+		for i, name := range names {
+			// Expect that names may omit the ns part
+			names[i] = addNs(name, ns)
+		}
+	}
+	return names
+}
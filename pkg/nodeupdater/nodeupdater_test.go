@@ -0,0 +1,329 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeupdater
+
+import (
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	"sigs.k8s.io/node-feature-discovery/pkg/apihelper"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+)
+
+const (
+	mockNodeName = "mock-node"
+)
+
+func newMockNode() *api.Node {
+	n := api.Node{}
+	n.Name = mockNodeName
+	n.Labels = map[string]string{}
+	n.Annotations = map[string]string{}
+	n.Status.Capacity = api.ResourceList{}
+	return &n
+}
+
+func newMockUpdater(apihelper apihelper.APIHelpers) *Updater {
+	return &Updater{APIHelper: apihelper, AnnotationNs: AnnotationNsBase}
+}
+
+func TestAddingExtResources(t *testing.T) {
+	Convey("When adding extended resources", t, func() {
+		mockUpdater := newMockUpdater(nil)
+		Convey("When there are no matching labels", func() {
+			mockNode := newMockNode()
+			mockResourceLabels := ExtendedResources{}
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(len(patches), ShouldEqual, 0)
+		})
+
+		Convey("When there are matching labels", func() {
+			mockNode := newMockNode()
+			mockResourceLabels := ExtendedResources{"feature-1": "1", "feature-2": "2"}
+			expectedPatches := []apihelper.JsonPatch{
+				apihelper.NewJsonPatch("add", "/status/capacity", "feature-1", "1"),
+				apihelper.NewJsonPatch("add", "/status/capacity", "feature-2", "2"),
+			}
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(sortJsonPatches(patches), ShouldResemble, sortJsonPatches(expectedPatches))
+		})
+
+		Convey("When the resource already exists", func() {
+			mockNode := newMockNode()
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
+			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-1": "1"}
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(len(patches), ShouldEqual, 0)
+		})
+
+		Convey("When the resource already exists but its capacity has changed", func() {
+			mockNode := newMockNode()
+			mockNode.Status.Capacity[api.ResourceName("feature-1")] = *resource.NewQuantity(2, resource.BinarySI)
+			mockResourceLabels := ExtendedResources{"feature-1": "1"}
+			expectedPatches := []apihelper.JsonPatch{
+				apihelper.NewJsonPatch("replace", "/status/capacity", "feature-1", "1"),
+				apihelper.NewJsonPatch("replace", "/status/allocatable", "feature-1", "1"),
+			}
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(sortJsonPatches(patches), ShouldResemble, sortJsonPatches(expectedPatches))
+		})
+	})
+}
+
+func TestRemovingExtResources(t *testing.T) {
+	Convey("When removing extended resources", t, func() {
+		mockUpdater := newMockUpdater(nil)
+		Convey("When none are removed", func() {
+			mockNode := newMockNode()
+			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-1": "1", FeatureLabelNs + "/feature-2": "2"}
+			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-1,feature-2"
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(len(patches), ShouldEqual, 0)
+		})
+		Convey("When the related label is gone", func() {
+			mockNode := newMockNode()
+			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-4": "", FeatureLabelNs + "/feature-2": "2"}
+			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-4,feature-2"
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-4")] = *resource.NewQuantity(4, resource.BinarySI)
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(len(patches), ShouldBeGreaterThan, 0)
+		})
+		Convey("When the extended resource is no longer wanted", func() {
+			mockNode := newMockNode()
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-1")] = *resource.NewQuantity(1, resource.BinarySI)
+			mockNode.Status.Capacity[api.ResourceName(FeatureLabelNs+"/feature-2")] = *resource.NewQuantity(2, resource.BinarySI)
+			mockResourceLabels := ExtendedResources{FeatureLabelNs + "/feature-2": "2"}
+			mockNode.Annotations[AnnotationNsBase+"/extended-resources"] = "feature-1,feature-2"
+			patches := mockUpdater.createExtendedResourcePatches(mockNode, mockResourceLabels)
+			So(len(patches), ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestCreatePatches(t *testing.T) {
+	Convey("When creating JSON patches", t, func() {
+		existingItems := map[string]string{"key-1": "val-1", "key-2": "val-2", "key-3": "val-3"}
+		jsonPath := "/root"
+
+		Convey("When when there are neither itmes to remoe nor to add or update", func() {
+			p := createPatches([]string{"foo", "bar"}, existingItems, map[string]string{}, jsonPath)
+			So(len(p), ShouldEqual, 0)
+		})
+
+		Convey("When when there are itmes to remoe but none to add or update", func() {
+			p := createPatches([]string{"key-2", "key-3", "foo"}, existingItems, map[string]string{}, jsonPath)
+			expected := []apihelper.JsonPatch{
+				apihelper.NewJsonPatch("remove", jsonPath, "key-2", ""),
+				apihelper.NewJsonPatch("remove", jsonPath, "key-3", ""),
+			}
+			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
+		})
+
+		Convey("When when there are no itmes to remove but new items to add", func() {
+			newItems := map[string]string{"new-key": "new-val", "key-1": "new-1"}
+			p := createPatches([]string{"key-1"}, existingItems, newItems, jsonPath)
+			expected := []apihelper.JsonPatch{
+				apihelper.NewJsonPatch("add", jsonPath, "new-key", newItems["new-key"]),
+				apihelper.NewJsonPatch("replace", jsonPath, "key-1", newItems["key-1"]),
+			}
+			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
+		})
+
+		Convey("When when there are items to remove add and update", func() {
+			newItems := map[string]string{"new-key": "new-val", "key-2": "new-2", "key-4": "val-4"}
+			p := createPatches([]string{"key-1", "key-2", "key-3", "foo"}, existingItems, newItems, jsonPath)
+			expected := []apihelper.JsonPatch{
+				apihelper.NewJsonPatch("add", jsonPath, "new-key", newItems["new-key"]),
+				apihelper.NewJsonPatch("add", jsonPath, "key-4", newItems["key-4"]),
+				apihelper.NewJsonPatch("replace", jsonPath, "key-2", newItems["key-2"]),
+				apihelper.NewJsonPatch("remove", jsonPath, "key-1", ""),
+				apihelper.NewJsonPatch("remove", jsonPath, "key-3", ""),
+			}
+			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches(expected))
+		})
+	})
+}
+
+func TestRemoveLabelsWithPrefix(t *testing.T) {
+	Convey("When removing labels", t, func() {
+		n := &api.Node{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Labels: map[string]string{
+					"single-label": "123",
+					"multiple_A":   "a",
+					"multiple_B":   "b",
+				},
+			},
+		}
+
+		Convey("a unique label should be removed", func() {
+			p := removeLabelsWithPrefix(n, "single")
+			So(p, ShouldResemble, []apihelper.JsonPatch{apihelper.NewJsonPatch("remove", "/metadata/labels", "single-label", "")})
+		})
+
+		Convey("a non-unique search string should remove all matching keys", func() {
+			p := removeLabelsWithPrefix(n, "multiple")
+			So(sortJsonPatches(p), ShouldResemble, sortJsonPatches([]apihelper.JsonPatch{
+				apihelper.NewJsonPatch("remove", "/metadata/labels", "multiple_A", ""),
+				apihelper.NewJsonPatch("remove", "/metadata/labels", "multiple_B", ""),
+			}))
+		})
+
+		Convey("a search string with no matches should not alter labels", func() {
+			removeLabelsWithPrefix(n, "unique")
+			So(n.Labels, ShouldContainKey, "single-label")
+			So(n.Labels, ShouldContainKey, "multiple_A")
+			So(n.Labels, ShouldContainKey, "multiple_B")
+			So(len(n.Labels), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestNodeFeatures(t *testing.T) {
+	Convey("When creating features from the node object", t, func() {
+		mockUpdater := newMockUpdater(nil)
+		mockNode := newMockNode()
+		mockNode.Labels["topology.kubernetes.io/zone"] = "zone-1"
+		mockNode.Labels[FeatureLabelNs+"/nfd-feature"] = "true"
+		mockNode.Labels[FeatureLabelNs+"/other-feature"] = "true"
+		mockNode.Labels["sub."+ProfileLabelNs+"/profile"] = "true"
+		mockNode.Labels["vendor.io/nfd-feature"] = "true"
+		mockNode.Labels["vendor.io/other-feature"] = "true"
+		mockNode.Annotations[AnnotationNsBase+"/feature-labels"] = "nfd-feature,vendor.io/nfd-feature"
+		mockNode.Annotations["my-annotation"] = "my-val"
+		mockNode.Status.NodeInfo.Architecture = "amd64"
+		mockNode.Status.NodeInfo.KubeletVersion = "v1.24.2"
+		mockNode.Status.Capacity[api.ResourceMemory] = resource.MustParse("16Gi")
+		mockNode.Status.Allocatable = api.ResourceList{api.ResourceCPU: resource.MustParse("3500m")}
+
+		f := mockUpdater.NodeFeatures(mockNode)
+
+		Convey("Labels owned by NFD should be excluded", func() {
+			So(f.Values[nfdv1alpha1.NodeLabelFeature].Elements, ShouldResemble, map[string]string{
+				"topology.kubernetes.io/zone": "zone-1",
+				"vendor.io/other-feature":     "true",
+			})
+		})
+		Convey("NFD annotations should be excluded", func() {
+			So(f.Values[nfdv1alpha1.NodeAnnotationFeature].Elements, ShouldResemble, map[string]string{"my-annotation": "my-val"})
+		})
+		Convey("Node info should be available", func() {
+			So(f.Values[nfdv1alpha1.NodeInfoFeature].Elements["architecture"], ShouldEqual, "amd64")
+			So(f.Values[nfdv1alpha1.NodeInfoFeature].Elements["kubeletVersion"], ShouldEqual, "v1.24.2")
+		})
+		Convey("Capacity and allocatable should be available", func() {
+			So(f.Values[nfdv1alpha1.NodeCapacityFeature].Elements, ShouldResemble, map[string]string{"memory": "16Gi"})
+			So(f.Values[nfdv1alpha1.NodeAllocatableFeature].Elements, ShouldResemble, map[string]string{"cpu": "3500m"})
+		})
+	})
+}
+
+func sortJsonPatches(p []apihelper.JsonPatch) []apihelper.JsonPatch {
+	sort.Slice(p, func(i, j int) bool { return p[i].Path < p[j].Path })
+	return p
+}
+
+func TestInstanceAnnotationNs(t *testing.T) {
+	Convey("When determining the annotation namespace of an instance", t, func() {
+		Convey("The default instance should use the base namespace", func() {
+			ns, err := InstanceAnnotationNs("")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, AnnotationNsBase)
+		})
+		Convey("A named instance should use a prefixed namespace", func() {
+			ns, err := InstanceAnnotationNs("foo")
+			So(err, ShouldBeNil)
+			So(ns, ShouldEqual, "foo."+AnnotationNsBase)
+		})
+		Convey("An invalid instance name should be rejected", func() {
+			_, err := InstanceAnnotationNs("foo/")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEvaluateRules(t *testing.T) {
+	Convey("When evaluating NodeFeatureRules", t, func() {
+		mockAPIHelper := new(apihelper.MockAPIHelpers)
+		mockUpdater := newMockUpdater(mockAPIHelper)
+		mockClient := &k8sclient.Clientset{}
+		mockNode := newMockNode()
+		mockNode.Labels["topology.kubernetes.io/zone"] = "zone-1"
+		mockAPIHelper.On("GetClient").Return(mockClient, nil)
+		mockAPIHelper.On("GetNode", mockClient, mockNodeName).Return(mockNode, nil)
+
+		zoneRule := func(name, zone string) *nfdv1alpha1.NodeFeatureRule {
+			return &nfdv1alpha1.NodeFeatureRule{
+				ObjectMeta: meta_v1.ObjectMeta{Name: name},
+				Spec: nfdv1alpha1.NodeFeatureRuleSpec{
+					Rules: []nfdv1alpha1.Rule{{
+						Name:   name,
+						Labels: map[string]string{name: "true"},
+						MatchFeatures: nfdv1alpha1.FeatureMatcher{{
+							Feature: nfdv1alpha1.NodeDomain + "." + nfdv1alpha1.NodeLabelFeature,
+							MatchExpressions: nfdv1alpha1.MatchExpressionSet{
+								"topology.kubernetes.io/zone": nfdv1alpha1.MustCreateMatchExpression(nfdv1alpha1.MatchIn, zone),
+							},
+						}},
+					}},
+				},
+			}
+		}
+
+		features := map[string]*feature.DomainFeatures{
+			// Node features must never be taken from the input
+			nfdv1alpha1.NodeDomain: feature.NewDomainFeatures(),
+		}
+		mockUpdater.AddNodeFeatures(features, mockNodeName)
+
+		labels := NewRuleCache().EvaluateRules(mockNodeName, []*nfdv1alpha1.NodeFeatureRule{zoneRule("rule-1", "zone-1"), zoneRule("rule-2", "zone-2")}, features)
+
+		Convey("Only labels of matching rules should be created", func() {
+			So(labels, ShouldResemble, Labels{"rule-1": "true"})
+		})
+
+		Convey("Compiled rules should be cached per object generation", func() {
+			cache := NewRuleCache()
+			obj := zoneRule("rule-1", "zone-1")
+			obj.UID = "uid-1"
+			obj.Generation = 1
+			obj.Spec.Rules[0].LabelsTemplate = "zone={{ range .node.label }}{{ .Value }}{{ end }}"
+
+			cache.Update(obj)
+			rules := cache.rules(obj)
+			So(rules[0], ShouldNotEqual, &obj.Spec.Rules[0])
+			So(cache.rules(obj)[0], ShouldEqual, rules[0])
+			So(cache.EvaluateRules(mockNodeName, []*nfdv1alpha1.NodeFeatureRule{obj}, features), ShouldResemble, Labels{"rule-1": "true", "zone": "zone-1"})
+
+			obj = obj.DeepCopy()
+			obj.Generation = 2
+			So(cache.rules(obj)[0], ShouldNotEqual, rules[0])
+
+			cache.Delete(obj)
+			So(cache.entries, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeupdater
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+)
+
+// AddNodeFeatures injects the features of a node object into a set of
+// features for NodeFeatureRules to match against. The node domain is reserved
+// and never taken from the input features.
+func (u *Updater) AddNodeFeatures(features map[string]*feature.DomainFeatures, nodeName string) {
+	delete(features, nfdv1alpha1.NodeDomain)
+	if node, err := u.GetNode(nodeName); err != nil {
+		klog.Errorf("failed to get node %q, node features will not be available for NodeFeatureRules: %v", nodeName, err)
+	} else {
+		features[nfdv1alpha1.NodeDomain] = u.NodeFeatures(node)
+	}
+}
+
+// RuleCache holds compiled copies of the rules of NodeFeatureRule objects so
+// that their templates and CEL expressions are not re-compiled on every
+// evaluation. The rules are indexed by the UID and generation of the object.
+type RuleCache struct {
+	sync.Mutex
+	entries map[types.UID]compiledRules
+}
+
+type compiledRules struct {
+	generation int64
+	// rules are the compiled rules of the object, nil for rules that failed
+	// to compile
+	rules []*nfdv1alpha1.Rule
+}
+
+// NewRuleCache creates a new, empty RuleCache.
+func NewRuleCache() *RuleCache {
+	return &RuleCache{entries: make(map[types.UID]compiledRules)}
+}
+
+// Update compiles the rules of a NodeFeatureRule object unless the same
+// generation of the object is already cached.
+func (c *RuleCache) Update(obj *nfdv1alpha1.NodeFeatureRule) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[obj.UID]; !ok || e.generation != obj.Generation {
+		c.entries[obj.UID] = compileRules(obj)
+	}
+}
+
+// Delete drops the compiled rules of a NodeFeatureRule object.
+func (c *RuleCache) Delete(obj *nfdv1alpha1.NodeFeatureRule) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, obj.UID)
+}
+
+// rules returns the compiled rules of a NodeFeatureRule object, compiling
+// them if they are not cached. Rules that failed to compile are returned as
+// uncompiled copies, which may be modified by the caller.
+func (c *RuleCache) rules(obj *nfdv1alpha1.NodeFeatureRule) []*nfdv1alpha1.Rule {
+	var compiled []*nfdv1alpha1.Rule
+	if c != nil {
+		c.Lock()
+		e, ok := c.entries[obj.UID]
+		if !ok || e.generation != obj.Generation {
+			e = compileRules(obj)
+			c.entries[obj.UID] = e
+		}
+		c.Unlock()
+		compiled = e.rules
+	}
+
+	ret := make([]*nfdv1alpha1.Rule, len(obj.Spec.Rules))
+	for i := range obj.Spec.Rules {
+		if i < len(compiled) && compiled[i] != nil {
+			ret[i] = compiled[i]
+		} else {
+			// Copy the rule as the objects from the lister must not be modified
+			rule := obj.Spec.Rules[i]
+			ret[i] = &rule
+		}
+	}
+	return ret
+}
+
+// compileRules compiles copies of the rules of a NodeFeatureRule object.
+func compileRules(obj *nfdv1alpha1.NodeFeatureRule) compiledRules {
+	ret := compiledRules{generation: obj.Generation, rules: make([]*nfdv1alpha1.Rule, len(obj.Spec.Rules))}
+	for i := range obj.Spec.Rules {
+		rule := obj.Spec.Rules[i].DeepCopy()
+		if err := rule.Validate(); err != nil {
+			// The error is reported when the rule is evaluated
+			klog.V(2).Infof("failed to compile rule %q of NodeFeatureRule %q: %v", rule.Name, obj.Name, err)
+			continue
+		}
+		ret.rules[i] = rule
+	}
+	return ret
+}
+
+// EvaluateRules executes the rules of a set of NodeFeatureRule objects
+// against the features of a node and returns the resulting labels. Rules are
+// evaluated after the rules they depend on. The rule objects are not modified.
+// The compiled rules are taken from the cache, which may be nil.
+func (c *RuleCache) EvaluateRules(nodeName string, ruleSpecs []*nfdv1alpha1.NodeFeatureRule, nodeFeatures map[string]*feature.DomainFeatures) Labels {
+	l := make(Labels)
+
+	ruleSpecs = append([]*nfdv1alpha1.NodeFeatureRule{}, ruleSpecs...)
+	sort.Slice(ruleSpecs, func(i, j int) bool {
+		return ruleSpecs[i].Name < ruleSpecs[j].Name
+	})
+
+	// Collect the rules of all rule CRs
+	rules := []*nfdv1alpha1.Rule{}
+	ruleOwners := []*nfdv1alpha1.NodeFeatureRule{}
+	for _, spec := range ruleSpecs {
+		switch {
+		case klog.V(3).Enabled():
+			h := fmt.Sprintf("executing NodeFeatureRule %q:", spec.ObjectMeta.Name)
+			utils.KlogDump(3, h, "  ", spec.Spec)
+		case klog.V(1).Enabled():
+			klog.Infof("executing NodeFeatureRule %q", spec.ObjectMeta.Name)
+		}
+		for _, rule := range c.rules(spec) {
+			rules = append(rules, rule)
+			ruleOwners = append(ruleOwners, spec)
+		}
+	}
+
+	// Evaluate rules after the rules they depend on
+	order, err := nfdv1alpha1.OrderRules(rules)
+	if err != nil {
+		klog.Errorf("failed to resolve dependencies of NodeFeatureRules, skipping affected rules: %v", err)
+	}
+
+	// Vars are private to the NodeFeatureRule object unless exported
+	backrefs := nfdv1alpha1.NewBackrefScopes()
+	evalCtx := nfdv1alpha1.NewEvalContext()
+
+	for _, i := range order {
+		rule := rules[i]
+		owner := ruleOwners[i]
+		features := backrefs.Features(nodeFeatures, owner.Name)
+
+		var ruleOut nfdv1alpha1.RuleOutput
+		var err error
+		if klog.V(3).Enabled() {
+			var trace *nfdv1alpha1.RuleTrace
+			ruleOut, trace, err = evalCtx.ExecuteWithTrace(rule, features)
+			klog.Infof("evaluation trace of NodeFeatureRule %q for node %q:\n%s", owner.Name, nodeName, trace)
+		} else {
+			ruleOut, err = evalCtx.Execute(rule, features)
+		}
+		if err != nil {
+			klog.Errorf("failed to process Rule %q: %v", rule.Name, err)
+			continue
+		}
+
+		for k, v := range ruleOut.Labels {
+			l[k] = v
+		}
+
+		// Feed back rule output for subsequent rules to match
+		if err := backrefs.Add(owner.Name, ruleOut, owner.Spec.ExportVars); err != nil {
+			klog.Warningf("node %q: %v", nodeName, err)
+		}
+	}
+
+	return l
+}
@@ -39,6 +39,11 @@ func (a *RegexpVal) Set(val string) error {
 	return err
 }
 
+// MarshalJSON implements the Marshaler interface from "encoding/json"
+func (a *RegexpVal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
 // UnmarshalJSON implements the Unmarshaler interface from "encoding/json"
 func (a *RegexpVal) UnmarshalJSON(data []byte) error {
 	var v interface{}
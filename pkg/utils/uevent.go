@@ -0,0 +1,64 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Uevent is a kernel uevent, notifying about a change in a device.
+type Uevent struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	Env       map[string]string
+}
+
+// UeventListener is a source of kernel uevents.
+type UeventListener interface {
+	// Events returns a channel delivering the received uevents. The channel
+	// is closed when the listener stops.
+	Events() <-chan Uevent
+	// Close stops the listener.
+	Close() error
+}
+
+// ParseUevent parses a uevent message sent by the kernel. The message
+// consists of a "<action>@<devpath>" header followed by KEY=VALUE pairs, all
+// separated by NUL characters.
+func ParseUevent(msg []byte) (*Uevent, error) {
+	fields := bytes.Split(bytes.TrimRight(msg, "\x00"), []byte{0})
+
+	header := strings.SplitN(string(fields[0]), "@", 2)
+	if len(header) != 2 || header[0] == "" {
+		return nil, fmt.Errorf("invalid uevent header %q", fields[0])
+	}
+
+	e := &Uevent{Action: header[0], DevPath: header[1], Env: make(map[string]string, len(fields)-1)}
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(string(f), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		e.Env[kv[0]] = kv[1]
+	}
+	e.Subsystem = e.Env["SUBSYSTEM"]
+
+	return e, nil
+}
@@ -0,0 +1,119 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// ueventGroup is the netlink multicast group of uevents sent by the kernel
+const ueventGroup = 1
+
+// netlinkUeventListener receives kernel uevents from a netlink socket.
+type netlinkUeventListener struct {
+	fd        int
+	events    chan Uevent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewUeventListener creates a new listener for kernel uevents.
+func NewUeventListener() (UeventListener, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create netlink socket: %w", err)
+	}
+
+	// Use a receive timeout so that the receiver notices when it is closed
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to set netlink socket timeout: %w", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventGroup}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	l := &netlinkUeventListener{
+		fd:     fd,
+		events: make(chan Uevent),
+		done:   make(chan struct{}),
+	}
+	go l.receive()
+
+	return l, nil
+}
+
+// Events method of the UeventListener interface
+func (l *netlinkUeventListener) Events() <-chan Uevent { return l.events }
+
+// Close method of the UeventListener interface
+func (l *netlinkUeventListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *netlinkUeventListener) receive() {
+	defer close(l.events)
+	defer syscall.Close(l.fd)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(l.fd, buf, 0)
+
+		select {
+		case <-l.done:
+			klog.V(1).Infof("uevent listener closed")
+			return
+		default:
+		}
+
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN, syscall.EINTR:
+			case syscall.ENOBUFS:
+				klog.Warningf("kernel uevents lost: receive buffer overrun")
+			default:
+				klog.Errorf("failed to receive kernel uevents: %v", err)
+				return
+			}
+			continue
+		}
+
+		e, err := ParseUevent(buf[:n])
+		if err != nil {
+			klog.V(3).Infof("ignoring uevent: %v", err)
+			continue
+		}
+
+		select {
+		case l.events <- *e:
+		case <-l.done:
+			klog.V(1).Infof("uevent listener closed")
+			return
+		}
+	}
+}
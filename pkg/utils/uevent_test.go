@@ -0,0 +1,56 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUevent(t *testing.T) {
+	msg := "add@/devices/pci0000:00/0000:00:1c.0/0000:3b:00.0\x00" +
+		"ACTION=add\x00" +
+		"DEVPATH=/devices/pci0000:00/0000:00:1c.0/0000:3b:00.0\x00" +
+		"SUBSYSTEM=pci\x00" +
+		"PCI_ID=15B3:1017\x00" +
+		"SEQNUM=4242\x00"
+
+	e, err := ParseUevent([]byte(msg))
+	if err != nil {
+		t.Fatalf("failed to parse uevent: %v", err)
+	}
+	expected := &Uevent{
+		Action:    "add",
+		DevPath:   "/devices/pci0000:00/0000:00:1c.0/0000:3b:00.0",
+		Subsystem: "pci",
+		Env: map[string]string{
+			"ACTION":    "add",
+			"DEVPATH":   "/devices/pci0000:00/0000:00:1c.0/0000:3b:00.0",
+			"SUBSYSTEM": "pci",
+			"PCI_ID":    "15B3:1017",
+			"SEQNUM":    "4242",
+		},
+	}
+	if !reflect.DeepEqual(e, expected) {
+		t.Errorf("unexpected uevent, expected %v, got %v", expected, e)
+	}
+
+	// Messages from udev have a different header
+	if _, err := ParseUevent([]byte("libudev\x00\xfe\xed\xca\xfe")); err == nil {
+		t.Errorf("parsing an udev message should have failed")
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldError is an error in a specific field of a structured (YAML or JSON)
+// document.
+type FieldError struct {
+	// Path of the field, e.g. "sources.custom[1].matchFeatures[0]"
+	Path string
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// WrapFieldError prefixes the path of a FieldError with the path of its parent
+// field. Other errors are wrapped in a new FieldError pointing to the parent.
+func WrapFieldError(parent string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if fe, ok := err.(*FieldError); ok {
+		return &FieldError{Path: JoinFieldPath(parent, fe.Path), Err: fe.Err}
+	}
+	return &FieldError{Path: parent, Err: err}
+}
+
+// JoinFieldPath joins two field paths.
+func JoinFieldPath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+var (
+	unknownFieldRe = regexp.MustCompile(`unknown field "([^"]*)"`)
+	yamlLineRe     = regexp.MustCompile(`yaml: line (\d+): `)
+	fieldPathRe    = regexp.MustCompile(`[^.\[\]]+|\[\d+\]`)
+)
+
+// UnmarshalYAMLStrict parses YAML (or JSON) data like yaml.UnmarshalStrict.
+// Unknown fields are reported as FieldErrors.
+//
+// NOTE: strictness does not extend to types implementing json.Unmarshaler.
+func UnmarshalYAMLStrict(data []byte, v interface{}) error {
+	err := yaml.UnmarshalStrict(data, v)
+	if err == nil {
+		return nil
+	}
+	if m := unknownFieldRe.FindStringSubmatch(err.Error()); m != nil {
+		return &FieldError{Path: m[1], Err: errors.New("unknown field")}
+	}
+	return err
+}
+
+// FormatYAMLError formats an error encountered when parsing or validating a
+// YAML document. The message is prefixed with the file name and, if it can be
+// determined, the line number of the error.
+func FormatYAMLError(file string, data []byte, err error) string {
+	msg := err.Error()
+
+	line := 0
+	if fe := (*FieldError)(nil); errors.As(err, &fe) && fe.Path != "" {
+		line = YAMLFieldLine(data, fe.Path)
+	} else if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		msg = strings.Replace(msg, m[0], "yaml: ", 1)
+	}
+
+	if line > 0 {
+		return fmt.Sprintf("%s:%d: %s", file, line, msg)
+	}
+	return fmt.Sprintf("%s: %s", file, msg)
+}
+
+// YAMLFieldLine returns the line number of a field in a YAML document. The
+// field is specified as a path of the form "a.b[1].c". If the last element of
+// the path is not found, it is searched for in the whole subtree of its
+// parent. If no match is found, the line of the closest parent is returned.
+// Zero is returned if the document cannot be parsed.
+func YAMLFieldLine(data []byte, path string) int {
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, doc); err != nil || len(doc.Content) == 0 {
+		return 0
+	}
+
+	node := doc.Content[0]
+	elems := fieldPathRe.FindAllString(path, -1)
+	for i, e := range elems {
+		var next *yamlv3.Node
+		if strings.HasPrefix(e, "[") {
+			idx, _ := strconv.Atoi(e[1 : len(e)-1])
+			if node.Kind == yamlv3.SequenceNode && idx < len(node.Content) {
+				next = node.Content[idx]
+			}
+		} else if node.Kind == yamlv3.MappingNode {
+			next = yamlMapKey(node, e)
+		}
+
+		if next == nil {
+			if i == len(elems)-1 && !strings.HasPrefix(e, "[") {
+				if n := yamlFindKey(node, e); n != nil {
+					return n.Line
+				}
+			}
+			break
+		}
+		node = next
+	}
+	return node.Line
+}
+
+// yamlMapKey returns the node of the given key of a mapping node. Matching of
+// the key is case-insensitive, similar to encoding/json. When the value is a
+// collection the key node is returned, otherwise the value node.
+func yamlMapKey(node *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			if v := node.Content[i+1]; v.Kind == yamlv3.MappingNode || v.Kind == yamlv3.SequenceNode {
+				return v
+			}
+			return node.Content[i]
+		}
+	}
+	return nil
+}
+
+// yamlFindKey searches a YAML subtree for a key, depth-first.
+func yamlFindKey(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.EqualFold(node.Content[i].Value, key) {
+				return node.Content[i]
+			}
+		}
+	}
+	for _, c := range node.Content {
+		if n := yamlFindKey(c, key); n != nil {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+const testYAML = `
+core:
+  sleepInterval: 60s
+sources:
+  custom:
+    - name: rule-1
+    - name: rule-2
+      matchFeatures:
+        - feature: cpu.cpuid
+          matchExpressions:
+            AVX: {op: Exists}
+`
+
+func TestYAMLFieldLine(t *testing.T) {
+	tcs := map[string]int{
+		"":                                   2,
+		"core":                               3,
+		"core.sleepInterval":                 3,
+		"Core.SleepInterval":                 3,
+		"core.nonExistent":                   3,
+		"sources.custom":                     6,
+		"sources.custom[1]":                  7,
+		"sources.custom[1].matchFeatures[0]": 9,
+		"sources.custom[3]":                  6,
+		"sources.custom.avx":                 11,
+	}
+	for path, expected := range tcs {
+		if line := YAMLFieldLine([]byte(testYAML), path); line != expected {
+			t.Errorf("unexpected line for %q: expected %d, got %d", path, expected, line)
+		}
+	}
+
+	if line := YAMLFieldLine([]byte("foo: [bar"), "foo"); line != 0 {
+		t.Errorf("unexpected line for invalid YAML: expected 0, got %d", line)
+	}
+}
+
+func TestUnmarshalYAMLStrict(t *testing.T) {
+	v := struct {
+		Foo string `json:"foo"`
+	}{}
+
+	if err := UnmarshalYAMLStrict([]byte("foo: bar"), &v); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := UnmarshalYAMLStrict([]byte("foo: bar\nbaz: qux"), &v)
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "baz" {
+		t.Errorf("expected unknown field error for \"baz\", got %v", err)
+	}
+
+	err = WrapFieldError("parent", WrapFieldError("[1]", err))
+	if msg := FormatYAMLError("file", []byte(testYAML), err); msg != "file:2: parent[1].baz: unknown field" {
+		t.Errorf("unexpected error message %q", msg)
+	}
+	if msg := FormatYAMLError("file", nil, errors.New("yaml: line 3: invalid")); msg != "file:3: yaml: invalid" {
+		t.Errorf("unexpected error message %q", msg)
+	}
+}
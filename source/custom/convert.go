@@ -0,0 +1,177 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package custom
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+	"sigs.k8s.io/node-feature-discovery/source/cpu"
+	"sigs.k8s.io/node-feature-discovery/source/kernel"
+	"sigs.k8s.io/node-feature-discovery/source/pci"
+	"sigs.k8s.io/node-feature-discovery/source/system"
+	"sigs.k8s.io/node-feature-discovery/source/usb"
+)
+
+// ConvertRules converts a list of custom rules into the nfdv1alpha1.Rule
+// format. Legacy rules are converted with ConvertLegacyRule, other rules are
+// returned as is. Semantic differences between the legacy and the converted
+// rules are described in the returned notes.
+func ConvertRules(rules []CustomRule) ([]nfdv1alpha1.Rule, []string) {
+	ret := make([]nfdv1alpha1.Rule, 0, len(rules))
+	notes := []string{}
+	for _, r := range rules {
+		switch {
+		case r.LegacyRule != nil:
+			rule, n := ConvertLegacyRule(r.LegacyRule)
+			ret = append(ret, rule)
+			notes = append(notes, n...)
+		case r.Rule != nil:
+			ret = append(ret, *r.Rule.Rule.DeepCopy())
+		}
+	}
+	return ret, notes
+}
+
+// ConvertLegacyRule converts a legacy custom rule into an equivalent rule in
+// the nfdv1alpha1.Rule format. The converted rule creates the same label as
+// the legacy rule. Semantic differences between the legacy and the converted
+// rule, if any, are described in the returned notes.
+func ConvertLegacyRule(r *LegacyRule) (nfdv1alpha1.Rule, []string) {
+	notes := []string{}
+
+	// Prefix non-namespaced labels with "custom-", like the legacy rule does
+	name := r.Name
+	if !strings.Contains(name, "/") {
+		name = "custom-" + name
+	}
+	value := "true"
+	if r.Value != nil {
+		value = *r.Value
+	}
+
+	ret := nfdv1alpha1.Rule{
+		Name:   r.Name,
+		Labels: map[string]string{name: value},
+	}
+
+	// Legacy matchers are evaluated as a logical OR, each of them being a
+	// logical AND over its sub-rules
+	elems := make([]nfdv1alpha1.MatchAnyElem, len(r.MatchOn))
+	for i, m := range r.MatchOn {
+		terms, n := m.convert()
+		elems[i].MatchFeatures = terms
+		for _, s := range n {
+			notes = append(notes, fmt.Sprintf("rule %q: matchOn[%d]: %s", r.Name, i, s))
+		}
+	}
+
+	switch len(elems) {
+	case 0:
+		// Legacy rule without matchers matches always, as does the converted one
+	case 1:
+		ret.MatchFeatures = elems[0].MatchFeatures
+	default:
+		ret.MatchAny = elems
+	}
+
+	return ret, notes
+}
+
+// convert converts a legacy matcher into a list of feature matcher terms.
+func (m *LegacyMatcher) convert() (nfdv1alpha1.FeatureMatcher, []string) {
+	terms := nfdv1alpha1.FeatureMatcher{}
+	notes := []string{}
+
+	addTerm := func(feature string, exprs nfdv1alpha1.MatchExpressionSet) {
+		terms = append(terms, nfdv1alpha1.FeatureMatcherTerm{
+			Feature:          feature,
+			MatchExpressions: exprs.DeepCopy(),
+		})
+	}
+
+	if m.PciID != nil {
+		addTerm("pci."+pci.DeviceFeature, m.PciID.MatchExpressionSet)
+	}
+	if m.UsbID != nil {
+		addTerm("usb."+usb.DeviceFeature, m.UsbID.MatchExpressionSet)
+	}
+	if m.LoadedKMod != nil {
+		addTerm("kernel."+kernel.LoadedModuleFeature, m.LoadedKMod.MatchExpressionSet)
+	}
+	if m.CpuID != nil {
+		addTerm("cpu."+cpu.CpuidFeature, m.CpuID.MatchExpressionSet)
+	}
+	if m.Kconfig != nil {
+		exprs, n := convertKconfigExpressions(m.Kconfig.MatchExpressionSet)
+		addTerm("kernel."+kernel.ConfigFeature, exprs)
+		notes = append(notes, n...)
+	}
+	if m.Nodename != nil {
+		addTerm("system."+system.NameFeature, nfdv1alpha1.MatchExpressionSet{
+			"nodename": m.Nodename.MatchExpression.DeepCopy(),
+		})
+	}
+
+	return terms, notes
+}
+
+// convertKconfigExpressions converts expressions of a legacy kConfig rule to
+// match the kernel.config feature. Legacy rules see kconfig options with
+// value "y" or "m" as having value "true" whereas kernel.config has the
+// original values.
+func convertKconfigExpressions(in nfdv1alpha1.MatchExpressionSet) (nfdv1alpha1.MatchExpressionSet, []string) {
+	out := make(nfdv1alpha1.MatchExpressionSet, len(in))
+	notes := []string{}
+
+	names := make([]string, 0, len(in))
+	for n := range in {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		e := in[n]
+		switch e.Op {
+		case nfdv1alpha1.MatchIn, nfdv1alpha1.MatchNotIn:
+			values := []string{}
+			for _, v := range e.Value {
+				switch v {
+				case "true":
+					values = append(values, "y", "m")
+				case "y", "m":
+					notes = append(notes, fmt.Sprintf("kConfig %q: value %q is matched against the original value of the option, the legacy rule saw enabled options as \"true\"", n, v))
+					values = append(values, v)
+				default:
+					values = append(values, v)
+				}
+			}
+			out[n] = nfdv1alpha1.MustCreateMatchExpression(e.Op, values...)
+		case nfdv1alpha1.MatchIsTrue:
+			out[n] = nfdv1alpha1.MustCreateMatchExpression(nfdv1alpha1.MatchIn, "y", "m")
+		case nfdv1alpha1.MatchInRegexp:
+			notes = append(notes, fmt.Sprintf("kConfig %q: regular expressions are matched against the original value (e.g. \"y\" or \"m\") instead of \"true\"", n))
+			out[n] = e.DeepCopy()
+		default:
+			out[n] = e.DeepCopy()
+		}
+	}
+
+	return out, notes
+}
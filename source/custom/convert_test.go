@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package custom
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
+)
+
+func TestConvertRules(t *testing.T) {
+	rules := []CustomRule{}
+	err := yaml.Unmarshal([]byte(`
+- name: "always"
+  matchOn: []
+- name: "kmod.feature"
+  matchOn:
+    - loadedKMod: ["kmod1", "kmod2"]
+- name: "vendor.io/combined"
+  value: "foo"
+  matchOn:
+    - loadedKMod: ["kmod1"]
+      pciId:
+        vendor: ["15b3"]
+      usbId:
+        vendor: ["1d6b"]
+    - cpuId: ["VMX"]
+      nodename: ["node-.*"]
+- name: "kconfig"
+  matchOn:
+    - kConfig: ["KVM", "NO_HZ=true", "GCC_VERSION=100101", "PREEMPT=y"]
+- name: "new-style"
+  labels:
+    new: "true"
+  matchFeatures:
+    - feature: cpu.cpuid
+      matchExpressions:
+        AVX: {op: Exists}
+`), &rules)
+	assert.Nil(t, err)
+
+	converted, notes := ConvertRules(rules)
+	assert.Len(t, converted, 5)
+	assert.Equal(t, []string{
+		`rule "kconfig": matchOn[0]: kConfig "PREEMPT": value "y" is matched against the original value of the option, the legacy rule saw enabled options as "true"`,
+	}, notes)
+
+	exists := nfdv1alpha1.MustCreateMatchExpression(nfdv1alpha1.MatchExists)
+	in := func(v ...string) *nfdv1alpha1.MatchExpression {
+		return nfdv1alpha1.MustCreateMatchExpression(nfdv1alpha1.MatchIn, v...)
+	}
+	// Compare the serialized form in order to ignore internal caches
+	assertEqual := func(expected, actual interface{}) {
+		e, err := json.Marshal(expected)
+		assert.Nil(t, err)
+		a, err := json.Marshal(actual)
+		assert.Nil(t, err)
+		assert.JSONEq(t, string(e), string(a))
+	}
+
+	// Rule without matchers
+	assertEqual(nfdv1alpha1.Rule{Name: "always", Labels: map[string]string{"custom-always": "true"}}, converted[0])
+
+	// Single matcher
+	assertEqual(nfdv1alpha1.Rule{
+		Name:   "kmod.feature",
+		Labels: map[string]string{"custom-kmod.feature": "true"},
+		MatchFeatures: nfdv1alpha1.FeatureMatcher{
+			{Feature: "kernel.loadedmodule", MatchExpressions: nfdv1alpha1.MatchExpressionSet{"kmod1": exists, "kmod2": exists}},
+		},
+	}, converted[1])
+
+	// Multiple matchers
+	assertEqual(nfdv1alpha1.Rule{
+		Name:   "vendor.io/combined",
+		Labels: map[string]string{"vendor.io/combined": "foo"},
+		MatchAny: []nfdv1alpha1.MatchAnyElem{
+			{MatchFeatures: nfdv1alpha1.FeatureMatcher{
+				{Feature: "pci.device", MatchExpressions: nfdv1alpha1.MatchExpressionSet{"vendor": in("15b3")}},
+				{Feature: "usb.device", MatchExpressions: nfdv1alpha1.MatchExpressionSet{"vendor": in("1d6b")}},
+				{Feature: "kernel.loadedmodule", MatchExpressions: nfdv1alpha1.MatchExpressionSet{"kmod1": exists}},
+			}},
+			{MatchFeatures: nfdv1alpha1.FeatureMatcher{
+				{Feature: "cpu.cpuid", MatchExpressions: nfdv1alpha1.MatchExpressionSet{"VMX": exists}},
+				{Feature: "system.name", MatchExpressions: nfdv1alpha1.MatchExpressionSet{
+					"nodename": nfdv1alpha1.MustCreateMatchExpression(nfdv1alpha1.MatchInRegexp, "node-.*")}},
+			}},
+		},
+	}, converted[2])
+
+	// Kconfig values are converted
+	assertEqual(nfdv1alpha1.FeatureMatcher{
+		{Feature: "kernel.config", MatchExpressions: nfdv1alpha1.MatchExpressionSet{
+			"KVM":         exists,
+			"NO_HZ":       in("y", "m"),
+			"GCC_VERSION": in("100101"),
+			"PREEMPT":     in("y"),
+		}},
+	}, converted[3].MatchFeatures)
+
+	// Rules in the new format are not modified
+	assertEqual(rules[4].Rule.Rule, converted[4])
+
+	// Check that the converted rules work
+	kernelFeatures := feature.NewDomainFeatures()
+	kernelFeatures.Keys["loadedmodule"] = feature.NewKeyFeatures("kmod1")
+	kernelFeatures.Values["config"] = feature.NewValueFeatures(map[string]string{"KVM": "m", "NO_HZ": "y", "GCC_VERSION": "100101", "PREEMPT": "y"})
+	systemFeatures := feature.NewDomainFeatures()
+	systemFeatures.Values["name"] = feature.NewValueFeatures(map[string]string{"nodename": "node-1"})
+	cpuFeatures := feature.NewDomainFeatures()
+	cpuFeatures.Keys["cpuid"] = feature.NewKeyFeatures("VMX")
+	pciFeatures := feature.NewDomainFeatures()
+	pciFeatures.Instances["device"] = feature.NewInstanceFeatures(nil)
+	usbFeatures := feature.NewDomainFeatures()
+	usbFeatures.Instances["device"] = feature.NewInstanceFeatures(nil)
+	features := feature.Features{"kernel": kernelFeatures, "system": systemFeatures, "cpu": cpuFeatures, "pci": pciFeatures, "usb": usbFeatures}
+
+	for i, expected := range []map[string]string{
+		{"custom-always": "true"},
+		nil,
+		{"vendor.io/combined": "foo"},
+		{"custom-kconfig": "true"},
+	} {
+		out, err := converted[i].Execute(features)
+		assert.Nilf(t, err, "rule %q failed", converted[i].Name)
+		assert.Equalf(t, expected, out.Labels, "rule %q failed", converted[i].Name)
+	}
+}
@@ -73,14 +73,15 @@ type customSource struct {
 }
 
 type legacyRule interface {
-	Match() (bool, error)
+	Match(map[string]*feature.DomainFeatures) (bool, error)
 }
 
 // Singleton source instance
 var (
-	src                           = customSource{config: newDefaultConfig()}
-	_   source.LabelSource        = &src
-	_   source.ConfigurableSource = &src
+	src                              = customSource{config: newDefaultConfig()}
+	_   source.LabelSource           = &src
+	_   source.ConfigurableSource    = &src
+	_   source.FeatureConsumerSource = &src
 )
 
 // Name returns the name of the feature source
@@ -108,17 +109,35 @@ func (s *customSource) Priority() int { return 10 }
 // GetLabels method of the LabelSource interface
 func (s *customSource) GetLabels() (source.FeatureLabels, error) {
 	// Get raw features from all sources
-	domainFeatures := make(map[string]*feature.DomainFeatures)
+	features := make(map[string]*feature.DomainFeatures)
 	for n, s := range source.GetAllFeatureSources() {
-		domainFeatures[n] = s.GetFeatures()
+		features[n] = s.GetFeatures()
+	}
+	return s.GetLabelsFromFeatures(features)
+}
+
+// GetLabelsFromFeatures method of the FeatureConsumerSource interface
+func (s *customSource) GetLabelsFromFeatures(features map[string]*feature.DomainFeatures) (source.FeatureLabels, error) {
+	// Copy the features map as rule backreferences are added to it
+	domainFeatures := make(map[string]*feature.DomainFeatures, len(features)+1)
+	for n, f := range features {
+		domainFeatures[n] = f
 	}
 
 	labels := source.FeatureLabels{}
 	allFeatureConfig := append(getStaticFeatureConfig(), *s.config...)
 	allFeatureConfig = append(allFeatureConfig, getDirectoryFeatureConfig()...)
 	utils.KlogDump(2, "custom features configuration:", "  ", allFeatureConfig)
+
+	// Evaluate rules after the rules they depend on
+	order, err := orderRules(allFeatureConfig)
+	if err != nil {
+		klog.Errorf("failed to resolve dependencies of custom rules, skipping affected rules: %v", err)
+	}
+
 	// Iterate over features
-	for _, rule := range allFeatureConfig {
+	for _, i := range order {
+		rule := allFeatureConfig[i]
 		ruleOut, err := rule.execute(domainFeatures)
 		if err != nil {
 			klog.Error(err)
@@ -135,6 +154,23 @@ func (s *customSource) GetLabels() (source.FeatureLabels, error) {
 	return labels, nil
 }
 
+// orderRules determines the evaluation order of custom rules. Legacy rules
+// cannot declare dependencies but other rules may depend on them.
+func orderRules(customRules []CustomRule) ([]int, error) {
+	rules := make([]*nfdv1alpha1.Rule, len(customRules))
+	for i, r := range customRules {
+		switch {
+		case r.Rule != nil:
+			rules[i] = &r.Rule.Rule
+		case r.LegacyRule != nil:
+			rules[i] = &nfdv1alpha1.Rule{Name: r.LegacyRule.Name}
+		default:
+			rules[i] = &nfdv1alpha1.Rule{}
+		}
+	}
+	return nfdv1alpha1.OrderRules(rules)
+}
+
 func (r *CustomRule) execute(features map[string]*feature.DomainFeatures) (nfdv1alpha1.RuleOutput, error) {
 	if r.LegacyRule != nil {
 		ruleOut, err := r.LegacyRule.execute(features)
@@ -145,7 +181,15 @@ func (r *CustomRule) execute(features map[string]*feature.DomainFeatures) (nfdv1
 	}
 
 	if r.Rule != nil {
-		ruleOut, err := r.Rule.Execute(features)
+		var ruleOut nfdv1alpha1.RuleOutput
+		var err error
+		if klog.V(3).Enabled() {
+			var trace *nfdv1alpha1.RuleTrace
+			ruleOut, trace, err = r.Rule.ExecuteWithTrace(features)
+			klog.Infof("evaluation trace of custom rule %q:\n%s", r.Rule.Name, trace)
+		} else {
+			ruleOut, err = r.Rule.Execute(features)
+		}
 		if err != nil {
 			return ruleOut, fmt.Errorf("failed to execute rule %s: %w", r.Rule.Name, err)
 		}
@@ -160,7 +204,7 @@ func (r *LegacyRule) execute(features map[string]*feature.DomainFeatures) (map[s
 		// Logical OR over the legacy rules
 		matched := false
 		for _, matcher := range r.MatchOn {
-			if m, err := matcher.match(); err != nil {
+			if m, err := matcher.match(features); err != nil {
 				return nil, err
 			} else if m {
 				matched = true
@@ -186,7 +230,7 @@ func (r *LegacyRule) execute(features map[string]*feature.DomainFeatures) (map[s
 	return map[string]string{name: value}, nil
 }
 
-func (m *LegacyMatcher) match() (bool, error) {
+func (m *LegacyMatcher) match(features map[string]*feature.DomainFeatures) (bool, error) {
 	allRules := []legacyRule{
 		m.PciID,
 		m.UsbID,
@@ -202,7 +246,7 @@ func (m *LegacyMatcher) match() (bool, error) {
 			if reflect.ValueOf(rule).IsNil() {
 				continue
 			}
-			if match, err := rule.Match(); err != nil {
+			if match, err := rule.Match(features); err != nil {
 				return false, err
 			} else if !match {
 				return false, nil
@@ -216,20 +260,32 @@ func (m *LegacyMatcher) match() (bool, error) {
 
 // UnmarshalJSON implements the Unmarshaler interface from "encoding/json"
 func (c *CustomRule) UnmarshalJSON(data []byte) error {
-	// Do a raw parse to determine if this is a legacy rule
+	legacy, err := isLegacyRule(data)
+	if err != nil {
+		return err
+	}
+
+	if legacy {
+		return yaml.Unmarshal(data, &c.LegacyRule)
+	}
+	return yaml.Unmarshal(data, &c.Rule)
+}
+
+// isLegacyRule does a raw parse of rule data to determine if it is a legacy
+// rule.
+func isLegacyRule(data []byte) (bool, error) {
 	raw := map[string]json.RawMessage{}
 	err := yaml.Unmarshal(data, &raw)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	for k := range raw {
 		if strings.ToLower(k) == "matchon" {
-			return yaml.Unmarshal(data, &c.LegacyRule)
+			return true, nil
 		}
 	}
-
-	return yaml.Unmarshal(data, &c.Rule)
+	return false, nil
 }
 
 // MarshalJSON implements the Marshaler interface from "encoding/json"
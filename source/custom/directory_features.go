@@ -17,6 +17,7 @@ limitations under the License.
 package custom
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -40,24 +41,51 @@ func getDirectoryFeatureConfig() []CustomRule {
 func readDir(dirName string, recursive bool) []CustomRule {
 	features := make([]CustomRule, 0)
 
+	errs := forEachRuleFile(dirName, recursive, func(fileName string) {
+		bytes, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			klog.Errorf("could not read custom config file %q, %v", fileName, err)
+			return
+		}
+		klog.V(2).Infof("custom config rules raw: %s", string(bytes))
+
+		config := &[]CustomRule{}
+		err = yaml.UnmarshalStrict(bytes, config)
+		if err != nil {
+			klog.Errorf("could not parse custom config file %q, %v", fileName, err)
+			return
+		}
+
+		features = append(features, *config...)
+	})
+	for _, err := range errs {
+		klog.Error(err)
+	}
+	return features
+}
+
+// forEachRuleFile calls fn for each non-hidden file in a directory and, if
+// recursive is true, in its 1st level subdirectories. Errors accessing the
+// directories are returned, a non-existent directory is not an error.
+func forEachRuleFile(dirName string, recursive bool, fn func(fileName string)) []error {
 	klog.V(1).Infof("getting files in %s", dirName)
 	files, err := ioutil.ReadDir(dirName)
 	if err != nil {
 		if os.IsNotExist(err) {
 			klog.V(1).Infof("custom config directory %q does not exist", dirName)
-		} else {
-			klog.Errorf("unable to access custom config directory %q, %v", dirName, err)
+			return nil
 		}
-		return features
+		return []error{fmt.Errorf("unable to access custom config directory %q, %v", dirName, err)}
 	}
 
+	errs := []error{}
 	for _, file := range files {
 		fileName := filepath.Join(dirName, file.Name())
 
 		if file.IsDir() {
 			if recursive {
 				klog.V(1).Infof("processing dir %q", fileName)
-				features = append(features, readDir(fileName, false)...)
+				errs = append(errs, forEachRuleFile(fileName, false, fn)...)
 			} else {
 				klog.V(2).Infof("skipping dir %q", fileName)
 			}
@@ -69,21 +97,7 @@ func readDir(dirName string, recursive bool) []CustomRule {
 		}
 		klog.V(2).Infof("processing file %q", fileName)
 
-		bytes, err := ioutil.ReadFile(fileName)
-		if err != nil {
-			klog.Errorf("could not read custom config file %q, %v", fileName, err)
-			continue
-		}
-		klog.V(2).Infof("custom config rules raw: %s", string(bytes))
-
-		config := &[]CustomRule{}
-		err = yaml.UnmarshalStrict(bytes, config)
-		if err != nil {
-			klog.Errorf("could not parse custom config file %q, %v", fileName, err)
-			continue
-		}
-
-		features = append(features, *config...)
+		fn(fileName)
 	}
-	return features
+	return errs
 }
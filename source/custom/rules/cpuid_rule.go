@@ -19,8 +19,8 @@ package rules
 import (
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
-	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/cpu"
 )
 
@@ -30,8 +30,8 @@ type CpuIDRule struct {
 }
 
 // Match checks if CpuId rule matches.
-func (r *CpuIDRule) Match() (bool, error) {
-	flags, ok := source.GetFeatureSource("cpu").GetFeatures().Keys[cpu.CpuidFeature]
+func (r *CpuIDRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	flags, ok := domainFeatures(features, cpu.Name).Keys[cpu.CpuidFeature]
 	if !ok {
 		return false, fmt.Errorf("cpuid information not available")
 	}
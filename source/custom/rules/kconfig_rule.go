@@ -19,6 +19,7 @@ package rules
 import (
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
 	"sigs.k8s.io/node-feature-discovery/source/kernel"
 )
@@ -29,8 +30,8 @@ type KconfigRule struct {
 }
 
 // Match compares the values of Kernel config provided and legacy config
-func (r *KconfigRule) Match() (bool, error) {
-	options := kernel.GetLegacyKconfig()
+func (r *KconfigRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	options := kernel.LegacyKconfig(domainFeatures(features, kernel.Name))
 	if options == nil {
 		return false, fmt.Errorf("kernel config options not available")
 	}
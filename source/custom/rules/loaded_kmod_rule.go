@@ -19,8 +19,8 @@ package rules
 import (
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
-	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/kernel"
 )
 
@@ -30,8 +30,8 @@ type LoadedKModRule struct {
 }
 
 // Match loaded kernel modules on provided list of kernel modules
-func (r *LoadedKModRule) Match() (bool, error) {
-	modules, ok := source.GetFeatureSource("kernel").GetFeatures().Keys[kernel.LoadedModuleFeature]
+func (r *LoadedKModRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	modules, ok := domainFeatures(features, kernel.Name).Keys[kernel.LoadedModuleFeature]
 	if !ok {
 		return false, fmt.Errorf("info about loaded modules not available")
 	}
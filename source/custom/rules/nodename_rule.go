@@ -20,8 +20,8 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
-	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/system"
 )
 
@@ -31,8 +31,8 @@ type NodenameRule struct {
 }
 
 // Match checks if node name matches the rule.
-func (r *NodenameRule) Match() (bool, error) {
-	nodeName, ok := source.GetFeatureSource("system").GetFeatures().Values[system.NameFeature].Elements["nodename"]
+func (r *NodenameRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	nodeName, ok := domainFeatures(features, system.Name).Values[system.NameFeature].Elements["nodename"]
 	if !ok || nodeName == "" {
 		return false, fmt.Errorf("node name not available")
 	}
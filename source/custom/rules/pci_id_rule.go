@@ -19,8 +19,8 @@ package rules
 import (
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
-	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/pci"
 )
 
@@ -29,8 +29,8 @@ type PciIDRule struct {
 }
 
 // Match PCI devices on provided PCI device attributes
-func (r *PciIDRule) Match() (bool, error) {
-	devs, ok := source.GetFeatureSource("pci").GetFeatures().Instances[pci.DeviceFeature]
+func (r *PciIDRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	devs, ok := domainFeatures(features, pci.Name).Instances[pci.DeviceFeature]
 	if !ok {
 		return false, fmt.Errorf("cpuid information not available")
 	}
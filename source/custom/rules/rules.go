@@ -0,0 +1,28 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import "sigs.k8s.io/node-feature-discovery/pkg/api/feature"
+
+// domainFeatures returns the features of one domain, or an empty set if the
+// features of the domain are not available.
+func domainFeatures(features map[string]*feature.DomainFeatures, domain string) *feature.DomainFeatures {
+	if f := features[domain]; f != nil {
+		return f
+	}
+	return feature.NewDomainFeatures()
+}
@@ -19,8 +19,8 @@ package rules
 import (
 	"fmt"
 
+	"sigs.k8s.io/node-feature-discovery/pkg/api/feature"
 	nfdv1alpha1 "sigs.k8s.io/node-feature-discovery/pkg/apis/nfd/v1alpha1"
-	"sigs.k8s.io/node-feature-discovery/source"
 	"sigs.k8s.io/node-feature-discovery/source/usb"
 )
 
@@ -29,8 +29,8 @@ type UsbIDRule struct {
 }
 
 // Match USB devices on provided USB device attributes
-func (r *UsbIDRule) Match() (bool, error) {
-	devs, ok := source.GetFeatureSource("usb").GetFeatures().Instances[usb.DeviceFeature]
+func (r *UsbIDRule) Match(features map[string]*feature.DomainFeatures) (bool, error) {
+	devs, ok := domainFeatures(features, usb.Name).Instances[usb.DeviceFeature]
 	if !ok {
 		return false, fmt.Errorf("usb device information not available")
 	}
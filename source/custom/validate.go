@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package custom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+	"sigs.k8s.io/node-feature-discovery/source"
+)
+
+var _ source.ValidatingSource = &src
+
+// RuleFileError is an error in a custom rule file.
+type RuleFileError struct {
+	File string
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *RuleFileError) Error() string { return fmt.Sprintf("%s: %v", e.File, e.Err) }
+
+// Unwrap returns the underlying error.
+func (e *RuleFileError) Unwrap() error { return e.Err }
+
+// ValidateConfig method of the ValidatingSource interface. Dependencies
+// between rules are not resolved as rules may depend on the rules in the
+// custom rule directory, see ValidateRules.
+func (s *customSource) ValidateConfig(data []byte) error {
+	_, err := parseRulesStrict(data)
+	return err
+}
+
+// ValidateRules strictly parses and validates the custom rule files in a
+// directory and its 1st level subdirectories, i.e. the files read from
+// Directory when discovering features. In addition, dependencies between all
+// the custom rules, including those of the effective configuration of the
+// source, are resolved. Errors in rule files are returned as RuleFileError.
+func ValidateRules(dirName string) []error {
+	allRules := append(getStaticFeatureConfig(), *src.config...)
+	errs := []error{}
+
+	dirErrs := forEachRuleFile(dirName, true, func(fileName string) {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			errs = append(errs, &RuleFileError{File: fileName, Err: err})
+			return
+		}
+		rules, err := parseRulesStrict(data)
+		if err != nil {
+			errs = append(errs, &RuleFileError{File: fileName, Err: err})
+			return
+		}
+		allRules = append(allRules, rules...)
+	})
+	errs = append(errs, dirErrs...)
+
+	if _, err := orderRules(allRules); err != nil {
+		errs = append(errs, fmt.Errorf("failed to resolve dependencies of custom rules: %v", err))
+	}
+	return errs
+}
+
+// parseRulesStrict parses a list of custom rules, failing on unknown fields,
+// and validates the rules. Errors in specific rules are returned as
+// utils.FieldError.
+func parseRulesStrict(data []byte) ([]CustomRule, error) {
+	raw := []json.RawMessage{}
+	if err := utils.UnmarshalYAMLStrict(data, &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make([]CustomRule, len(raw))
+	for i, d := range raw {
+		if err := rules[i].unmarshalStrict(d); err != nil {
+			return nil, utils.WrapFieldError(fmt.Sprintf("[%d]", i), err)
+		}
+		if rules[i].Rule != nil {
+			if err := rules[i].Rule.Validate(); err != nil {
+				return nil, utils.WrapFieldError(fmt.Sprintf("[%d]", i), err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// unmarshalStrict is a strict counterpart of UnmarshalJSON.
+func (c *CustomRule) unmarshalStrict(data []byte) error {
+	legacy, err := isLegacyRule(data)
+	if err != nil {
+		return err
+	}
+
+	if legacy {
+		return utils.UnmarshalYAMLStrict(data, &c.LegacyRule)
+	}
+	return utils.UnmarshalYAMLStrict(data, &c.Rule)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package custom
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/node-feature-discovery/pkg/utils"
+)
+
+func TestValidateConfig(t *testing.T) {
+	fieldPath := func(err error) string {
+		var fe *utils.FieldError
+		if !errors.As(err, &fe) {
+			return ""
+		}
+		return fe.Path
+	}
+
+	// Valid rules
+	assert.Nil(t, src.ValidateConfig([]byte(`
+- name: legacy
+  matchOn:
+    - loadedKMod: ["kmod1"]
+- name: rule
+  labels:
+    foo: bar
+  matchFeatures:
+    - feature: kernel.loadedmodule
+      matchExpressions:
+        kmod1: {op: Exists}
+`)))
+
+	// Unknown fields
+	err := src.ValidateConfig([]byte(`
+- name: rule
+  lables:
+    foo: bar
+`))
+	assert.Error(t, err)
+	assert.Equal(t, "[0].lables", fieldPath(err))
+
+	err = src.ValidateConfig([]byte(`
+- name: legacy
+  value: foo
+  matchOn:
+    - loadedKmods: ["kmod1"]
+`))
+	assert.Error(t, err)
+	assert.Equal(t, "[0].loadedKmods", fieldPath(err))
+
+	// Invalid rule
+	err = src.ValidateConfig([]byte(`
+- name: rule-1
+- name: rule-2
+  labelsTemplate: "{{ .foo "
+`))
+	assert.Error(t, err)
+	assert.Equal(t, "[1].labelsTemplate", fieldPath(err))
+
+	// Invalid regexp
+	err = src.ValidateConfig([]byte(`
+- name: rule
+  matchFeatures:
+    - feature: kernel.loadedmodule
+      matchExpressions:
+        kmod1: {op: InRegexp, value: ["("]}
+`))
+	assert.Error(t, err)
+}
+
+func TestValidateRules(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "subdir"), 0755))
+	writeFile := func(name, data string) {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	// Non-existent directory
+	assert.Empty(t, ValidateRules(filepath.Join(dir, "non-existent")))
+
+	// Valid rules
+	writeFile("rules-1.yaml", `
+- name: rule-1
+  vars:
+    var-1: "true"
+`)
+	writeFile("subdir/rules-2.yaml", `
+- name: rule-2
+  dependsOnVars: [var-1]
+`)
+	assert.Empty(t, ValidateRules(dir))
+
+	// Invalid rule file
+	writeFile(".hidden", "invalid")
+	writeFile("subdir/rules-3.yaml", `
+- name: rule-3
+  matchFeatures:
+    - feature: loadedmodule
+`)
+	errs := ValidateRules(dir)
+	if assert.Len(t, errs, 1) {
+		var fe *RuleFileError
+		assert.True(t, errors.As(errs[0], &fe))
+		assert.Equal(t, filepath.Join(dir, "subdir", "rules-3.yaml"), fe.File)
+	}
+	assert.Nil(t, os.Remove(filepath.Join(dir, "subdir", "rules-3.yaml")))
+
+	// Missing dependency
+	writeFile("rules-4.yaml", `
+- name: rule-4
+  dependsOn: [non-existent]
+`)
+	assert.Len(t, ValidateRules(dir), 1)
+}
@@ -160,8 +160,23 @@ func (s *kernelSource) GetFeatures() *feature.DomainFeatures {
 	return s.features
 }
 
-func GetLegacyKconfig() map[string]string {
-	return src.legacyKconfig
+// LegacyKconfig returns the "mangled" kconfig values used by legacy kConfig
+// custom rules, derived from discovered kernel features. Nil is returned if
+// the kernel configuration is not available.
+func LegacyKconfig(features *feature.DomainFeatures) map[string]string {
+	kconfig, ok := features.Values[ConfigFeature]
+	if !ok {
+		return nil
+	}
+	ret := make(map[string]string, len(kconfig.Elements))
+	for name, value := range kconfig.Elements {
+		if value == "y" || value == "m" {
+			ret[name] = "true"
+		} else {
+			ret[name] = value
+		}
+	}
+	return ret
 }
 
 func init() {
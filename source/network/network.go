@@ -158,6 +158,10 @@ func readIfaceInfo(path string) feature.InstanceFeature {
 		attrs[attrName] = strings.TrimSpace(string(data))
 	}
 
+	if addr := source.PciAddress(path); addr != "" {
+		attrs["pci_address"] = addr
+	}
+
 	return *feature.NewInstanceFeature(attrs)
 
 }
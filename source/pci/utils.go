@@ -65,6 +65,8 @@ func readPciDevInfo(devPath string) (*feature.InstanceFeature, error) {
 			attrs[attr] = attrVal
 		}
 	}
+	// The PCI address is used as a key for joining with other features
+	attrs["address"] = filepath.Base(devPath)
 	return feature.NewInstanceFeature(attrs), nil
 }
 
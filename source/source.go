@@ -66,6 +66,27 @@ type ConfigurableSource interface {
 	SetConfig(Config)
 }
 
+// ValidatingSource is an interface for a ConfigurableSource that validates
+// its configuration beyond what parsing it does
+type ValidatingSource interface {
+	ConfigurableSource
+
+	// ValidateConfig strictly parses and validates raw configuration data of
+	// the source, without changing the effective configuration
+	ValidateConfig([]byte) error
+}
+
+// FeatureConsumerSource is a LabelSource whose labels are created from the
+// features of other feature sources
+type FeatureConsumerSource interface {
+	LabelSource
+
+	// GetLabelsFromFeatures returns feature labels created from the given
+	// features of all feature sources, instead of the current features of
+	// the sources
+	GetLabelsFromFeatures(map[string]*feature.DomainFeatures) (FeatureLabels, error)
+}
+
 // SupplementalSource represents a source that does not belong to the core set
 // sources to be used in production, e.g. is deprecated, very experimental or
 // purposed for testing only.
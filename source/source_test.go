@@ -18,6 +18,8 @@ package source_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -78,3 +80,21 @@ func TestFeatureSources(t *testing.T) {
 		assert.Empty(t, (*f).Instances, msg)
 	}
 }
+
+func TestPciAddress(t *testing.T) {
+	sysfs := t.TempDir()
+	devPath := filepath.Join(sysfs, "devices/pci0000:00/0000:00:1d.0/0000:3d:00.0")
+	assert.Nil(t, os.MkdirAll(filepath.Join(devPath, "net/eth0"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(sysfs, "devices/virtual/net/lo"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(sysfs, "class/net"), 0755))
+	assert.Nil(t, os.Symlink("../../devices/pci0000:00/0000:00:1d.0/0000:3d:00.0/net/eth0", filepath.Join(sysfs, "class/net/eth0")))
+	assert.Nil(t, os.Symlink("../../devices/virtual/net/lo", filepath.Join(sysfs, "class/net/lo")))
+
+	// The nearest PCI device is returned
+	assert.Equal(t, "0000:3d:00.0", source.PciAddress(filepath.Join(sysfs, "class/net/eth0")))
+	assert.Equal(t, "0000:3d:00.0", source.PciAddress(devPath))
+
+	// Not a PCI device
+	assert.Equal(t, "", source.PciAddress(filepath.Join(sysfs, "class/net/lo")))
+	assert.Equal(t, "", source.PciAddress(filepath.Join(sysfs, "class/net/non-existent")))
+}
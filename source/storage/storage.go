@@ -120,6 +120,9 @@ func readBlockDevQueueInfo(path string) *feature.InstanceFeature {
 		}
 		attrs[attrName] = strings.TrimSpace(string(data))
 	}
+	if addr := source.PciAddress(path); addr != "" {
+		attrs["pci_address"] = addr
+	}
 	return feature.NewInstanceFeature(attrs)
 }
 
@@ -0,0 +1,46 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var pciAddressRe = regexp.MustCompile(`^[0-9a-f]{4,}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// PciAddress returns the address (e.g. 0000:3b:00.0) of the PCI device that
+// a sysfs device belongs to, or an empty string if it is not a PCI device.
+// The path is resolved and the last path element having the format of a PCI
+// address is returned, i.e. the nearest PCI device in the device hierarchy.
+// The address is used as a key for joining instance features of different
+// sources.
+func PciAddress(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+
+	elems := strings.Split(resolved, string(filepath.Separator))
+	for i := len(elems) - 1; i >= 0; i-- {
+		if pciAddressRe.MatchString(elems[i]) {
+			return elems[i]
+		}
+	}
+	return ""
+}